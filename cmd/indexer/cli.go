@@ -4,16 +4,28 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bocha-io/garnet/internal/gui"
 	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth"
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
 	"github.com/bocha-io/logger"
 	"github.com/jroimartin/gocui"
 )
 
 const maxLinesToDisplay = 37
 
+// chainView is one of the indexed chains, the UI displays one chain at a time
+type chainView struct {
+	Name     string
+	Database *data.Database
+	Filter   mudhandlers.Filter
+	// Used by Control+s, the storage is locked by the indexer so the snapshot must be written by this process
+	Snapshots *data.Snapshotter
+}
+
 type DebugUI struct {
 	done             chan (struct{})
 	ui               *gocui.Gui
@@ -25,9 +37,15 @@ type DebugUI struct {
 	searchTotalIndex int
 	searchIndex      int
 	keyPressed       string
+	// The main view shows the dead letters instead of the tables
+	showDeadLetters bool
+	chains          []chainView
+	// Changed by the key bindings and read by the update goroutines
+	currentChain int
+	chainMutex   *sync.Mutex
 }
 
-func NewDebugUI() *DebugUI {
+func NewDebugUI(chains []chainView) *DebugUI {
 	return &DebugUI{
 		done:             make(chan struct{}),
 		ui:               ui(),
@@ -39,9 +57,19 @@ func NewDebugUI() *DebugUI {
 		keyPressed:       "",
 		searchTotalIndex: 0,
 		searchIndex:      0,
+		showDeadLetters:  false,
+		chains:           chains,
+		currentChain:     0,
+		chainMutex:       &sync.Mutex{},
 	}
 }
 
+func (ui *DebugUI) chain() chainView {
+	ui.chainMutex.Lock()
+	defer ui.chainMutex.Unlock()
+	return ui.chains[ui.currentChain]
+}
+
 func findWord(input string, values []string) []int {
 	// Input must be lower case
 	a := []int{}
@@ -64,12 +92,13 @@ func (ui *DebugUI) Run() {
 	close(ui.done)
 }
 
-func (ui *DebugUI) ProcessLatestEvents(database *data.Database) {
+func (ui *DebugUI) ProcessLatestEvents() {
 	for {
 		select {
 		case <-ui.done:
 			return
 		case <-time.After(500 * time.Millisecond):
+			database := ui.chain().Database
 			ui.ui.Update(func(g *gocui.Gui) error {
 				v, err := g.View("latestevents")
 				if err != nil {
@@ -79,23 +108,14 @@ func (ui *DebugUI) ProcessLatestEvents(database *data.Database) {
 				fmt.Fprintln(v, gui.ColorMagenta("Latest Events:"))
 				fmt.Fprintln(v, strings.Repeat("─", logoWidth-logoOffsetX))
 
-				end := 0
-				start := len(database.Events) - 1
-				if start < 0 {
-					start = 0
-				}
-				if len(database.Events) >= 7 {
-					end = len(database.Events) - 7
-				}
-
-				for i := start; i > end; i-- {
+				for _, event := range database.LatestEvents(6) {
 					fmt.Fprintln(v, "----EVENT----")
 					fmt.Fprint(v, "Table:")
-					fmt.Fprintln(v, database.Events[i].Table)
+					fmt.Fprintln(v, event.Table)
 					// fmt.Fprint(v, "Row:")
-					// fmt.Fprintln(v, database.Events[i].Row)
+					// fmt.Fprintln(v, event.Row)
 					fmt.Fprint(v, "Value:")
-					fmt.Fprintln(v, database.Events[i].Value)
+					fmt.Fprintln(v, event.Value())
 				}
 
 				return nil
@@ -104,12 +124,14 @@ func (ui *DebugUI) ProcessLatestEvents(database *data.Database) {
 	}
 }
 
-func (ui *DebugUI) ProcessBlockchainInfo(database *data.Database) {
+func (ui *DebugUI) ProcessBlockchainInfo() {
 	for {
 		select {
 		case <-ui.done:
 			return
 		case <-time.After(500 * time.Millisecond):
+			chain := ui.chain()
+			database := chain.Database
 			ui.ui.Update(func(g *gocui.Gui) error {
 				v, err := g.View("blockchaininfo")
 				if err != nil {
@@ -118,20 +140,24 @@ func (ui *DebugUI) ProcessBlockchainInfo(database *data.Database) {
 				v.Clear()
 				fmt.Fprintln(v, gui.ColorMagenta("Blockchain Info:"))
 				fmt.Fprintln(v, strings.Repeat("─", logoWidth))
-				fmt.Fprintf(v, " \u26d3 ChainID: %s\n", database.ChainID)
-				fmt.Fprintf(v, " \u279a Height : %d\n", database.LastHeight)
+				info := database.Info()
+				fmt.Fprintf(v, " \u26d3 ChainID: %s (%s)\n", info.ChainID, chain.Name)
+				fmt.Fprintf(v, " \u279a Height : %d\n", info.LastHeight)
+				fmt.Fprintf(v, " \u2714 Confirmed: %d\n", info.ConfirmedHeight)
 				return nil
 			})
 		}
 	}
 }
 
-func (ui *DebugUI) ProcessIncomingData(database *data.Database) {
+func (ui *DebugUI) ProcessIncomingData() {
 	for {
 		select {
 		case <-ui.done:
 			return
 		case <-time.After(50 * time.Millisecond):
+			chain := ui.chain()
+			database := chain.Database
 			// TODO: move the search status updates to another function
 			// Update search status
 			ui.ui.Update(func(g *gocui.Gui) error {
@@ -141,15 +167,31 @@ func (ui *DebugUI) ProcessIncomingData(database *data.Database) {
 					if ui.searchTotalIndex == 0 {
 						current = 0
 					}
-					fmt.Fprintf(v, "Type to search. Control+n and Control+p to move around. Res:%d/%d", current, ui.searchTotalIndex)
+					switchChain := ""
+					if len(ui.chains) > 1 {
+						switchChain = " Control+t switches the chain."
+					}
+					fmt.Fprintf(v, "Type to search. Control+n and Control+p to move around. Control+d shows the dead letters. Control+s saves a snapshot.%s Res:%d/%d", switchChain, current, ui.searchTotalIndex)
 				}
 				return nil
 			})
 
+			if ui.keyPressed == "RETRY" {
+				eth.RetryDeadLetters(database, chain.Filter)
+			}
+
+			if ui.keyPressed == "SNAPSHOT" {
+				ui.saveSnapshot(chain)
+			}
+
 			rerender := false
-			lastUpdate := database.LastUpdate
-			if ui.dataLastUpdate != lastUpdate {
-				ui.data = database.ToStringList(debugWindowWidth - debugWindowOffset)
+			lastUpdate := database.Info().LastUpdate
+			if ui.dataLastUpdate != lastUpdate || ui.keyPressed == "TOGGLE" || ui.keyPressed == "CHAIN" {
+				if ui.showDeadLetters {
+					ui.data = database.DeadLettersToStringList()
+				} else {
+					ui.data = database.ToStringList(debugWindowWidth - debugWindowOffset)
+				}
 				ui.dataLastUpdate = lastUpdate
 				rerender = true
 			}
@@ -291,7 +333,11 @@ func (ui *DebugUI) ProcessIncomingData(database *data.Database) {
 						end = ui.yOffset + maxLinesToDisplay
 					}
 
-					fmt.Fprintln(v, gui.ColorMagenta("Tables Info:"))
+					if ui.showDeadLetters {
+						fmt.Fprintln(v, gui.ColorMagenta("Dead Letters (Control+r to retry):"))
+					} else {
+						fmt.Fprintln(v, gui.ColorMagenta("Tables Info:"))
+					}
 					fmt.Fprintln(v, strings.Repeat("─", debugWindowWidth-debugWindowOffset-1))
 					for i := ui.yOffset; i < end; i++ {
 						fmt.Fprintln(v, ui.data[i])
@@ -317,7 +363,7 @@ const (
 	logoOffsetX          = 4
 	logoHeight           = 8
 	logoWidth            = 40
-	blockchainInfoHeight = logoHeight + 5
+	blockchainInfoHeight = logoHeight + 6
 	blockchainInfoOffset = 2
 	debugWindowHeight    = 40
 	debugWindowWidth     = 120
@@ -349,6 +395,7 @@ func layout(g *gocui.Gui) error {
 		fmt.Fprintln(v, strings.Repeat("─", logoWidth))
 		fmt.Fprintln(v, "ChainID: ")
 		fmt.Fprintln(v, "Height: ")
+		fmt.Fprintln(v, "Confirmed: ")
 	}
 
 	if v, err := g.SetView("latestevents", blockchainInfoOffset, blockchainInfoHeight+2, logoWidth, debugWindowHeight); err != nil {
@@ -438,9 +485,63 @@ func (ui *DebugUI) keybindings(g *gocui.Gui) error {
 		return err
 	}
 
+	if err := g.SetKeybinding("", gocui.KeyCtrlD, gocui.ModNone, ui.controlDPressed); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding("", gocui.KeyCtrlR, gocui.ModNone, ui.controlRPressed); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding("", gocui.KeyCtrlT, gocui.ModNone, ui.controlTPressed); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding("", gocui.KeyCtrlS, gocui.ModNone, ui.controlSPressed); err != nil {
+		return err
+	}
+
 	return g.SetKeybinding("", gocui.KeyCtrlP, gocui.ModNone, ui.controlPPressed)
 }
 
+func (ui *DebugUI) controlDPressed(_ *gocui.Gui, _ *gocui.View) error {
+	ui.showDeadLetters = !ui.showDeadLetters
+	ui.yOffset = 0
+	ui.keyPressed = "TOGGLE"
+	return nil
+}
+
+func (ui *DebugUI) controlRPressed(_ *gocui.Gui, _ *gocui.View) error {
+	ui.keyPressed = "RETRY"
+	return nil
+}
+
+func (ui *DebugUI) controlTPressed(_ *gocui.Gui, _ *gocui.View) error {
+	ui.chainMutex.Lock()
+	ui.currentChain = (ui.currentChain + 1) % len(ui.chains)
+	ui.chainMutex.Unlock()
+	ui.yOffset = 0
+	ui.keyPressed = "CHAIN"
+	return nil
+}
+
+func (ui *DebugUI) controlSPressed(_ *gocui.Gui, _ *gocui.View) error {
+	ui.keyPressed = "SNAPSHOT"
+	return nil
+}
+
+// saveSnapshot writes the snapshot in the background, the result is logged
+func (ui *DebugUI) saveSnapshot(chain chainView) {
+	if chain.Snapshots == nil {
+		logger.LogError(fmt.Sprintf("[indexer] the snapshots of chain %s are disabled, use -snapshots to set their directory", chain.Name))
+		return
+	}
+	go func() {
+		if _, err := chain.Snapshots.Save(chain.Database); err != nil {
+			logger.LogError(fmt.Sprintf("[indexer] error saving the snapshot of chain %s: %s", chain.Name, err))
+		}
+	}()
+}
+
 func (ui *DebugUI) controlNPressed(_ *gocui.Gui, _ *gocui.View) error {
 	ui.keyPressed = "N"
 	return nil
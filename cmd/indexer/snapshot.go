@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth"
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+)
+
+const snapshotUsage = `usage:
+  indexer snapshot create -out file (-storage dir | -checkpoint file | -replay file)
+  indexer snapshot inspect [-tables] file...`
+
+// snapshotCommand creates and inspects the snapshot files used to bootstrap new replicas
+func snapshotCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(snapshotUsage)
+	}
+	switch args[0] {
+	case "create":
+		return createSnapshot(args[1:])
+	case "inspect":
+		return inspectSnapshots(args[1:])
+	default:
+		return fmt.Errorf("unknown snapshot command %s\n%s", args[0], snapshotUsage)
+	}
+}
+
+func createSnapshot(args []string) error {
+	flags := flag.NewFlagSet("snapshot create", flag.ContinueOnError)
+	out := flags.String("out", "", "snapshot file to write")
+	storagePath := flags.String("storage", "", "storage directory of a stopped indexer")
+	checkpointPath := flags.String("checkpoint", "", "checkpoint file")
+	replayPath := flags.String("replay", "", "file with recorded logs")
+	worlds := flags.String("worlds", "", "comma separated list of world addresses to replay, all of them if empty")
+	tables := flags.String("tables", "", "comma separated list of table names to replay, all of them if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("missing the -out file\n%s", snapshotUsage)
+	}
+
+	sources := 0
+	for _, v := range []string{*storagePath, *checkpointPath, *replayPath} {
+		if v != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of -storage, -checkpoint or -replay must be set\n%s", snapshotUsage)
+	}
+
+	database := data.NewDatabase()
+	switch {
+	case *storagePath != "":
+		storage, err := data.NewLevelDBStorage(*storagePath)
+		if err != nil {
+			return err
+		}
+		database, err = data.NewDatabaseWithStorage(storage)
+		if err != nil {
+			storage.Close()
+			return err
+		}
+		defer database.Close()
+	case *checkpointPath != "":
+		found, err := data.NewCheckpointer(*checkpointPath, 0).Load(database)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("the checkpoint %s does not exist", *checkpointPath)
+		}
+	case *replayPath != "":
+		filter := mudhandlers.Filter{Worlds: splitList(*worlds), Tables: splitList(*tables)}
+		if _, err := eth.ReplayLogs(database, filter, *replayPath); err != nil {
+			return err
+		}
+	}
+
+	if database.LastProcessedBlock() == nil {
+		return fmt.Errorf("the database is empty, there is nothing to snapshot")
+	}
+	header, err := data.WriteSnapshot(database, *out)
+	if err != nil {
+		return err
+	}
+	printSnapshotHeader(*out, header)
+	return nil
+}
+
+func printSnapshotHeader(path string, header *data.SnapshotHeader) {
+	fmt.Printf("%s\n", path)
+	fmt.Printf("  version:    %d (state %d)\n", header.Version, header.StateVersion)
+	fmt.Printf("  chain id:   %s\n", header.ChainID)
+	fmt.Printf("  block:      %d (%s)\n", header.Height, header.Hash.Hex())
+	fmt.Printf("  created at: %s\n", header.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	fmt.Printf("  contents:   %d worlds, %d tables, %d rows\n", header.Worlds, header.Tables, header.Rows)
+	fmt.Printf("  checksum:   %s\n", header.Checksum)
+}
+
+// inspectSnapshots verifies the checksum of each file, it fails if any of them is corrupted
+func inspectSnapshots(args []string) error {
+	flags := flag.NewFlagSet("snapshot inspect", flag.ContinueOnError)
+	listTables := flags.Bool("tables", false, "list the rows of each table")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("missing the snapshot file\n%s", snapshotUsage)
+	}
+
+	failed := 0
+	for _, path := range flags.Args() {
+		if !*listTables {
+			header, err := data.VerifySnapshot(path)
+			if err != nil {
+				fmt.Printf("%s\n  ERROR: %s\n", path, err)
+				failed++
+				continue
+			}
+			printSnapshotHeader(path, header)
+			fmt.Printf("  verified:   yes\n")
+			continue
+		}
+
+		header, state, err := data.ReadSnapshot(path)
+		if err != nil {
+			fmt.Printf("%s\n  ERROR: %s\n", path, err)
+			failed++
+			continue
+		}
+		printSnapshotHeader(path, header)
+		fmt.Printf("  verified:   yes\n")
+		for _, world := range state.Worlds {
+			fmt.Printf("  world %s\n", world.Address)
+			sort.Slice(world.Tables, func(i, j int) bool {
+				return world.Tables[i].Metadata.TableName < world.Tables[j].Metadata.TableName
+			})
+			for _, table := range world.Tables {
+				name := table.Metadata.TableName
+				if name == "" {
+					name = table.Metadata.TableID
+				}
+				fmt.Printf("    %-40s %d rows\n", name, len(table.Rows))
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d snapshots could not be verified", failed, flags.NArg())
+	}
+	return nil
+}
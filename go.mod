@@ -3,14 +3,18 @@ module github.com/bocha-io/garnet
 go 1.20
 
 require (
-	github.com/bocha-io/ethclient v0.0.0-20231018131853-f7b11696427b
 	github.com/bocha-io/logger v0.0.0-20230722133508-fbef5d720b58
 	github.com/ethereum/go-ethereum v1.13.4
 	github.com/jroimartin/gocui v0.5.0
+	github.com/lib/pq v1.10.9
+	github.com/syndtr/goleveldb v1.0.1-0.20210819022825-2ae1ddf74ef7
 	github.com/umbracle/ethgo v0.1.3
 	go.uber.org/zap v1.24.0
 )
 
+// Only imported by the sqlmirror tests, they need cgo and are built with -tags sqlite
+require github.com/mattn/go-sqlite3 v1.14.33
+
 require (
 	github.com/Microsoft/go-winio v0.6.1 // indirect
 	github.com/StackExchange/wmi v1.2.1 // indirect
@@ -25,6 +29,7 @@ require (
 	github.com/fsnotify/fsnotify v1.6.0 // indirect
 	github.com/go-ole/go-ole v1.2.5 // indirect
 	github.com/go-stack/stack v1.8.1 // indirect
+	github.com/golang/snappy v0.0.5-0.20220116011046-fa5810519dcb // indirect
 	github.com/google/gofuzz v1.2.0 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
@@ -0,0 +1,161 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/bocha-io/garnet/x/indexer/eth"
+)
+
+// ChainStatus is the sync status of one of the indexed chains
+type ChainStatus struct {
+	Name      string
+	ChainID   string
+	Height    uint64
+	Head      uint64
+	Lag       uint64
+	LastError error
+}
+
+// Chains runs one indexer per chain, each one with its own database and checkpoint
+type Chains struct {
+	names    []string
+	indexers map[string]*Indexer
+	mutex    *sync.Mutex
+}
+
+func NewChains() *Chains {
+	return &Chains{
+		names:    []string{},
+		indexers: map[string]*Indexer{},
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// Add registers a chain, the name is used by the queries and must be unique
+func (c *Chains) Add(name string, source eth.LogSource, database *data.Database, options Options) (*Indexer, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if name == "" {
+		return nil, fmt.Errorf("the chain name can not be empty")
+	}
+	if _, ok := c.indexers[name]; ok {
+		return nil, fmt.Errorf("the chain %s was already added", name)
+	}
+
+	idx := NewIndexer(source, database, options)
+	c.names = append(c.names, name)
+	c.indexers[name] = idx
+	return idx, nil
+}
+
+// Names returns the chain names in the order they were added
+func (c *Chains) Names() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ret := make([]string, len(c.names))
+	copy(ret, c.names)
+	return ret
+}
+
+func (c *Chains) Indexer(name string) *Indexer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.indexers[name]
+}
+
+func (c *Chains) Database(name string) *data.Database {
+	idx := c.Indexer(name)
+	if idx == nil {
+		return nil
+	}
+	return idx.Database
+}
+
+// DatabaseByChainID returns the database of the chain once its indexer got the chain id from the endpoint
+func (c *Chains) DatabaseByChainID(chainID string) *data.Database {
+	for _, name := range c.Names() {
+		if db := c.Database(name); db != nil && db.Info().ChainID == chainID {
+			return db
+		}
+	}
+	return nil
+}
+
+// World returns the world of the given chain, the chain can be its name or its chain id
+func (c *Chains) World(chain string, worldAddress string) (*data.Database, *data.World, error) {
+	db := c.Database(chain)
+	if db == nil {
+		db = c.DatabaseByChainID(chain)
+	}
+	if db == nil {
+		return nil, nil, fmt.Errorf("chain not found: %s", chain)
+	}
+
+	if world := db.FindWorld(worldAddress); world != nil {
+		return db, world, nil
+	}
+	return db, nil, fmt.Errorf("world %s not found in chain %s", worldAddress, chain)
+}
+
+// WorldByNamespace looks for the world using the namespace built by mudhelpers.Namespace
+func (c *Chains) WorldByNamespace(namespace string) (*data.Database, *data.World, error) {
+	chainID, worldAddress, err := mudhelpers.ParseNamespace(namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.World(chainID, worldAddress)
+}
+
+func (c *Chains) Status() []ChainStatus {
+	ret := []ChainStatus{}
+	for _, name := range c.Names() {
+		idx := c.Indexer(name)
+		ret = append(ret, ChainStatus{
+			Name:      name,
+			ChainID:   idx.Database.Info().ChainID,
+			Height:    idx.Height(),
+			Head:      idx.Head(),
+			Lag:       idx.Lag(),
+			LastError: idx.LastError(),
+		})
+	}
+	return ret
+}
+
+// Start runs every indexer in the background, the already started ones are stopped if one of them fails to start
+func (c *Chains) Start(ctx context.Context) error {
+	started := []*Indexer{}
+	for _, name := range c.Names() {
+		idx := c.Indexer(name)
+		if err := idx.Start(ctx); err != nil {
+			for _, v := range started {
+				v.Stop()
+			}
+			return fmt.Errorf("error starting the chain %s: %w", name, err)
+		}
+		started = append(started, idx)
+	}
+	return nil
+}
+
+func (c *Chains) Stop() {
+	for _, name := range c.Names() {
+		c.Indexer(name).Stop()
+	}
+}
+
+// Wait blocks until every indexer stops and returns their errors
+func (c *Chains) Wait() error {
+	errs := []error{}
+	for _, name := range c.Names() {
+		if err := c.Indexer(name).Wait(); err != nil {
+			errs = append(errs, fmt.Errorf("chain %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
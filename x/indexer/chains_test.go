@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/bocha-io/garnet/x/indexer/eth"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testChainOptions() Options {
+	options := DefaultOptions()
+	options.PollInterval = 5 * time.Millisecond
+	return options
+}
+
+// addTestChain indexes a chain with the game:Counter table and one row
+func addTestChain(t *testing.T, chains *Chains, name string, chainID int64) *Indexer {
+	t.Helper()
+	source := eth.NewMemorySource(chainID)
+	source.AddLogs(registerTableLog(t, 2), counterLog(t, 3, 0, 1, uint32(chainID)))
+	source.SetHead(5)
+	idx, err := chains.Add(name, source, data.NewDatabase(), testChainOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func TestChainsLookup(t *testing.T) {
+	chains := NewChains()
+	first := addTestChain(t, chains, "first", 10)
+	second := addTestChain(t, chains, "second", 20)
+	if _, err := chains.Add("first", eth.NewMemorySource(30), data.NewDatabase(), testChainOptions()); err == nil {
+		t.Fatal("the duplicated chain was added")
+	}
+	if _, err := chains.Add("", eth.NewMemorySource(30), data.NewDatabase(), testChainOptions()); err == nil {
+		t.Fatal("the chain without a name was added")
+	}
+	if names := chains.Names(); len(names) != 2 || names[0] != "first" || names[1] != "second" {
+		t.Fatalf("unexpected names %v", names)
+	}
+	if chains.Indexer("second") != second || chains.Database("first") != first.Database {
+		t.Fatal("the chains were not found by name")
+	}
+	if chains.Indexer("third") != nil || chains.Database("third") != nil {
+		t.Fatal("found a chain that was not added")
+	}
+
+	if err := chains.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the initial sync", func() bool { return first.Height() == 5 && second.Height() == 5 })
+	chains.Stop()
+	if err := chains.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chains are found by name or by chain id
+	if chains.DatabaseByChainID("20") != second.Database || chains.DatabaseByChainID("30") != nil {
+		t.Fatal("unexpected database by chain id")
+	}
+	for _, chain := range []string{"second", "20"} {
+		db, world, err := chains.World(chain, strings.ToLower(testWorld.Hex()))
+		if err != nil || db != second.Database || world == nil {
+			t.Fatalf("the world of %s was not found: %v", chain, err)
+		}
+		if value := counterValue(db, 1); value != `"value":20` {
+			t.Fatalf("unexpected value %q in %s", value, chain)
+		}
+	}
+	if db, _, err := chains.World("first", common.Address{}.Hex()); err == nil || db != first.Database {
+		t.Fatalf("found a world that does not exist: %v", err)
+	}
+	if _, _, err := chains.World("third", testWorld.Hex()); err == nil {
+		t.Fatal("found a chain that was not added")
+	}
+	if db, world, err := chains.WorldByNamespace(mudhelpers.Namespace("10", testWorld.Hex())); err != nil || db != first.Database || world == nil {
+		t.Fatalf("the world was not found by namespace: %v", err)
+	}
+	if _, _, err := chains.WorldByNamespace("invalid"); err == nil {
+		t.Fatal("the invalid namespace was parsed")
+	}
+
+	status := chains.Status()
+	if len(status) != 2 || status[1].Name != "second" || status[1].ChainID != "20" || status[1].Height != 5 || status[1].LastError != nil {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestChainsStartError(t *testing.T) {
+	chains := NewChains()
+	first := addTestChain(t, chains, "first", 10)
+	second := addTestChain(t, chains, "second", 20)
+	if err := second.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chains started before the one that failed are stopped
+	err := chains.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "second") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := first.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	second.Stop()
+	if err := second.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChainsWaitErrors(t *testing.T) {
+	chains := NewChains()
+	addTestChain(t, chains, "first", 10)
+
+	// The storage of the second chain fails, its indexer stops with the error
+	storage, err := data.NewLevelDBStorage(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := data.NewDatabaseWithStorage(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.StartBlock(1, common.Hash{31: 1})
+	if _, err := chains.Add("second", eth.NewMemorySource(20), db, testChainOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chains.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the failed chain", func() bool { return chains.Indexer("second").LastError() != nil })
+	chains.Stop()
+	err = chains.Wait()
+	if err == nil || !strings.HasPrefix(err.Error(), "chain second: ") || strings.Contains(err.Error(), "chain first") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MaxReorgDepth is the amount of processed blocks that we keep in memory to be able to revert them
+var MaxReorgDepth = 256
+
+type rowChange struct {
+	table      *Table
+	key        string
+	existed    bool
+	fields     []Field
+	provenance *Provenance
+}
+
+// tableChange keeps a copy of the table before its schema or metadata was modified
+type tableChange struct {
+	table    *Table
+	previous *Table
+}
+
+type ProcessedBlock struct {
+	Height  uint64
+	Hash    common.Hash
+	changes []rowChange
+	tables  []tableChange
+	// Pending transactions confirmed by the block, they are pending again if it is reverted
+	transactions []UnconfirmedTransaction
+}
+
+// StartBlock sets the block that will own every row modification until the next call
+func (db *Database) StartBlock(height uint64, hash common.Hash) {
+	db.BeginBlock(height, hash)
+	db.EndBlock()
+}
+
+// startBlock must be called with the write lock
+func (db *Database) startBlock(height uint64, hash common.Hash) {
+	if last := db.lastProcessedBlock(); last != nil && last.Height == height {
+		if last.Hash != hash {
+			last.Hash = hash
+			db.dirtyBlocks[last] = true
+		}
+		return
+	}
+
+	block := &ProcessedBlock{Height: height, Hash: hash, changes: []rowChange{}}
+	db.processedBlocks = append(db.processedBlocks, block)
+	db.dirtyBlocks[block] = true
+	// The unconfirmed blocks are always kept because they are needed to build the confirmed view
+	for len(db.processedBlocks) > MaxReorgDepth && db.processedBlocks[0].Height <= db.ConfirmedHeight {
+		db.processedBlocks = db.processedBlocks[1:]
+	}
+}
+
+func (db *Database) SetConfirmedHeight(height uint64) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.ConfirmedHeight = height
+}
+
+// LastProcessedBlock returns a copy of the last tracked block, without its changes
+func (db *Database) LastProcessedBlock() *ProcessedBlock {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	last := db.lastProcessedBlock()
+	if last == nil {
+		return nil
+	}
+	return &ProcessedBlock{Height: last.Height, Hash: last.Hash}
+}
+
+func (db *Database) lastProcessedBlock() *ProcessedBlock {
+	if len(db.processedBlocks) == 0 {
+		return nil
+	}
+	return db.processedBlocks[len(db.processedBlocks)-1]
+}
+
+// trackedBlock returns nil if the block is not tracked anymore or it was orphaned
+func (db *Database) trackedBlock(height uint64, hash common.Hash) *ProcessedBlock {
+	for _, block := range db.processedBlocks {
+		if block.Height == height && block.Hash == hash {
+			return block
+		}
+	}
+	return nil
+}
+
+// journalBlock is the block that owns the row modifications, the retried dead letters use their own block.
+// Nothing is journaled if it returns nil.
+func (db *Database) journalBlock() *ProcessedBlock {
+	if db.retriedLog != nil {
+		return db.retriedBlock
+	}
+	return db.lastProcessedBlock()
+}
+
+// ProcessedBlocks returns the tracked blocks, from the newest to the oldest one
+func (db *Database) ProcessedBlocks() []ProcessedBlock {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	ret := make([]ProcessedBlock, 0, len(db.processedBlocks))
+	for i := len(db.processedBlocks) - 1; i >= 0; i-- {
+		ret = append(ret, ProcessedBlock{Height: db.processedBlocks[i].Height, Hash: db.processedBlocks[i].Hash})
+	}
+	return ret
+}
+
+func (db *Database) BlockHash(height uint64) (common.Hash, bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	for i := len(db.processedBlocks) - 1; i >= 0; i-- {
+		if db.processedBlocks[i].Height == height {
+			return db.processedBlocks[i].Hash, true
+		}
+		if db.processedBlocks[i].Height < height {
+			break
+		}
+	}
+	return common.Hash{}, false
+}
+
+// Rollback reverts every row modification made by the blocks with a height bigger than the given one
+func (db *Database) Rollback(height uint64) error {
+	db.lock()
+	defer db.unlock()
+
+	if len(db.processedBlocks) > 0 && db.processedBlocks[0].Height > height {
+		return fmt.Errorf("can not rollback to %d, the oldest tracked block is %d", height, db.processedBlocks[0].Height)
+	}
+
+	for len(db.processedBlocks) > 0 {
+		block := db.lastProcessedBlock()
+		if block.Height <= height {
+			break
+		}
+
+		logger.LogInfo(fmt.Sprintf("[indexer] reverting block %d (%s) with %d changes", block.Height, block.Hash.Hex(), len(block.changes)+len(block.tables)))
+		for i := len(block.changes) - 1; i >= 0; i-- {
+			change := block.changes[i]
+			if change.existed {
+				if err := db.tx.PutRow(storageID(change.table), change.key, Row{Fields: change.fields, Provenance: change.provenance}); err != nil {
+					return err
+				}
+				db.sinkRow(change.table, change.key, change.fields)
+				db.AddEvent(change.table, change.key, &change.fields)
+			} else {
+				if err := db.tx.DeleteRow(storageID(change.table), change.key); err != nil {
+					return err
+				}
+				db.sinkRow(change.table, change.key, nil)
+				db.AddEvent(change.table, change.key, nil)
+			}
+		}
+		for i := len(block.tables) - 1; i >= 0; i-- {
+			change := block.tables[i]
+			// The previous values are replaced, not copied, like in UpdateTable
+			change.table.Metadata = change.previous.Metadata
+			change.table.Schema = change.previous.Schema
+			db.sinkSchema(change.table)
+			db.setMetadataDirty()
+		}
+		if len(block.transactions) > 0 {
+			db.txSentMutex.Lock()
+			db.UnconfirmedTransactions = append(db.UnconfirmedTransactions, block.transactions...)
+			db.txSentMutex.Unlock()
+		}
+
+		db.processedBlocks = db.processedBlocks[:len(db.processedBlocks)-1]
+	}
+
+	// The logs of the reverted blocks do not exist anymore
+	db.removeDeadLettersAfter(height)
+
+	if db.ConfirmedHeight > height {
+		logger.LogError(fmt.Sprintf("[indexer] reverted confirmed blocks, moving the confirmed height from %d to %d", db.ConfirmedHeight, height))
+		db.ConfirmedHeight = height
+	}
+	return nil
+}
+
+// journalRow stores the current value of the row so it can be restored if the block is reverted
+func (db *Database) journalRow(table *Table, key string) {
+	block := db.journalBlock()
+	if block == nil {
+		return
+	}
+
+	row, existed := db.storedRow(table, key)
+	var copied []Field
+	if existed {
+		copied = make([]Field, len(row.Fields))
+		copy(copied, row.Fields)
+	}
+	block.changes = append(block.changes, rowChange{table: table, key: key, existed: existed, fields: copied, provenance: row.Provenance})
+	db.dirtyBlocks[block] = true
+}
+
+// JournalTable stores the current schema and metadata of the table so they can be restored if the block is reverted.
+// It must be called with the write lock before the table is registered or renamed, UpdateTable already calls it.
+func (db *Database) JournalTable(table *Table) {
+	db.setMetadataDirty()
+	block := db.journalBlock()
+	if block == nil {
+		return
+	}
+	// Only the value before the block is needed
+	for _, change := range block.tables {
+		if change.table == table {
+			return
+		}
+	}
+	block.tables = append(block.tables, tableChange{table: table, previous: copyTable(table)})
+	db.dirtyBlocks[block] = true
+}
+
+// UpdateTable registers or renames the table, fn modifies copies of its metadata and schema that replace the current ones.
+// The values are never modified in place, the readers that still use them do not see the change. It must be called with the write lock.
+func (db *Database) UpdateTable(table *Table, fn func(metadata *TableMetadata, schema *TableSchema)) {
+	db.JournalTable(table)
+	updated := copyTable(table)
+	fn(updated.Metadata, updated.Schema)
+	table.Metadata = updated.Metadata
+	table.Schema = updated.Schema
+}
+
+// ConfirmUnconfirmedTransaction is TakeUnconfirmedTransaction for the transactions included in the current block,
+// the transaction is pending again if the block is reverted. It must be called with the write lock.
+func (db *Database) ConfirmUnconfirmedTransaction(txHash string) (UnconfirmedTransaction, bool) {
+	tx, ok := db.TakeUnconfirmedTransaction(txHash)
+	if ok {
+		if block := db.journalBlock(); block != nil {
+			block.transactions = append(block.transactions, tx)
+			db.dirtyBlocks[block] = true
+		}
+	}
+	return tx, ok
+}
+
+// sameTable compares the identifiers, the readers may use a copy of the table
+func sameTable(a *Table, b *Table) bool {
+	return a == b || storageID(a) == storageID(b)
+}
+
+// confirmedRow returns the value that the row had at the confirmed height if it was modified after it
+func (db *Database) confirmedRow(table *Table, key string) ([]Field, bool, bool) {
+	for _, block := range db.processedBlocks {
+		if block.Height <= db.ConfirmedHeight {
+			continue
+		}
+		for _, change := range block.changes {
+			if sameTable(change.table, table) && change.key == key {
+				return change.fields, change.existed, true
+			}
+		}
+	}
+	return nil, false, false
+}
+
+// GetConfirmedRow ignores the mempool and the blocks that are newer than the confirmed height
+func (db *Database) GetConfirmedRow(table *Table, key string) ([]Field, error) {
+	if table == nil {
+		return []Field{}, fmt.Errorf("table not found")
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	fields, existed, modified := db.confirmedRow(table, key)
+	if !modified {
+		return db.getRowNoMempool(table, key)
+	}
+	if !existed {
+		return []Field{}, fmt.Errorf("key not found")
+	}
+	return fields, nil
+}
+
+func (db *Database) GetConfirmedRows(table *Table) map[string][]Field {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	ret := db.copyRows(table)
+
+	// Only the first change after the confirmed height has the confirmed value
+	restored := map[string]bool{}
+	for _, block := range db.processedBlocks {
+		if block.Height <= db.ConfirmedHeight {
+			continue
+		}
+		for _, change := range block.changes {
+			if !sameTable(change.table, table) || restored[change.key] {
+				continue
+			}
+			restored[change.key] = true
+			if change.existed {
+				ret[change.key] = change.fields
+			} else {
+				delete(ret, change.key)
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,229 @@
+package data
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testTable(db *Database) *Table {
+	table := db.GetTable("0x01", "0x7462000000000000000000000000000000000000000000000000000000000001")
+	fieldNames := []string{"f0"}
+	keyNames := []string{"k0"}
+	table.Schema.Schema = &mudhelpers.SchemaTypeKV{
+		Key:   &mudhelpers.SchemaTypePair{Static: []mudhelpers.SchemaType{mudhelpers.BYTES32}, Dynamic: []mudhelpers.SchemaType{}, StaticDataLength: 32},
+		Value: &mudhelpers.SchemaTypePair{Static: []mudhelpers.SchemaType{mudhelpers.UINT32}, Dynamic: []mudhelpers.SchemaType{}, StaticDataLength: 4},
+	}
+	table.Schema.FieldNames = &fieldNames
+	table.Schema.KeyNames = &keyNames
+	table.Metadata.TableName = "Counter"
+	return table
+}
+
+func uintFields(name string, value int64) *[]Field {
+	return &[]Field{{Key: name, Data: NewUintFieldFromNumber(value)}}
+}
+
+func rowValue(t *testing.T, db *Database, table *Table, key string) string {
+	t.Helper()
+	fields, err := db.GetRowNoMempool(table, key)
+	if err != nil {
+		return ""
+	}
+	if len(fields) != 1 {
+		t.Fatalf("expected one field, got %v", fields)
+	}
+	return fields[0].String()
+}
+
+func TestRollbackRows(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	first := []byte{1}
+	second := []byte{2}
+
+	db.BeginBlock(1, common.HexToHash("0x01"))
+	db.AddRow(table, first, uintFields("f0", 1))
+	db.EndBlock()
+
+	db.BeginBlock(2, common.HexToHash("0x02"))
+	db.AddRow(table, first, uintFields("f0", 2))
+	db.AddRow(table, second, uintFields("f0", 3))
+	db.EndBlock()
+
+	db.BeginBlock(3, common.HexToHash("0x03"))
+	db.DeleteRow(table, first)
+	db.AddRow(table, second, uintFields("f0", 4))
+	db.EndBlock()
+
+	if err := db.Rollback(2); err != nil {
+		t.Fatal(err)
+	}
+	if value := rowValue(t, db, table, "0x01"); value != `"f0":2` {
+		t.Errorf("the deleted row was not restored: %q", value)
+	}
+	if value := rowValue(t, db, table, "0x02"); value != `"f0":3` {
+		t.Errorf("the modified row was not restored: %q", value)
+	}
+
+	if err := db.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if value := rowValue(t, db, table, "0x01"); value != `"f0":1` {
+		t.Errorf("unexpected row value %q", value)
+	}
+	if value := rowValue(t, db, table, "0x02"); value != "" {
+		t.Errorf("the row added by a reverted block still exists: %q", value)
+	}
+	if last := db.LastProcessedBlock(); last == nil || last.Height != 1 {
+		t.Errorf("unexpected last block %v", last)
+	}
+
+	if err := db.Rollback(0); err == nil {
+		t.Error("expected an error reverting blocks that are not tracked")
+	}
+}
+
+func TestRollbackTableRegistration(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+
+	db.BeginBlock(1, common.HexToHash("0x01"))
+	db.AddRow(table, []byte{1}, uintFields("f0", 1))
+	db.EndBlock()
+
+	// Same changes as a metadata event: the table and its columns are renamed
+	db.BeginBlock(2, common.HexToHash("0x02"))
+	db.UpdateTable(table, func(metadata *TableMetadata, schema *TableSchema) {
+		metadata.TableName = "Renamed"
+		fieldNames := []string{"value"}
+		schema.FieldNames = &fieldNames
+		(*schema.NamedFields)["value"] = mudhelpers.UINT32
+	})
+	db.RewriteRows(table, func(fields []Field) []Field {
+		fields[0].Key = "value"
+		return fields
+	})
+	// Only the value before the block is kept
+	db.UpdateTable(table, func(metadata *TableMetadata, _ *TableSchema) {
+		metadata.TableName = "RenamedAgain"
+	})
+	db.EndBlock()
+
+	if value := rowValue(t, db, table, "0x01"); value != `"value":1` {
+		t.Fatalf("the row was not renamed: %q", value)
+	}
+
+	if err := db.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if table.Metadata.TableName != "Counter" {
+		t.Errorf("the table name was not restored: %s", table.Metadata.TableName)
+	}
+	if names := *table.Schema.FieldNames; len(names) != 1 || names[0] != "f0" {
+		t.Errorf("the field names were not restored: %v", names)
+	}
+	if _, ok := (*table.Schema.NamedFields)["value"]; ok {
+		t.Error("the named fields were not restored")
+	}
+	if value := rowValue(t, db, table, "0x01"); value != `"f0":1` {
+		t.Errorf("the rewritten row was not restored: %q", value)
+	}
+}
+
+func TestTableReadersWhileUpdating(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	world := db.GetWorld(table.Metadata.WorldAddress)
+	applyBlock(db, table, 1, map[byte]int64{1: 1})
+	db.SetConfirmedHeight(1)
+
+	done := make(chan bool)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				copied := world.GetTableByName("Counter")
+				if copied == nil {
+					t.Error("the table was not found")
+					return
+				}
+				// The copy does not change while it is read
+				names := *copied.Schema.FieldNames
+				for _, name := range names {
+					if _, ok := (*copied.Schema.NamedFields)[name]; !ok {
+						t.Errorf("the field %s is not named in %v", name, *copied.Schema.NamedFields)
+						return
+					}
+				}
+				if rows := db.GetConfirmedRows(copied); len(rows) != 1 {
+					t.Errorf("unexpected confirmed rows %v", rows)
+					return
+				}
+				db.GetRows(copied)
+				db.RowsPerTable()
+				if again := *copied.Schema.FieldNames; again[0] != names[0] {
+					t.Errorf("the copy was modified from %v to %v", names, again)
+					return
+				}
+			}
+		}()
+	}
+
+	for height := uint64(2); height < 2000; height++ {
+		name := fmt.Sprintf("f%d", height)
+		db.BeginBlock(height, common.Hash{31: byte(height)})
+		db.UpdateTable(table, func(metadata *TableMetadata, schema *TableSchema) {
+			metadata.OnChainTableName = name
+			schema.FieldNames = &[]string{name}
+			schema.NamedFields = &map[string]mudhelpers.SchemaType{name: mudhelpers.UINT32}
+		})
+		db.SetCurrentLog(&Provenance{BlockNumber: height})
+		db.AddRow(table, []byte{1}, uintFields(name, int64(height)))
+		db.EndBlock()
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestRollbackConfirmedTransactions(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	db.AddTxSent(UnconfirmedTransaction{Txhash: "0xaa", Events: []MudEvent{NewMudEvent(table, []byte{1}, *uintFields("f0", 5))}})
+
+	db.BeginBlock(1, common.HexToHash("0x01"))
+	db.EndBlock()
+
+	db.BeginBlock(2, common.HexToHash("0x02"))
+	if _, ok := db.ConfirmUnconfirmedTransaction("0xaa"); !ok {
+		t.Fatal("the pending transaction was not found")
+	}
+	db.AddRow(table, []byte{1}, uintFields("f0", 5))
+	db.EndBlock()
+
+	if pending := db.PendingTransactions(); len(pending) != 0 {
+		t.Fatalf("the confirmed transaction is still pending: %v", pending)
+	}
+
+	if err := db.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	pending := db.PendingTransactions()
+	if len(pending) != 1 || pending[0].Txhash != "0xaa" {
+		t.Fatalf("the transaction of the reverted block is not pending again: %v", pending)
+	}
+	// The optimistic value is used again
+	if fields, err := db.GetRow(table, "0x01"); err != nil || fields[0].String() != `"f0":5` {
+		t.Errorf("unexpected predicted row %v (%v)", fields, err)
+	}
+}
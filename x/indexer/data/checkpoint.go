@@ -0,0 +1,103 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bocha-io/logger"
+)
+
+// Checkpointer persists the database together with the last applied block so the indexer can resume after a restart
+type Checkpointer struct {
+	Path string
+	// Minimum time between saves, the state is encoded completely each time
+	Interval time.Duration
+	lastSave time.Time
+}
+
+func NewCheckpointer(path string, interval time.Duration) *Checkpointer {
+	return &Checkpointer{Path: path, Interval: interval, lastSave: time.Now()}
+}
+
+// writeFileAtomic writes to a temp file first so a crash never leaves a half written file
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if err := write(temp); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// Save encodes the whole database, a crash never leaves a half written checkpoint
+func (c *Checkpointer) Save(db *Database) error {
+	state, err := db.ExportState()
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(c.Path, func(w io.Writer) error {
+		_, err := w.Write(encoded)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	c.lastSave = time.Now()
+	logger.LogDebug(fmt.Sprintf("[indexer] checkpoint saved at block %d (%s)", state.Height, state.Hash.Hex()))
+	return nil
+}
+
+// SaveIfDue is called after each applied block, it only saves when the interval has passed
+func (c *Checkpointer) SaveIfDue(db *Database) {
+	if c == nil || time.Since(c.lastSave) < c.Interval {
+		return
+	}
+	if err := c.Save(db); err != nil {
+		logger.LogError(fmt.Sprintf("[indexer] error saving the checkpoint: %s", err))
+	}
+}
+
+// Load returns false if there is no checkpoint yet
+func (c *Checkpointer) Load(db *Database) (bool, error) {
+	encoded, err := os.ReadFile(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	state := StoredState{}
+	if err := json.Unmarshal(encoded, &state); err != nil {
+		return false, fmt.Errorf("error decoding the checkpoint %s: %w", c.Path, err)
+	}
+	if err := db.ImportState(&state); err != nil {
+		return false, err
+	}
+
+	logger.LogInfo(fmt.Sprintf("[indexer] checkpoint loaded at block %d (%s)", state.Height, state.Hash.Hex()))
+	return true, nil
+}
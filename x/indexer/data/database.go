@@ -3,6 +3,7 @@ package data
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
@@ -10,19 +11,13 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
-type Event struct {
-	// TODO: add world here
-	// World string `json:"world"`
-	Table string `json:"table"`
-	Row   string `json:"row"`
-	Value string `json:"value"`
-}
-
 type TableMetadata struct {
 	TableID          string
 	TableName        string
 	OnChainTableName string
 	WorldAddress     string
+	// MUD v2 resource type (mudhelpers.ResourceTable or ResourceOffchainTable), empty for v1 tables
+	ResourceType string
 }
 
 type TableSchema struct {
@@ -32,37 +27,83 @@ type TableSchema struct {
 	NamedFields *map[string]mudhelpers.SchemaType
 }
 
+// Table is the schema of a table, its rows are kept by the database storage
 type Table struct {
 	Metadata *TableMetadata
 	Schema   *TableSchema
-	Rows     *map[string][]Field
 }
 
 type World struct {
 	Address string
-	Tables  map[string]*Table
+	// Use GetTable or a database snapshot to access the tables while the indexer is running
+	Tables map[string]*Table
+
+	// Database that owns the world, nil for the worlds created outside of it
+	db *Database
+}
+
+func (w *World) tableList() []*Table {
+	if w.db != nil {
+		w.db.worldsMutex.Lock()
+		defer w.db.worldsMutex.Unlock()
+	}
+	ret := make([]*Table, 0, len(w.Tables))
+	for _, table := range w.Tables {
+		ret = append(ret, table)
+	}
+	return ret
 }
 
+// GetTableByName returns a copy of the table, the indexer can register or rename it while it is used.
+// The name can also include the namespace (namespace__name), it is needed if several namespaces use the same table name.
 // TODO: add a cache layer here to avoid the loop
 func (w *World) GetTableByName(tableName string) *Table {
-	for tableID := range w.Tables {
-		if w.Tables[tableID].Metadata != nil {
-			if w.Tables[tableID].Metadata.TableName == tableName {
-				return w.Tables[tableID]
+	if w.db != nil {
+		w.db.mutex.RLock()
+		defer w.db.mutex.RUnlock()
+	}
+	matches := []*Table{}
+	for _, table := range w.tableList() {
+		if table.Metadata != nil {
+			if table.Metadata.OnChainTableName == tableName {
+				return copyTable(table)
+			}
+			if table.Metadata.TableName == tableName {
+				matches = append(matches, table)
 			}
 		}
 	}
+	if len(matches) > 1 {
+		logger.LogError(fmt.Sprintf("[indexer] the table name %s is used by several namespaces of world %s, use the namespace too", tableName, w.Address))
+		return nil
+	}
+	if len(matches) == 1 {
+		return copyTable(matches[0])
+	}
 	return nil
 }
 
+// GetTableByNamespace is GetTableByName for a MUD v2 table of the given namespace
+func (w *World) GetTableByNamespace(namespace string, tableName string) *Table {
+	return w.GetTableByName(namespace + mudhelpers.CONNECTOR + tableName)
+}
+
+// GetTable returns the table used by the indexer, it is created if it does not exist. Its metadata and schema are
+// replaced by UpdateTable with the write lock, so they must be read with the database queries, GetTableByName or a Snapshot.
 func (w *World) GetTable(tableID string) *Table {
+	if w.db != nil {
+		w.db.worldsMutex.Lock()
+		defer w.db.worldsMutex.Unlock()
+	}
 	if table, ok := w.Tables[tableID]; ok {
 		return table
 	}
+	if w.db != nil {
+		w.db.setMetadataDirty()
+	}
 	w.Tables[tableID] = &Table{
 		Metadata: &TableMetadata{TableID: tableID, TableName: "", OnChainTableName: "", WorldAddress: w.Address},
 		Schema:   &TableSchema{FieldNames: &[]string{}, KeyNames: &[]string{}, Schema: &mudhelpers.SchemaTypeKV{}, NamedFields: &map[string]mudhelpers.SchemaType{}},
-		Rows:     &map[string][]Field{},
 	}
 	table := w.Tables[tableID]
 	return table
@@ -71,38 +112,185 @@ func (w *World) GetTable(tableID string) *Table {
 type UnconfirmedTransaction struct {
 	Txhash string
 	Events []MudEvent
+	// Set by AddTxSent if empty, used to expire the transactions
+	SentAt     time.Time
+	SentHeight uint64
+	// Last time the receipt was requested, used to rate limit the receipt checks
+	ReceiptCheckedAt time.Time
 }
 
+// Database is written by the indexer and read concurrently by the games and the UI.
+// The rows are modified between BeginBlock and EndBlock (or inside Update), the fields must be read using Info, the queries or a Snapshot.
 type Database struct {
 	Worlds                  map[string]*World
-	Events                  []Event
 	LastUpdate              time.Time
 	LastHeight              uint64
+	ConfirmedHeight         uint64
 	ChainID                 string
 	UnconfirmedTransactions []UnconfirmedTransaction
 	txSentMutex             *sync.Mutex
 
+	// Held by the writer for a whole block so the readers never see it half applied
+	mutex *sync.RWMutex
+	// Guards the worlds and tables maps, they are created on demand by the readers too
+	worldsMutex *sync.Mutex
+	// Set while the write lock is held, the update handler calls are delayed until it is released
+	writing       bool
+	notifications []rowUpdate
+
+	// Last row events, the events of a block are added when it ends
+	events        *eventLog
+	pendingEvents []Event
+
+	// Rows of every table, the writes of a block are kept in the transaction until it ends
+	storage Storage
+	tx      StorageTransaction
+
+	// Blocks used to detect and revert chain reorgs
+	processedBlocks []*ProcessedBlock
+	// Blocks whose journal changed during the write lock and the heights saved by the durable storage
+	dirtyBlocks  map[*ProcessedBlock]bool
+	storedBlocks map[uint64]bool
+	// First error committing the writes, the storage is not consistent with the database after it
+	storageErr error
+
 	defaultWorld string
 
 	updateHandler *func(table string, key string, fields *[]Field)
+
+	// Optimistic updates that did not match the confirmed logs
+	mismatchHandler *func(mismatch PredictionMismatch)
+	predictionStats PredictionStats
+
+	txFailedHandler *func(tx UnconfirmedTransaction, reason string)
+
+	// Logs that could not be decoded or applied
+	deadLetters      []DeadLetter
+	tombstones       map[string]Provenance
+	deadLettersMutex *sync.Mutex
+
+	logStats   LogStats
+	statsMutex *sync.Mutex
+
+	// Log being applied, used as the provenance of the modified rows
+	currentLog *Provenance
+	// Dead letter being retried, the rows modified by newer logs are kept and the changes are journaled in its block
+	retriedLog   *Provenance
+	retriedBlock *ProcessedBlock
+
+	// Records of the offchain and ephemeral tables
+	Ephemeral *EphemeralStream
+
+	// Mirror of the committed rows, the changes are kept until the end of the block
+	sink        Sink
+	sinkChanges []sinkChange
+	sinkTables  map[*Table]bool
+	// Tables already sent with all their rows
+	sinkSent  map[*Table]bool
+	sinkReset bool
+	// Applies the batches in order once the write lock is released
+	sinkWorker *sinkWorker
+
+	// Set when the worlds, tables or dead letters change, the durable storages only save them again after it
+	metadataDirty atomic.Bool
 }
 
 func NewDatabase() *Database {
-	return &Database{
-		Worlds:     map[string]*World{},
-		Events:     make([]Event, 0),
-		LastUpdate: time.Now(),
-		LastHeight: 0,
-		ChainID:    "",
+	return newDatabase(NewMemoryStorage())
+}
+
+// NewDatabaseWithStorage restores the tables and the last block if the storage is durable and was already used
+func NewDatabaseWithStorage(storage Storage) (*Database, error) {
+	db := newDatabase(storage)
+	if err := db.loadMetadata(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func newDatabase(storage Storage) *Database {
+	db := &Database{
+		Worlds:          map[string]*World{},
+		LastUpdate:      time.Now(),
+		LastHeight:      0,
+		ConfirmedHeight: 0,
+		ChainID:         "",
 		// TODO: use a list instead of array
 		UnconfirmedTransactions: []UnconfirmedTransaction{},
 		txSentMutex:             &sync.Mutex{},
+		mutex:                   &sync.RWMutex{},
+		worldsMutex:             &sync.Mutex{},
+		writing:                 false,
+		notifications:           []rowUpdate{},
+		events:                  newEventLog(DefaultEventRetention),
+		pendingEvents:           []Event{},
+		storage:                 storage,
+		tx:                      nil,
+		processedBlocks:         []*ProcessedBlock{},
+		dirtyBlocks:             map[*ProcessedBlock]bool{},
+		storedBlocks:            map[uint64]bool{},
+		storageErr:              nil,
 		// Helper for games
 		defaultWorld: "",
 
 		// handleUpdates
-		updateHandler: nil,
+		updateHandler:   nil,
+		mismatchHandler: nil,
+		predictionStats: PredictionStats{},
+		txFailedHandler: nil,
+
+		deadLetters:      []DeadLetter{},
+		tombstones:       map[string]Provenance{},
+		deadLettersMutex: &sync.Mutex{},
+
+		logStats:   LogStats{Logs: map[string]uint64{}},
+		statsMutex: &sync.Mutex{},
+
+		Ephemeral: NewEphemeralStream(DefaultEphemeralCapacity),
+
+		sink:        nil,
+		sinkChanges: []sinkChange{},
+		sinkTables:  map[*Table]bool{},
+		sinkSent:    map[*Table]bool{},
+		sinkReset:   false,
+		sinkWorker:  nil,
 	}
+	// The metadata is saved with the first commit
+	db.setMetadataDirty()
+	return db
+}
+
+// Reset removes the tables and tracked blocks, the handlers and the default world are kept
+func (db *Database) Reset() {
+	db.lock()
+	defer db.unlock()
+	db.reset()
+}
+
+// reset must be called with the write lock
+func (db *Database) reset() {
+	db.worldsMutex.Lock()
+	db.Worlds = map[string]*World{}
+	db.worldsMutex.Unlock()
+	// The rows are removed when the transaction is committed, with the writes that follow the reset
+	db.tx.Clear()
+	db.setMetadataDirty()
+	db.dirtyBlocks = map[*ProcessedBlock]bool{}
+	db.storedBlocks = map[uint64]bool{}
+	db.events.clear()
+	db.pendingEvents = []Event{}
+	db.LastUpdate = time.Now()
+	db.LastHeight = 0
+	db.ConfirmedHeight = 0
+	db.processedBlocks = []*ProcessedBlock{}
+	db.deadLettersMutex.Lock()
+	db.deadLetters = []DeadLetter{}
+	db.tombstones = map[string]Provenance{}
+	db.deadLettersMutex.Unlock()
+	// The sink drops its tables and gets the new ones
+	db.sinkReset = db.sink != nil
+	db.sinkChanges = []sinkChange{}
+	db.sinkTables = map[*Table]bool{}
 }
 
 func (db *Database) SetUpdateHandler(handler func(table string, key string, fields *[]Field)) {
@@ -110,30 +298,54 @@ func (db *Database) SetUpdateHandler(handler func(table string, key string, fiel
 }
 
 func (db *Database) AddTxSent(tx UnconfirmedTransaction) {
+	if tx.SentAt.IsZero() {
+		tx.SentAt = time.Now()
+	}
+	if tx.SentHeight == 0 {
+		db.mutex.RLock()
+		tx.SentHeight = db.LastHeight
+		db.mutex.RUnlock()
+	}
+
 	db.txSentMutex.Lock()
 	defer db.txSentMutex.Unlock()
 	db.UnconfirmedTransactions = append(db.UnconfirmedTransactions, tx)
 }
 
-func (db *Database) AddEvent(tableName string, key string, fields *[]Field) {
+// AddEvent records the row modification and sends it to the update handler, nil fields mean that the row was deleted
+func (db *Database) AddEvent(table *Table, key string, fields *[]Field) {
+	tableName := table.Metadata.TableName
 	if db.updateHandler != nil {
-		(*db.updateHandler)(tableName, key, fields)
+		if db.writing {
+			// The handler may query the database, it is called once the block is visible
+			db.notifications = append(db.notifications, rowUpdate{table: tableName, key: key, fields: fields})
+		} else {
+			(*db.updateHandler)(tableName, key, fields)
+		}
 	}
 
-	value := ""
+	event := Event{
+		World:   table.Metadata.WorldAddress,
+		TableID: table.Metadata.TableID,
+		Table:   tableName,
+		Key:     key,
+		Fields:  nil,
+		Deleted: fields == nil,
+		Time:    time.Now(),
+	}
 	if fields != nil {
-		value = "{"
-		for i, v := range *fields {
-			value += v.String()
-			if i != len(*fields)-1 {
-				value += ","
-			}
-		}
-		value += "}"
+		event.Fields = *fields
+	}
+	if db.currentLog != nil {
+		provenance := *db.currentLog
+		event.Provenance = &provenance
+	}
+	if db.writing {
+		// The readers get the events of the block with its rows
+		db.pendingEvents = append(db.pendingEvents, event)
+	} else {
+		db.events.append(event)
 	}
-
-	// TODO: limit this to 10 events to avoid memory leak
-	db.Events = append(db.Events, Event{Table: tableName, Row: key, Value: value})
 	db.LastUpdate = time.Now()
 }
 
@@ -149,10 +361,13 @@ func (db *Database) GetDefaultWorld() *World {
 }
 
 func (db *Database) GetWorld(worldID string) *World {
+	db.worldsMutex.Lock()
+	defer db.worldsMutex.Unlock()
 	if world, ok := db.Worlds[worldID]; ok {
 		return world
 	}
-	db.Worlds[worldID] = &World{Address: worldID, Tables: map[string]*Table{}}
+	db.Worlds[worldID] = &World{Address: worldID, Tables: map[string]*Table{}, db: db}
+	db.setMetadataDirty()
 	logger.LogInfo(fmt.Sprintf("new world registered %s", worldID))
 	world := db.Worlds[worldID]
 	return world
@@ -166,42 +381,88 @@ func (db *Database) GetTable(worldID string, tableID string) *Table {
 func (db *Database) AddRow(table *Table, key []byte, fields *[]Field) MudEvent {
 	// Use the database to add and remove info so we can broadcast events to subs
 	keyAsString := hexutil.Encode(key)
-	// TODO: add locks here
-	(*table.Rows)[keyAsString] = *fields
-	db.AddEvent(table.Metadata.TableName, keyAsString, fields)
+	if current, skip := db.skipRetriedRow(table, keyAsString); skip {
+		return NewMudEvent(table, key, current)
+	}
+	db.journalRow(table, keyAsString)
+	db.putRow(table, keyAsString, *fields)
+	db.AddEvent(table, keyAsString, fields)
 	return NewMudEvent(table, key, *fields)
 }
 
 func (db *Database) SetField(table *Table, key []byte, event *mudhelpers.StorecoreStoreSetField) MudEvent {
-	// TODO: add locks here
-
 	// keyAsString := string(key)
 	keyAsString := hexutil.Encode(key)
+	if current, skip := db.skipRetriedRow(table, keyAsString); skip {
+		return NewMudEvent(table, key, current)
+	}
 	fields, modified := BytesToFieldWithDefaults(event.Data, *table.Schema.Schema.Value, event.SchemaIndex, table.Schema.FieldNames)
-
-	_, ok := (*table.Rows)[keyAsString]
-	if ok {
-		// Edit the row because it already exists
-		for i := range (*table.Rows)[keyAsString] {
-			if (*table.Rows)[keyAsString][i].Key == modified.Key {
-				(*table.Rows)[keyAsString][i].Data = modified.Data
+	db.journalRow(table, keyAsString)
+
+	if row, ok := db.storedRow(table, keyAsString); ok {
+		// Edit a copy of the row because it already exists, the readers may still use the old one
+		updated := make([]Field, len(row.Fields))
+		copy(updated, row.Fields)
+		for i := range updated {
+			if updated[i].Key == modified.Key {
+				updated[i].Data = modified.Data
 				break
 			}
 		}
-	} else {
-		// Create an empty row with defaults but the event index that uses event.Data
-		(*table.Rows)[keyAsString] = *fields
+		// The event has the complete row, not the defaults
+		fields = &updated
 	}
+	// Otherwise create an empty row with defaults but the event index that uses event.Data
+	db.putRow(table, keyAsString, *fields)
 
-	db.AddEvent(table.Metadata.TableName, keyAsString, fields)
+	db.AddEvent(table, keyAsString, fields)
 	return NewMudEvent(table, key, *fields)
 }
 
+func (db *Database) SpliceStaticData(table *Table, key []byte, event *mudhelpers.StoreEventsSpliceStaticData) MudEvent {
+	keyAsString := hexutil.Encode(key)
+	if current, skip := db.skipRetriedRow(table, keyAsString); skip {
+		return NewMudEvent(table, key, current)
+	}
+	row, _ := db.storedRow(table, keyAsString)
+	fields := rowWithDefaults(table, row.Fields)
+	SpliceStaticFields(fields, *table.Schema.Schema.Value, event.Start.Uint64(), event.Data)
+
+	db.journalRow(table, keyAsString)
+	db.putRow(table, keyAsString, fields)
+	db.AddEvent(table, keyAsString, &fields)
+	return NewMudEvent(table, key, fields)
+}
+
+func (db *Database) SpliceDynamicData(table *Table, key []byte, event *mudhelpers.StoreEventsSpliceDynamicData) MudEvent {
+	keyAsString := hexutil.Encode(key)
+	if current, skip := db.skipRetriedRow(table, keyAsString); skip {
+		return NewMudEvent(table, key, current)
+	}
+	row, _ := db.storedRow(table, keyAsString)
+	fields := rowWithDefaults(table, row.Fields)
+	schemaTypePair := *table.Schema.Schema.Value
+	length := SpliceDynamicField(fields, schemaTypePair, event.DynamicFieldIndex, event.Start.Uint64(), event.DeleteCount.Uint64(), event.Data)
+
+	_, lengths := mudhelpers.DecodeEncodedLengths(event.EncodedLengths, len(schemaTypePair.Dynamic))
+	if lengths[event.DynamicFieldIndex] != length {
+		logger.LogError(fmt.Sprintf("[indexer] dynamic field %d of table %s, key %s has %d bytes but the event expected %d", event.DynamicFieldIndex, table.Metadata.TableName, keyAsString, length, lengths[event.DynamicFieldIndex]))
+	}
+
+	db.journalRow(table, keyAsString)
+	db.putRow(table, keyAsString, fields)
+	db.AddEvent(table, keyAsString, &fields)
+	return NewMudEvent(table, key, fields)
+}
+
 func (db *Database) DeleteRow(table *Table, key []byte) MudEvent {
 	keyAsString := hexutil.Encode(key)
-	// TODO: add locks here
-	delete((*table.Rows), keyAsString)
-	db.AddEvent(table.Metadata.TableName, keyAsString, nil)
+	if current, skip := db.skipRetriedRow(table, keyAsString); skip {
+		return NewMudEvent(table, key, current)
+	}
+	db.journalRow(table, keyAsString)
+	db.removeRow(table, keyAsString)
+	db.AddEvent(table, keyAsString, nil)
 	return NewMudEvent(table, key, nil)
 }
 
@@ -210,7 +471,23 @@ func (db *Database) GetRowUsingBytes(table *Table, key []byte) ([]Field, error)
 	return db.GetRow(table, keyAsString)
 }
 
+// GetRow returns the predicted value of the row if a pending transaction modified it, the returned fields must not be modified
 func (db *Database) GetRow(table *Table, key string) ([]Field, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if table != nil {
+		if fields, found := db.pendingRow(table, key); found {
+			return fields, nil
+		}
+	}
+	return db.getRowNoMempool(table, key)
+}
+
+func (db *Database) pendingRow(table *Table, key string) ([]Field, bool) {
+	db.txSentMutex.Lock()
+	defer db.txSentMutex.Unlock()
+
 	var fields []Field
 	found := false
 	// TODO: go from the lastest to the first one so we can break the for loop instead of looking for the most recent value
@@ -225,36 +502,41 @@ func (db *Database) GetRow(table *Table, key string) ([]Field, error) {
 		}
 	}
 
-	if found {
-		return fields, nil
-	}
-
-	return db.GetRowNoMempool(table, key)
+	return fields, found
 }
 
 func (db *Database) GetRowNoMempool(table *Table, key string) ([]Field, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.getRowNoMempool(table, key)
+}
+
+func (db *Database) getRowNoMempool(table *Table, key string) ([]Field, error) {
 	// Table will be nil when you query the table by name and its metadata was not set yet
 	if table == nil {
 		return []Field{}, fmt.Errorf("table not found")
 	}
 	// Look for the value in the database
-	v, ok := (*table.Rows)[key]
+	row, ok, err := db.rows().GetRow(storageID(table), key)
+	if err != nil {
+		return []Field{}, err
+	}
 	if ok {
-		return v, nil
+		return row.Fields, nil
 	}
 
 	return []Field{}, fmt.Errorf("key not found")
 }
 
 func (db *Database) GetRows(table *Table) map[string][]Field {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
 	// TODO: improve this because it's expensive
-	ret := map[string][]Field{}
-	for k, v := range *table.Rows {
-		temp := make([]Field, len(v))
-		copy(temp, v)
-		ret[k] = temp
-	}
+	ret := db.copyRows(table)
 
+	db.txSentMutex.Lock()
+	defer db.txSentMutex.Unlock()
 	for _, v := range db.UnconfirmedTransactions {
 		for _, event := range v.Events {
 			if event.Table == table.Metadata.TableName {
@@ -264,3 +546,8 @@ func (db *Database) GetRows(table *Table) map[string][]Field {
 	}
 	return ret
 }
+
+// Namespace identifies a world of this chain when several chains are indexed
+func (db *Database) Namespace(worldID string) string {
+	return mudhelpers.Namespace(db.Info().ChainID, worldID)
+}
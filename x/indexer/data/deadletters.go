@@ -0,0 +1,197 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bocha-io/garnet/internal/gui"
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// DeadLetter is a store log that could not be decoded or applied, it is kept so it can be retried
+type DeadLetter struct {
+	Log         types.Log `json:"log"`
+	Error       string    `json:"error"`
+	BlockNumber uint64    `json:"block_number"`
+	LogIndex    uint      `json:"log_index"`
+	TxHash      string    `json:"tx_hash"`
+	FailedAt    time.Time `json:"failed_at"`
+	Attempts    int       `json:"attempts"`
+}
+
+// AddDeadLetter must be called with the write lock, like the row modifications
+func (db *Database) AddDeadLetter(log types.Log, err error) {
+	logger.LogError(fmt.Sprintf("[indexer] moving the log %d of block %d to the dead letters: %s", log.Index, log.BlockNumber, err))
+
+	db.deadLettersMutex.Lock()
+	defer db.deadLettersMutex.Unlock()
+	for i, v := range db.deadLetters {
+		if v.BlockNumber == log.BlockNumber && v.LogIndex == log.Index {
+			db.deadLetters[i].Error = err.Error()
+			db.deadLetters[i].FailedAt = time.Now()
+			db.deadLetters[i].Attempts++
+			db.setMetadataDirty()
+			return
+		}
+	}
+	db.deadLetters = append(db.deadLetters, DeadLetter{
+		Log:         log,
+		Error:       err.Error(),
+		BlockNumber: log.BlockNumber,
+		LogIndex:    log.Index,
+		TxHash:      log.TxHash.Hex(),
+		FailedAt:    time.Now(),
+		Attempts:    1,
+	})
+	db.setMetadataDirty()
+	db.LastUpdate = time.Now()
+}
+
+func (db *Database) DeadLetters() []DeadLetter {
+	db.deadLettersMutex.Lock()
+	defer db.deadLettersMutex.Unlock()
+	ret := make([]DeadLetter, len(db.deadLetters))
+	copy(ret, db.deadLetters)
+	return ret
+}
+
+// RemoveDeadLetter must be called with the write lock, like the row modifications
+func (db *Database) RemoveDeadLetter(blockNumber uint64, logIndex uint) bool {
+	db.deadLettersMutex.Lock()
+	defer db.deadLettersMutex.Unlock()
+	for i, v := range db.deadLetters {
+		if v.BlockNumber == blockNumber && v.LogIndex == logIndex {
+			db.deadLetters = append(db.deadLetters[:i], db.deadLetters[i+1:]...)
+			if len(db.deadLetters) == 0 {
+				db.tombstones = map[string]Provenance{}
+			}
+			db.setMetadataDirty()
+			db.LastUpdate = time.Now()
+			return true
+		}
+	}
+	return false
+}
+
+// removeDeadLettersAfter must be called with the write lock
+func (db *Database) removeDeadLettersAfter(height uint64) {
+	db.deadLettersMutex.Lock()
+	defer db.deadLettersMutex.Unlock()
+	kept := []DeadLetter{}
+	for _, v := range db.deadLetters {
+		if v.BlockNumber <= height {
+			kept = append(kept, v)
+		}
+	}
+	db.deadLetters = kept
+	db.setMetadataDirty()
+	// The rows deleted by the reverted blocks are restored
+	for id, provenance := range db.tombstones {
+		if provenance.BlockNumber > height || len(kept) == 0 {
+			delete(db.tombstones, id)
+		}
+	}
+}
+
+func (db *Database) setDeadLetters(deadLetters []DeadLetter, tombstones map[string]Provenance) {
+	db.deadLettersMutex.Lock()
+	defer db.deadLettersMutex.Unlock()
+	db.deadLetters = make([]DeadLetter, len(deadLetters))
+	copy(db.deadLetters, deadLetters)
+	db.tombstones = map[string]Provenance{}
+	for id, provenance := range tombstones {
+		db.tombstones[id] = provenance
+	}
+	db.setMetadataDirty()
+}
+
+// Tombstones returns the log that deleted each row while there were dead letters, by table storage id and key
+func (db *Database) Tombstones() map[string]Provenance {
+	db.deadLettersMutex.Lock()
+	defer db.deadLettersMutex.Unlock()
+	ret := make(map[string]Provenance, len(db.tombstones))
+	for id, provenance := range db.tombstones {
+		ret[id] = provenance
+	}
+	return ret
+}
+
+func tombstoneID(table *Table, key string) string {
+	return storageID(table) + "/" + key
+}
+
+// addTombstone must be called with the write lock. A deleted row has no provenance, the log that deleted it is kept
+// while there are dead letters so an older retried log does not create it again.
+func (db *Database) addTombstone(table *Table, key string) {
+	if db.currentLog == nil {
+		return
+	}
+	db.deadLettersMutex.Lock()
+	defer db.deadLettersMutex.Unlock()
+	if len(db.deadLetters) > 0 {
+		db.tombstones[tombstoneID(table, key)] = *db.currentLog
+		db.setMetadataDirty()
+	}
+}
+
+// RetryDeadLetter calls apply with the write lock and the log as the current one. The rows modified by a newer log are kept,
+// and the changes are journaled in the block of the log so they are only reverted with it.
+// The dead letter is removed if apply succeeds, otherwise its attempts are increased.
+func (db *Database) RetryDeadLetter(deadLetter DeadLetter, apply func() error) error {
+	db.lock()
+	defer db.unlock()
+
+	provenance := NewProvenance(deadLetter.Log, 0)
+	db.currentLog = &provenance
+	db.retriedLog = &provenance
+	db.retriedBlock = db.trackedBlock(deadLetter.BlockNumber, deadLetter.Log.BlockHash)
+	defer func() {
+		db.retriedLog = nil
+		db.retriedBlock = nil
+	}()
+
+	if err := apply(); err != nil {
+		db.AddDeadLetter(deadLetter.Log, err)
+		return err
+	}
+	db.RemoveDeadLetter(deadLetter.BlockNumber, deadLetter.LogIndex)
+	return nil
+}
+
+// skipRetriedRow must be called with the write lock, it returns the current fields if the row was modified after the retried log
+func (db *Database) skipRetriedRow(table *Table, key string) ([]Field, bool) {
+	if db.retriedLog == nil {
+		return nil, false
+	}
+	row, ok := db.storedRow(table, key)
+	if !ok {
+		db.deadLettersMutex.Lock()
+		deleted, found := db.tombstones[tombstoneID(table, key)]
+		db.deadLettersMutex.Unlock()
+		if found && deleted.After(*db.retriedLog) {
+			logger.LogInfo(fmt.Sprintf("[indexer] the retried log %d of block %d does not create the row %s of table %s, it was deleted by %s", db.retriedLog.LogIndex, db.retriedLog.BlockNumber, key, table.Metadata.TableName, deleted))
+			return nil, true
+		}
+		return nil, false
+	}
+	if row.Provenance == nil || !row.Provenance.After(*db.retriedLog) {
+		return nil, false
+	}
+	logger.LogInfo(fmt.Sprintf("[indexer] the retried log %d of block %d does not overwrite the row %s of table %s, it was modified by %s", db.retriedLog.LogIndex, db.retriedLog.BlockNumber, key, table.Metadata.TableName, row.Provenance))
+	return row.Fields, true
+}
+
+func (db *Database) DeadLettersToStringList() []string {
+	deadLetters := db.DeadLetters()
+	ret := []string{fmt.Sprintf("☠ Dead letters: %d", len(deadLetters)), ""}
+	for _, v := range deadLetters {
+		ret = append(ret, gui.ColorRed(fmt.Sprintf("✗ Block %d, log %d, attempts %d", v.BlockNumber, v.LogIndex, v.Attempts)))
+		ret = append(ret, fmt.Sprintf("    Tx    : %s", v.TxHash))
+		ret = append(ret, fmt.Sprintf("    World : %s", v.Log.Address.Hex()))
+		ret = append(ret, fmt.Sprintf("    Error : %s", v.Error))
+		ret = append(ret, fmt.Sprintf("    Failed: %s", v.FailedAt.Format(time.RFC3339)))
+		ret = append(ret, "")
+	}
+	return ret
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// applyBlock sets the rows in a block, each row is modified by its own log
+func applyBlock(db *Database, table *Table, height uint64, rows map[byte]int64) {
+	db.BeginBlock(height, common.Hash{31: byte(height)})
+	index := uint(0)
+	for key, value := range rows {
+		db.SetCurrentLog(&Provenance{BlockNumber: height, LogIndex: index})
+		db.AddRow(table, []byte{key}, uintFields("f0", value))
+		index++
+	}
+	db.EndBlock()
+}
+
+func addDeadLetter(db *Database, height uint64, index uint) DeadLetter {
+	log := types.Log{BlockNumber: height, BlockHash: common.Hash{31: byte(height)}, Index: index, Topics: []common.Hash{}, Data: []byte{}}
+	db.Update(func() {
+		db.AddDeadLetter(log, errors.New("the table is not registered"))
+	})
+	return db.DeadLetters()[len(db.DeadLetters())-1]
+}
+
+func TestRetryDeadLetterKeepsNewerRows(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	applyBlock(db, table, 1, map[byte]int64{1: 1, 2: 2})
+	applyBlock(db, table, 2, nil)
+	deadLetter := addDeadLetter(db, 2, 0)
+	applyBlock(db, table, 3, map[byte]int64{1: 3})
+	applyBlock(db, table, 4, nil)
+
+	err := db.RetryDeadLetter(deadLetter, func() error {
+		db.AddRow(table, []byte{1}, uintFields("f0", 20))
+		db.AddRow(table, []byte{2}, uintFields("f0", 20))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.DeadLetters()) != 0 {
+		t.Fatal("the dead letter was not removed")
+	}
+	key1 := common.Bytes2Hex([]byte{1})
+	key2 := common.Bytes2Hex([]byte{2})
+	if value := rowValue(t, db, table, "0x"+key1); value != `"f0":3` {
+		t.Errorf("the row modified by a newer log was overwritten: %q", value)
+	}
+	if value := rowValue(t, db, table, "0x"+key2); value != `"f0":20` {
+		t.Errorf("the row was not updated: %q", value)
+	}
+	if provenance, err := db.GetProvenance(table, "0x"+key2); err != nil || provenance.BlockNumber != 2 {
+		t.Errorf("unexpected provenance %v: %v", provenance, err)
+	}
+
+	// The changes belong to the block of the dead letter
+	if err := db.Rollback(3); err != nil {
+		t.Fatal(err)
+	}
+	if value := rowValue(t, db, table, "0x"+key2); value != `"f0":20` {
+		t.Errorf("the retried log was reverted with a newer block: %q", value)
+	}
+	if err := db.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if value := rowValue(t, db, table, "0x"+key2); value != `"f0":2` {
+		t.Errorf("the retried log was not reverted with its block: %q", value)
+	}
+}
+
+func TestRetryDeadLetterKeepsDeletedRows(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	applyBlock(db, table, 1, map[byte]int64{1: 1})
+	deadLetter := addDeadLetter(db, 2, 0)
+	db.BeginBlock(3, common.Hash{31: 3})
+	db.SetCurrentLog(&Provenance{BlockNumber: 3, LogIndex: 0})
+	db.DeleteRow(table, []byte{1})
+	db.EndBlock()
+
+	err := db.RetryDeadLetter(deadLetter, func() error {
+		db.AddRow(table, []byte{1}, uintFields("f0", 20))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := rowValue(t, db, table, "0x"+common.Bytes2Hex([]byte{1})); value != "" {
+		t.Errorf("the row deleted by a newer log was created again: %q", value)
+	}
+	if tombstones := db.Tombstones(); len(tombstones) != 0 {
+		t.Errorf("the tombstones were kept without dead letters: %v", tombstones)
+	}
+}
+
+func TestRetryDeadLetterFailsAgain(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	applyBlock(db, table, 1, nil)
+	deadLetter := addDeadLetter(db, 1, 0)
+
+	err := db.RetryDeadLetter(deadLetter, func() error {
+		return errors.New("still failing")
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	deadLetters := db.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 2 || deadLetters[0].Error != "still failing" {
+		t.Fatalf("unexpected dead letters %+v", deadLetters)
+	}
+}
+
+func TestDeadLettersArePersisted(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	applyBlock(db, table, 1, nil)
+	addDeadLetter(db, 1, 3)
+
+	imported := reimport(t, db)
+	deadLetters := imported.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].BlockNumber != 1 || deadLetters[0].LogIndex != 3 || deadLetters[0].Log.BlockHash != (common.Hash{31: 1}) {
+		t.Fatalf("unexpected imported dead letters %+v", deadLetters)
+	}
+
+	// The metadata of the durable storages keeps them too
+	path := filepath.Join(t.TempDir(), "db")
+	storage, err := NewLevelDBStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	durable, err := NewDatabaseWithStorage(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyBlock(durable, testTable(durable), 1, nil)
+	addDeadLetter(durable, 1, 3)
+	if err := durable.Close(); err != nil {
+		t.Fatal(err)
+	}
+	storage, err = NewLevelDBStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewDatabaseWithStorage(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if deadLetters := reopened.DeadLetters(); len(deadLetters) != 1 || deadLetters[0].LogIndex != 3 {
+		t.Fatalf("unexpected stored dead letters %+v", deadLetters)
+	}
+}
+
+func TestRollbackRemovesDeadLetters(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	applyBlock(db, table, 1, nil)
+	addDeadLetter(db, 1, 0)
+	applyBlock(db, table, 2, nil)
+	addDeadLetter(db, 2, 0)
+
+	if err := db.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if deadLetters := db.DeadLetters(); len(deadLetters) != 1 || deadLetters[0].BlockNumber != 1 {
+		t.Fatalf("the dead letters of the reverted block were kept: %+v", deadLetters)
+	}
+	db.Reset()
+	if len(db.DeadLetters()) != 0 {
+		t.Fatal("the dead letters were kept after a reset")
+	}
+}
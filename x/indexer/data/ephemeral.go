@@ -0,0 +1,132 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultEphemeralCapacity is the amount of ephemeral records kept for replays
+var DefaultEphemeralCapacity = 1024
+
+// EphemeralRecord is a record of an offchain (MUD v2) or ephemeral (MUD v1) table, it is never stored in the tables
+type EphemeralRecord struct {
+	// Increases by one with each record, a gap means that the subscriber missed records
+	Sequence   uint64
+	World      string
+	TableID    string
+	Table      string
+	Key        string
+	Fields     []Field
+	Deleted    bool
+	Provenance Provenance
+	ReceivedAt time.Time
+}
+
+type ephemeralSubscriber struct {
+	ch      chan EphemeralRecord
+	dropped uint64
+}
+
+// EphemeralStream keeps the last records in a ring buffer and sends the new ones to the subscribers.
+// The records of reverted blocks are not removed, the subscribers can compare the provenance with the chain.
+type EphemeralStream struct {
+	records     *ringBuffer[EphemeralRecord]
+	sequence    uint64
+	subscribers map[int]*ephemeralSubscriber
+	nextID      int
+	mutex       *sync.Mutex
+}
+
+func NewEphemeralStream(capacity int) *EphemeralStream {
+	return &EphemeralStream{
+		records:     newRingBuffer[EphemeralRecord](capacity),
+		sequence:    0,
+		subscribers: map[int]*ephemeralSubscriber{},
+		nextID:      0,
+		mutex:       &sync.Mutex{},
+	}
+}
+
+// Publish assigns the sequence to the record, subscribers with a full channel miss it
+func (s *EphemeralStream) Publish(record EphemeralRecord) EphemeralRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sequence++
+	record.Sequence = s.sequence
+	s.records.push(record)
+
+	for _, sub := range s.subscribers {
+		select {
+		case sub.ch <- record:
+		default:
+			sub.dropped++
+		}
+	}
+	return record
+}
+
+// replay must be called with the lock
+func (s *EphemeralStream) replay(after uint64) []EphemeralRecord {
+	ret := []EphemeralRecord{}
+	for _, record := range s.records.list() {
+		if record.Sequence > after {
+			ret = append(ret, record)
+		}
+	}
+	return ret
+}
+
+// Replay returns the buffered records with a sequence bigger than `after`, use 0 to get all of them
+func (s *EphemeralStream) Replay(after uint64) []EphemeralRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.replay(after)
+}
+
+// Subscribe returns the buffered records after the given sequence and a channel with the new ones, without gaps between them.
+// The returned function must be called to unsubscribe, it closes the channel.
+func (s *EphemeralStream) Subscribe(after uint64, buffer int) ([]EphemeralRecord, <-chan EphemeralRecord, func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	sub := &ephemeralSubscriber{ch: make(chan EphemeralRecord, buffer)}
+	s.subscribers[id] = sub
+
+	once := &sync.Once{}
+	unsubscribe := func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			delete(s.subscribers, id)
+			close(sub.ch)
+		})
+	}
+	return s.replay(after), sub.ch, unsubscribe
+}
+
+// Sequence is the sequence of the last published record
+func (s *EphemeralStream) Sequence() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sequence
+}
+
+// PublishEphemeral sends the record to the ephemeral stream instead of storing it in the table
+func (db *Database) PublishEphemeral(table *Table, key string, fields []Field, deleted bool) EphemeralRecord {
+	record := EphemeralRecord{
+		World:      table.Metadata.WorldAddress,
+		TableID:    table.Metadata.TableID,
+		Table:      table.Metadata.TableName,
+		Key:        key,
+		Fields:     fields,
+		Deleted:    deleted,
+		ReceivedAt: time.Now(),
+	}
+	if db.currentLog != nil {
+		record.Provenance = *db.currentLog
+	}
+	return db.Ephemeral.Publish(record)
+}
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"testing"
+)
+
+func publishEphemeral(stream *EphemeralStream, keys ...string) {
+	for _, key := range keys {
+		stream.Publish(EphemeralRecord{Table: "Move", Key: key})
+	}
+}
+
+func sequences(records []EphemeralRecord) []uint64 {
+	ret := make([]uint64, 0, len(records))
+	for _, record := range records {
+		ret = append(ret, record.Sequence)
+	}
+	return ret
+}
+
+func TestEphemeralLateSubscriber(t *testing.T) {
+	stream := NewEphemeralStream(3)
+	publishEphemeral(stream, "0x01", "0x02", "0x03", "0x04", "0x05")
+
+	// Only the last records are kept for the replays
+	if replayed := sequences(stream.Replay(0)); len(replayed) != 3 || replayed[0] != 3 || replayed[2] != 5 {
+		t.Fatalf("unexpected replay %v", replayed)
+	}
+	if replayed := stream.Replay(4); len(replayed) != 1 || replayed[0].Key != "0x05" {
+		t.Fatalf("unexpected replay after 4 %v", replayed)
+	}
+	if replayed := stream.Replay(5); len(replayed) != 0 {
+		t.Fatalf("unexpected replay after the last record %v", replayed)
+	}
+
+	// The subscriber continues after the replayed records without gaps
+	replayed, ch, unsubscribe := stream.Subscribe(3, 4)
+	if seqs := sequences(replayed); len(seqs) != 2 || seqs[0] != 4 || seqs[1] != 5 {
+		t.Fatalf("unexpected replay on subscribe %v", seqs)
+	}
+	publishEphemeral(stream, "0x06")
+	if record := <-ch; record.Sequence != 6 || record.Key != "0x06" {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	if sequence := stream.Sequence(); sequence != 6 {
+		t.Fatalf("unexpected sequence %d", sequence)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, open := <-ch; open {
+		t.Fatal("the channel was not closed")
+	}
+	// Publishing without subscribers does not block
+	publishEphemeral(stream, "0x07")
+}
+
+func TestEphemeralSubscriberOverflow(t *testing.T) {
+	stream := NewEphemeralStream(10)
+	_, ch, unsubscribe := stream.Subscribe(0, 2)
+	defer unsubscribe()
+
+	// The records that do not fit in the channel are dropped, the subscriber sees a gap in the sequence
+	publishEphemeral(stream, "0x01", "0x02", "0x03", "0x04")
+	if record := <-ch; record.Sequence != 1 {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	if record := <-ch; record.Sequence != 2 {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	publishEphemeral(stream, "0x05")
+	if record := <-ch; record.Sequence != 5 {
+		t.Fatalf("the dropped records were sent %+v", record)
+	}
+
+	// The missed records can be replayed
+	if replayed := sequences(stream.Replay(2)); len(replayed) != 3 || replayed[0] != 3 || replayed[1] != 4 {
+		t.Fatalf("unexpected replay of the dropped records %v", replayed)
+	}
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	for _, sub := range stream.subscribers {
+		if sub.dropped != 2 {
+			t.Errorf("unexpected dropped records %d", sub.dropped)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEventRetention is the amount of row events kept by a new database
+var DefaultEventRetention = 1024
+
+// Event is a row modification applied to the database (including the reverted blocks)
+type Event struct {
+	// Increases by one with each event, it is used as the cursor of EventsAfter
+	Sequence uint64
+	World    string
+	TableID  string
+	Table    string
+	Key      string
+	// Nil if the row was deleted
+	Fields  []Field
+	Deleted bool
+	// Log that modified the row, nil if it was not tracked (ie. a reverted block)
+	Provenance *Provenance
+	Time       time.Time
+}
+
+// Value renders the fields as a json like object, it is empty if the row was deleted
+func (e Event) Value() string {
+	if e.Deleted {
+		return ""
+	}
+	values := make([]string, 0, len(e.Fields))
+	for _, v := range e.Fields {
+		values = append(values, v.String())
+	}
+	return "{" + strings.Join(values, ",") + "}"
+}
+
+// eventLog keeps the last events in a ring buffer, the sequence is never reset
+type eventLog struct {
+	events   *ringBuffer[Event]
+	sequence uint64
+	mutex    *sync.Mutex
+}
+
+func newEventLog(capacity int) *eventLog {
+	return &eventLog{
+		events:   newRingBuffer[Event](capacity),
+		sequence: 0,
+		mutex:    &sync.Mutex{},
+	}
+}
+
+func (l *eventLog) append(event Event) Event {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sequence++
+	event.Sequence = l.sequence
+	l.events.push(event)
+	return event
+}
+
+// after returns false if some events after the cursor were already discarded
+func (l *eventLog) after(cursor uint64) ([]Event, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ret := []Event{}
+	for _, event := range l.events.list() {
+		if event.Sequence > cursor {
+			ret = append(ret, event)
+		}
+	}
+	if cursor >= l.sequence {
+		return ret, true
+	}
+	return ret, len(ret) > 0 && ret[0].Sequence == cursor+1
+}
+
+func (l *eventLog) latest(amount int) []Event {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ret := []Event{}
+	events := l.events.list()
+	for i := len(events) - 1; i >= 0 && len(ret) < amount; i-- {
+		ret = append(ret, events[i])
+	}
+	return ret
+}
+
+// setCapacity keeps the newest events that fit in the new capacity
+func (l *eventLog) setCapacity(capacity int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.events.setCapacity(capacity)
+}
+
+// clear removes the events, the cursors of the consumers stay valid because the sequence keeps increasing
+func (l *eventLog) clear() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.events.clear()
+}
+
+func (l *eventLog) copy() *eventLog {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return &eventLog{events: l.events.copy(), sequence: l.sequence, mutex: &sync.Mutex{}}
+}
+
+// SetEventRetention changes the amount of events kept, the oldest ones are discarded first
+func (db *Database) SetEventRetention(amount int) {
+	db.events.setCapacity(amount)
+}
+
+// LatestEvents returns up to `amount` events, from the newest to the oldest one
+func (db *Database) LatestEvents(amount int) []Event {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.events.latest(amount)
+}
+
+// EventsAfter returns the kept events with a sequence bigger than the cursor, from the oldest to the newest one (use 0 to get all of them).
+// It returns false if some of the events after the cursor were already discarded, the consumer must read the rows again.
+func (db *Database) EventsAfter(cursor uint64) ([]Event, bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.events.after(cursor)
+}
+
+// EventSequence is the sequence of the last event, the cursor to use to only get the new events
+func (db *Database) EventSequence() uint64 {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	db.events.mutex.Lock()
+	defer db.events.mutex.Unlock()
+	return db.events.sequence
+}
@@ -0,0 +1,44 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestEventsAfterEvictedCursor(t *testing.T) {
+	db := NewDatabase()
+	db.SetEventRetention(3)
+	table := testTable(db)
+	for height := uint64(1); height <= 5; height++ {
+		applyBlock(db, table, height, map[byte]int64{1: int64(height)})
+	}
+	if sequence := db.EventSequence(); sequence != 5 {
+		t.Fatalf("unexpected sequence %d", sequence)
+	}
+
+	// The events 2 to 5 can not be returned, the first kept one is 3
+	events, complete := db.EventsAfter(1)
+	if complete {
+		t.Error("the evicted events were not reported")
+	}
+	if len(events) != 3 || events[0].Sequence != 3 || events[2].Sequence != 5 || events[2].Value() != `{"f0":5}` {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	// The cursor of the event before the oldest kept one has no gap
+	if events, complete := db.EventsAfter(2); !complete || len(events) != 3 {
+		t.Fatalf("unexpected events after 2 (%t) %+v", complete, events)
+	}
+	if events, complete := db.EventsAfter(5); !complete || len(events) != 0 {
+		t.Fatalf("unexpected events after the last one (%t) %+v", complete, events)
+	}
+
+	// Once the events are cleared every older cursor has a gap, the sequence is not reset
+	db.events.clear()
+	if events, complete := db.EventsAfter(4); complete || len(events) != 0 {
+		t.Fatalf("unexpected events after clearing (%t) %+v", complete, events)
+	}
+	applyBlock(db, table, 6, map[byte]int64{1: 6})
+	if events, complete := db.EventsAfter(5); !complete || len(events) != 1 || events[0].Sequence != 6 {
+		t.Fatalf("unexpected new events (%t) %+v", complete, events)
+	}
+}
@@ -0,0 +1,266 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	levelDBRowsPrefix   = "rows/"
+	levelDBMetadataKey  = []byte("metadata")
+	levelDBStatusKey    = []byte("status")
+	levelDBBlocksPrefix = []byte("blocks/")
+)
+
+type levelDBRow struct {
+	Fields     []StoredField `json:"fields"`
+	Provenance *Provenance   `json:"provenance,omitempty"`
+}
+
+func levelDBTablePrefix(table string) []byte {
+	return []byte(levelDBRowsPrefix + table + "/")
+}
+
+func levelDBRowKey(table string, key string) []byte {
+	return append(levelDBTablePrefix(table), key...)
+}
+
+// levelDBBlockKey is padded so the blocks are iterated by height
+func levelDBBlockKey(height uint64) []byte {
+	return append(append([]byte{}, levelDBBlocksPrefix...), fmt.Sprintf("%020d", height)...)
+}
+
+func encodeLevelDBRow(row Row) ([]byte, error) {
+	fields, err := encodeFields(row.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(levelDBRow{Fields: fields, Provenance: row.Provenance})
+}
+
+func decodeLevelDBRow(encoded []byte) (Row, error) {
+	stored := levelDBRow{}
+	if err := json.Unmarshal(encoded, &stored); err != nil {
+		return Row{}, err
+	}
+	fields, err := decodeFields(stored.Fields)
+	if err != nil {
+		return Row{}, err
+	}
+	return Row{Fields: fields, Provenance: stored.Provenance}, nil
+}
+
+// levelDBSource is implemented by the database and its snapshots
+type levelDBSource interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+type levelDBReader struct {
+	source levelDBSource
+}
+
+func (r levelDBReader) GetRow(table string, key string) (Row, bool, error) {
+	encoded, err := r.source.Get(levelDBRowKey(table, key), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return Row{}, false, nil
+	}
+	if err != nil {
+		return Row{}, false, err
+	}
+	row, err := decodeLevelDBRow(encoded)
+	if err != nil {
+		return Row{}, false, fmt.Errorf("error decoding the row %s of table %s: %w", key, table, err)
+	}
+	return row, true, nil
+}
+
+func (r levelDBReader) IterateRows(table string, fn func(key string, row Row) bool) error {
+	prefix := levelDBTablePrefix(table)
+	it := r.source.NewIterator(util.BytesPrefix(prefix), nil)
+	defer it.Release()
+	for it.Next() {
+		key := string(it.Key()[len(prefix):])
+		row, err := decodeLevelDBRow(it.Value())
+		if err != nil {
+			return fmt.Errorf("error decoding the row %s of table %s: %w", key, table, err)
+		}
+		if !fn(key, row) {
+			break
+		}
+	}
+	return it.Error()
+}
+
+func (r levelDBReader) CountRows(table string) (int, error) {
+	it := r.source.NewIterator(util.BytesPrefix(levelDBTablePrefix(table)), nil)
+	defer it.Release()
+	count := 0
+	for it.Next() {
+		count++
+	}
+	return count, it.Error()
+}
+
+func (r levelDBReader) GetMetadata() ([]byte, bool, error) {
+	return r.get(levelDBMetadataKey)
+}
+
+func (r levelDBReader) GetStatus() ([]byte, bool, error) {
+	return r.get(levelDBStatusKey)
+}
+
+func (r levelDBReader) get(key []byte) ([]byte, bool, error) {
+	encoded, err := r.source.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return encoded, true, nil
+}
+
+func (r levelDBReader) GetBlocks() ([][]byte, error) {
+	it := r.source.NewIterator(util.BytesPrefix(levelDBBlocksPrefix), nil)
+	defer it.Release()
+	ret := [][]byte{}
+	for it.Next() {
+		ret = append(ret, append([]byte{}, it.Value()...))
+	}
+	return ret, it.Error()
+}
+
+// LevelDBStorage keeps the rows on disk, so the state survives restarts and does not have to fit in memory
+type LevelDBStorage struct {
+	levelDBReader
+	db *leveldb.DB
+}
+
+func NewLevelDBStorage(path string) (*LevelDBStorage, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the storage %s: %w", path, err)
+	}
+	return &LevelDBStorage{levelDBReader: levelDBReader{source: db}, db: db}, nil
+}
+
+func (s *LevelDBStorage) PutRow(table string, key string, row Row) error {
+	encoded, err := encodeLevelDBRow(row)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(levelDBRowKey(table, key), encoded, nil)
+}
+
+func (s *LevelDBStorage) DeleteRow(table string, key string) error {
+	return s.db.Delete(levelDBRowKey(table, key), nil)
+}
+
+// Begin keeps the writes in memory, they are written in a single batch on commit
+func (s *LevelDBStorage) Begin() StorageTransaction {
+	return newTransaction(s, func(writes transactionWrites) error {
+		batch := new(leveldb.Batch)
+		if writes.cleared {
+			if err := s.clear(batch); err != nil {
+				return err
+			}
+		}
+		for _, write := range writes.rows {
+			if write.deleted {
+				batch.Delete(levelDBRowKey(write.table, write.key))
+				continue
+			}
+			encoded, err := encodeLevelDBRow(write.row)
+			if err != nil {
+				return err
+			}
+			batch.Put(levelDBRowKey(write.table, write.key), encoded)
+		}
+		if writes.metadata != nil {
+			batch.Put(levelDBMetadataKey, writes.metadata)
+		}
+		if writes.status != nil {
+			batch.Put(levelDBStatusKey, writes.status)
+		}
+		for height, encoded := range writes.blocks {
+			if encoded == nil {
+				batch.Delete(levelDBBlockKey(height))
+			} else {
+				batch.Put(levelDBBlockKey(height), encoded)
+			}
+		}
+		return s.db.Write(batch, nil)
+	})
+}
+
+func (s *LevelDBStorage) Snapshot() (Storage, error) {
+	snapshot, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &levelDBSnapshot{levelDBReader: levelDBReader{source: snapshot}, snapshot: snapshot}, nil
+}
+
+func (s *LevelDBStorage) Clear() error {
+	batch := new(leveldb.Batch)
+	if err := s.clear(batch); err != nil {
+		return err
+	}
+	return s.db.Write(batch, nil)
+}
+
+// clear adds the deletion of every key to the batch
+func (s *LevelDBStorage) clear(batch *leveldb.Batch) error {
+	it := s.db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		batch.Delete(append([]byte{}, it.Key()...))
+	}
+	return it.Error()
+}
+
+func (s *LevelDBStorage) Close() error {
+	return s.db.Close()
+}
+
+var errReadOnlyStorage = errors.New("the storage snapshot is read only")
+
+// levelDBSnapshot is the read only storage returned by Snapshot, closing it releases the snapshot
+type levelDBSnapshot struct {
+	levelDBReader
+	snapshot *leveldb.Snapshot
+}
+
+func (s *levelDBSnapshot) PutRow(string, string, Row) error {
+	return errReadOnlyStorage
+}
+
+func (s *levelDBSnapshot) DeleteRow(string, string) error {
+	return errReadOnlyStorage
+}
+
+func (s *levelDBSnapshot) Begin() StorageTransaction {
+	return newTransaction(s, func(transactionWrites) error {
+		return errReadOnlyStorage
+	})
+}
+
+func (s *levelDBSnapshot) Snapshot() (Storage, error) {
+	return nil, errReadOnlyStorage
+}
+
+func (s *levelDBSnapshot) Clear() error {
+	return errReadOnlyStorage
+}
+
+func (s *levelDBSnapshot) Close() error {
+	s.snapshot.Release()
+	return nil
+}
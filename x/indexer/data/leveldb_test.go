@@ -0,0 +1,176 @@
+package data
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func openLevelDB(t *testing.T, path string) *Database {
+	storage, err := NewLevelDBStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewDatabaseWithStorage(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestLevelDBKeepsTrackedBlocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openLevelDB(t, path)
+	table := testTable(db)
+	applyBlock(db, table, 1, map[byte]int64{1: 1})
+	applyBlock(db, table, 2, map[byte]int64{1: 2, 2: 2})
+	applyBlock(db, table, 3, map[byte]int64{1: 3})
+	db.SetConfirmedHeight(1)
+	db.Update(func() {})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openLevelDB(t, path)
+	if info := reopened.Info(); info.ConfirmedHeight != 1 {
+		t.Errorf("the confirmed height was not restored: %d", info.ConfirmedHeight)
+	}
+	if last := reopened.LastProcessedBlock(); last == nil || last.Height != 3 || last.Hash != (common.Hash{31: 3}) {
+		t.Fatalf("unexpected last processed block %v", last)
+	}
+
+	// The reorg is reverted with the stored journal instead of clearing the storage
+	table = reopened.GetTable(table.Metadata.WorldAddress, table.Metadata.TableID)
+	if err := reopened.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	key1 := "0x" + common.Bytes2Hex([]byte{1})
+	key2 := "0x" + common.Bytes2Hex([]byte{2})
+	if value := rowValue(t, reopened, table, key1); value != `"f0":1` {
+		t.Errorf("the row was not reverted: %q", value)
+	}
+	if value := rowValue(t, reopened, table, key2); value != "" {
+		t.Errorf("the row added after the ancestor was kept: %q", value)
+	}
+	applyBlock(reopened, table, 2, map[byte]int64{2: 20})
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reverted blocks were removed from the storage
+	reopened = openLevelDB(t, path)
+	defer reopened.Close()
+	table = reopened.GetTable(table.Metadata.WorldAddress, table.Metadata.TableID)
+	if err := reopened.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if value := rowValue(t, reopened, table, key2); value != "" {
+		t.Errorf("the row of the new block was not reverted: %q", value)
+	}
+	if last := reopened.LastProcessedBlock(); last == nil || last.Height != 1 {
+		t.Fatalf("unexpected last processed block %v", last)
+	}
+}
+
+func TestLevelDBCommitError(t *testing.T) {
+	storage, err := NewLevelDBStorage(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewDatabaseWithStorage(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := testTable(db)
+	applyBlock(db, table, 1, nil)
+	if err := db.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+	applyBlock(db, table, 2, nil)
+	if db.Err() == nil {
+		t.Fatal("the failed write was not reported")
+	}
+}
+
+func TestLevelDBMetadataOnlyWhenChanged(t *testing.T) {
+	storage, err := NewLevelDBStorage(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewDatabaseWithStorage(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	table := testTable(db)
+	applyBlock(db, table, 1, map[byte]int64{1: 1})
+	metadata, found, err := storage.GetMetadata()
+	if err != nil || !found {
+		t.Fatalf("the metadata was not saved: %v", err)
+	}
+
+	// The blocks only update the status, the tables are not encoded again
+	if err := storage.db.Delete(levelDBMetadataKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	applyBlock(db, table, 2, map[byte]int64{1: 2})
+	if _, found, err := storage.GetMetadata(); err != nil || found {
+		t.Fatalf("the metadata was written without changes: %v", err)
+	}
+	if status, found, err := storage.GetStatus(); err != nil || !found || !strings.Contains(string(status), `"height":2`) {
+		t.Fatalf("unexpected status %s: %v", status, err)
+	}
+
+	addDeadLetter(db, 2, 1)
+	updated, found, err := storage.GetMetadata()
+	if err != nil || !found || bytes.Equal(updated, metadata) {
+		t.Fatalf("the dead letter was not saved: %v", err)
+	}
+}
+
+func TestLevelDBResetInTransaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openLevelDB(t, path)
+	table := testTable(db)
+	applyBlock(db, table, 1, map[byte]int64{1: 1, 2: 2})
+
+	// The rows written after the reset are committed with it
+	db.Update(func() {
+		db.reset()
+		table = testTable(db)
+		db.startBlock(5, common.Hash{31: 5})
+		db.AddRow(table, []byte{3}, uintFields("f0", 3))
+	})
+	key := func(id byte) string {
+		return "0x" + common.Bytes2Hex([]byte{id})
+	}
+	if value := rowValue(t, db, table, key(1)); value != "" {
+		t.Errorf("the row was not cleared: %q", value)
+	}
+	if value := rowValue(t, db, table, key(3)); value != `"f0":3` {
+		t.Errorf("the row written after the reset was lost: %q", value)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openLevelDB(t, path)
+	defer reopened.Close()
+	table = reopened.GetTable(table.Metadata.WorldAddress, table.Metadata.TableID)
+	if value := rowValue(t, reopened, table, key(2)); value != "" {
+		t.Errorf("the row was not cleared: %q", value)
+	}
+	if value := rowValue(t, reopened, table, key(3)); value != `"f0":3` {
+		t.Errorf("the row written after the reset was lost: %q", value)
+	}
+	if last := reopened.LastProcessedBlock(); last == nil || last.Height != 5 {
+		t.Fatalf("unexpected last processed block %v", last)
+	}
+}
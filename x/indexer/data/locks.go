@@ -0,0 +1,206 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// rowUpdate is an update handler call delayed until the write lock is released
+type rowUpdate struct {
+	table  string
+	key    string
+	fields *[]Field
+}
+
+// DatabaseInfo is the chain status of the database, read at once
+type DatabaseInfo struct {
+	ChainID         string
+	LastHeight      uint64
+	ConfirmedHeight uint64
+	LastUpdate      time.Time
+}
+
+// lock takes the write lock, the rows modified until unlock are visible at once
+func (db *Database) lock() {
+	db.mutex.Lock()
+	db.writing = true
+	db.tx = db.storage.Begin()
+}
+
+// unlock commits the storage transaction, queues the changes for the sink, releases the write lock and sends the delayed updates to the handler
+func (db *Database) unlock() {
+	db.saveMetadata()
+	if err := db.tx.Commit(); err != nil {
+		logger.LogError(fmt.Sprintf("[indexer] error writing the rows to the storage: %s", err))
+		if db.storageErr == nil {
+			db.storageErr = fmt.Errorf("error writing the rows to the storage: %w", err)
+		}
+	}
+	db.tx = nil
+
+	// The batches are queued with the write lock so they are applied in order
+	if db.sinkWorker != nil {
+		if job, send := db.sinkBatch(); send {
+			db.sinkWorker.enqueue(job)
+		}
+	}
+
+	for _, event := range db.pendingEvents {
+		db.events.append(event)
+	}
+	db.pendingEvents = []Event{}
+
+	notifications := db.notifications
+	db.notifications = []rowUpdate{}
+	db.currentLog = nil
+	db.writing = false
+	db.mutex.Unlock()
+
+	if db.updateHandler != nil {
+		for _, v := range notifications {
+			(*db.updateHandler)(v.table, v.key, v.fields)
+		}
+	}
+}
+
+// BeginBlock takes the write lock and sets the block that owns the row modifications.
+// The readers do not see any change of the block until EndBlock is called.
+func (db *Database) BeginBlock(height uint64, hash common.Hash) {
+	db.lock()
+	db.startBlock(height, hash)
+}
+
+// EndBlock releases the lock taken by BeginBlock, the update handler is called with the modified rows
+func (db *Database) EndBlock() {
+	db.unlock()
+}
+
+// Update calls fn with the write lock, used to modify the rows outside of a block (like retrying a dead letter).
+// The database queries can not be used inside fn.
+func (db *Database) Update(fn func()) {
+	db.lock()
+	defer db.unlock()
+	fn()
+}
+
+// Err returns the first error writing to the storage, the indexer must stop because the stored state is not valid anymore
+func (db *Database) Err() error {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.storageErr
+}
+
+func (db *Database) Info() DatabaseInfo {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return DatabaseInfo{
+		ChainID:         db.ChainID,
+		LastHeight:      db.LastHeight,
+		ConfirmedHeight: db.ConfirmedHeight,
+		LastUpdate:      db.LastUpdate,
+	}
+}
+
+func (db *Database) SetLastHeight(height uint64) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.LastHeight = height
+}
+
+func (db *Database) SetChainID(chainID string) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.ChainID != chainID {
+		db.setMetadataDirty()
+		// The namespace of the mirrored tables changed
+		db.sinkReset = db.sink != nil || db.sinkReset
+	}
+	db.ChainID = chainID
+}
+
+// FindWorld returns the world without registering it if it does not exist, the address is not case sensitive
+func (db *Database) FindWorld(worldID string) *World {
+	for _, world := range db.worldList() {
+		if strings.EqualFold(world.Address, worldID) {
+			return world
+		}
+	}
+	return nil
+}
+
+func (db *Database) worldList() []*World {
+	db.worldsMutex.Lock()
+	defer db.worldsMutex.Unlock()
+	ret := make([]*World, 0, len(db.Worlds))
+	for _, world := range db.Worlds {
+		ret = append(ret, world)
+	}
+	return ret
+}
+
+func copyTable(table *Table) *Table {
+	metadata := *table.Metadata
+	fieldNames := make([]string, len(*table.Schema.FieldNames))
+	copy(fieldNames, *table.Schema.FieldNames)
+	keyNames := make([]string, len(*table.Schema.KeyNames))
+	copy(keyNames, *table.Schema.KeyNames)
+	namedFields := map[string]mudhelpers.SchemaType{}
+	for k, v := range *table.Schema.NamedFields {
+		namedFields[k] = v
+	}
+
+	return &Table{
+		Metadata: &metadata,
+		// The schema is replaced when a table is registered again
+		Schema: &TableSchema{FieldNames: &fieldNames, KeyNames: &keyNames, Schema: table.Schema.Schema, NamedFields: &namedFields},
+	}
+}
+
+// Snapshot returns a copy of the database with every applied block, it can be queried while the indexer keeps running.
+// The handlers and dead letters are not copied, the ephemeral stream is shared. Close releases the storage snapshot.
+func (db *Database) Snapshot() (*Database, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	storage, err := db.storage.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	ret := newDatabase(storage)
+	ret.events = db.events.copy()
+	ret.LastUpdate = db.LastUpdate
+	ret.LastHeight = db.LastHeight
+	ret.ConfirmedHeight = db.ConfirmedHeight
+	ret.ChainID = db.ChainID
+	ret.defaultWorld = db.defaultWorld
+	ret.Ephemeral = db.Ephemeral
+	ret.UnconfirmedTransactions = db.PendingTransactions()
+
+	tables := map[*Table]*Table{}
+	for _, world := range db.worldList() {
+		copied := &World{Address: world.Address, Tables: map[string]*Table{}, db: ret}
+		for _, table := range world.tableList() {
+			tables[table] = copyTable(table)
+			copied.Tables[table.Metadata.TableID] = tables[table]
+		}
+		ret.Worlds[world.Address] = copied
+	}
+
+	// The journal is needed by the confirmed view
+	for _, block := range db.processedBlocks {
+		changes := make([]rowChange, 0, len(block.changes))
+		for _, change := range block.changes {
+			if table, ok := tables[change.table]; ok {
+				change.table = table
+				changes = append(changes, change)
+			}
+		}
+		ret.processedBlocks = append(ret.processedBlocks, &ProcessedBlock{Height: block.Height, Hash: block.Hash, changes: changes})
+	}
+	return ret, nil
+}
@@ -0,0 +1,103 @@
+package data
+
+import "sync"
+
+// MemoryStorage keeps the rows in maps, it is the default storage and it is lost on restart
+type MemoryStorage struct {
+	tables map[string]map[string]Row
+	mutex  *sync.RWMutex
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{tables: map[string]map[string]Row{}, mutex: &sync.RWMutex{}}
+}
+
+func (s *MemoryStorage) GetRow(table string, key string) (Row, bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	row, ok := s.tables[table][key]
+	return row, ok, nil
+}
+
+func (s *MemoryStorage) IterateRows(table string, fn func(key string, row Row) bool) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for key, row := range s.tables[table] {
+		if !fn(key, row) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) CountRows(table string) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.tables[table]), nil
+}
+
+// put must be called with the lock
+func (s *MemoryStorage) put(table string, key string, row Row) {
+	if _, ok := s.tables[table]; !ok {
+		s.tables[table] = map[string]Row{}
+	}
+	s.tables[table][key] = row
+}
+
+func (s *MemoryStorage) PutRow(table string, key string, row Row) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.put(table, key, row)
+	return nil
+}
+
+func (s *MemoryStorage) DeleteRow(table string, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.tables[table], key)
+	return nil
+}
+
+func (s *MemoryStorage) Begin() StorageTransaction {
+	return newTransaction(s, func(writes transactionWrites) error {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if writes.cleared {
+			s.tables = map[string]map[string]Row{}
+		}
+		for _, write := range writes.rows {
+			if write.deleted {
+				delete(s.tables[write.table], write.key)
+			} else {
+				s.put(write.table, write.key, write.row)
+			}
+		}
+		return nil
+	})
+}
+
+// Snapshot copies the maps, the rows are never modified in place so their fields are shared
+func (s *MemoryStorage) Snapshot() (Storage, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ret := NewMemoryStorage()
+	for table, rows := range s.tables {
+		copied := make(map[string]Row, len(rows))
+		for key, row := range rows {
+			copied[key] = row
+		}
+		ret.tables[table] = copied
+	}
+	return ret, nil
+}
+
+func (s *MemoryStorage) Clear() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tables = map[string]map[string]Row{}
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
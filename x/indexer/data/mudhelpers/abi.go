@@ -11,6 +11,12 @@ import (
 var (
 	StorecoreAbi abi.ABI
 	events       []string = []string{"StoreSetRecord", "StoreSetField", "StoreDeleteRecord"}
+
+	// StoreEventsAbi is used by the worlds deployed with the released MUD v2 version
+	StoreEventsAbi abi.ABI
+
+	// StoreEphemeralAbi is used by the MUD v1 ephemeral tables
+	StoreEphemeralAbi abi.ABI
 )
 
 func init() {
@@ -20,12 +26,32 @@ func init() {
 		logger.LogError("failed to parse the store ABI")
 		panic("")
 	}
+
+	StoreEventsAbi, err = abi.JSON(strings.NewReader(StoreEventsABI))
+	if err != nil {
+		logger.LogError("failed to parse the store events ABI")
+		panic("")
+	}
+
+	StoreEphemeralAbi, err = abi.JSON(strings.NewReader(StoreEphemeralABI))
+	if err != nil {
+		logger.LogError("failed to parse the store ephemeral ABI")
+		panic("")
+	}
 }
 
 func GetStoreAbiEventID(eventName string) common.Hash {
 	return StorecoreAbi.Events[eventName].ID
 }
 
+func GetStoreEventsAbiEventID(eventName string) common.Hash {
+	return StoreEventsAbi.Events[eventName].ID
+}
+
+func GetStoreEphemeralRecordEventID() common.Hash {
+	return StoreEphemeralAbi.Events["StoreEphemeralRecord"].ID
+}
+
 func (event *StorecoreStoreSetRecord) WorldAddress() string {
 	return event.Raw.Address.Hex()
 }
@@ -713,9 +713,12 @@ func SchemaTypeToSolidityType(schemaType SchemaType) string {
 // Returns:
 // (string) - A string representing the PostgreSQL type for the specified SchemaType instance.
 func SchemaTypeToPostgresType(schemaType SchemaType) string {
-	if (schemaType >= UINT8 && schemaType <= UINT32) || (schemaType >= INT8 && schemaType <= INT32) {
+	if (schemaType >= UINT8 && schemaType <= UINT24) || (schemaType >= INT8 && schemaType <= INT32) {
 		// Integer.
 		return "integer"
+	} else if schemaType == UINT32 {
+		// The biggest uint32 values do not fit in an integer.
+		return "bigint"
 	} else if (schemaType >= UINT64 && schemaType <= UINT256) || (schemaType >= INT64 && schemaType <= INT256) {
 		// Big integer.
 		return "text"
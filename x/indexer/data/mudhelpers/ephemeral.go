@@ -0,0 +1,26 @@
+package mudhelpers
+
+import (
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// StoreEphemeralABI has the event emitted by the MUD v1 ephemeral tables, the records are never stored on chain
+const StoreEphemeralABI = `[
+	{"anonymous":false,"inputs":[
+		{"indexed":false,"internalType":"bytes32","name":"tableId","type":"bytes32"},
+		{"indexed":false,"internalType":"bytes32[]","name":"key","type":"bytes32[]"},
+		{"indexed":false,"internalType":"bytes","name":"data","type":"bytes"}
+	],"name":"StoreEphemeralRecord","type":"event"}
+]`
+
+// StorecoreStoreEphemeralRecord represents a StoreEphemeralRecord event
+type StorecoreStoreEphemeralRecord struct {
+	TableId [32]byte
+	Key     [][32]byte
+	Data    []byte
+	Raw     types.Log
+}
+
+func (event *StorecoreStoreEphemeralRecord) WorldAddress() string {
+	return event.Raw.Address.Hex()
+}
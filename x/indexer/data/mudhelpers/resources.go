@@ -0,0 +1,116 @@
+package mudhelpers
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MUD v2 resource ids are 2 bytes with the resource type, 14 bytes with the namespace and 16 bytes with the name
+const (
+	ResourceTable         = "tb"
+	ResourceOffchainTable = "ot"
+	ResourceSystem        = "sy"
+	ResourceNamespace     = "ns"
+)
+
+type ResourceID struct {
+	Type      string
+	Namespace string
+	Name      string
+}
+
+func DecodeResourceID(id [32]byte) ResourceID {
+	return ResourceID{
+		Type:      strings.Trim(string(id[:2]), "\u0000"),
+		Namespace: strings.Trim(string(id[2:16]), "\u0000"),
+		Name:      strings.Trim(string(id[16:32]), "\u0000"),
+	}
+}
+
+func EncodeResourceID(resourceType string, namespace string, name string) [32]byte {
+	var ret [32]byte
+	copy(ret[:2], resourceType)
+	copy(ret[2:16], namespace)
+	copy(ret[16:32], name)
+	return ret
+}
+
+func (r ResourceID) IsTable() bool {
+	return r.Type == ResourceTable || r.Type == ResourceOffchainTable
+}
+
+// TableName uses the same format as TableIdToTableName, the namespace and the name joined with `__`
+func (r ResourceID) TableName() string {
+	return r.Namespace + CONNECTOR + r.Name
+}
+
+func (r ResourceID) String() string {
+	return fmt.Sprintf("%s:%s:%s", r.Type, r.Namespace, r.Name)
+}
+
+// ResourceIdToTableName is TableIdToTableName for the MUD v2 resource ids
+func ResourceIdToTableName(tableId [32]byte) string {
+	return DecodeResourceID(tableId).TableName()
+}
+
+func StoreTablesId() string {
+	return PaddedTableId(EncodeResourceID(ResourceTable, "store", "Tables"))
+}
+
+// StoreResourceIdsId is the table with every registered resource, its records are filtered by the resource like store:Tables
+func StoreResourceIdsId() string {
+	return PaddedTableId(EncodeResourceID(ResourceTable, "store", "ResourceIds"))
+}
+
+// StoreTablesSchema is the schema of the store:Tables table, it is needed before the table registers itself
+func StoreTablesSchema() *SchemaTypeKV {
+	return &SchemaTypeKV{
+		Key: &SchemaTypePair{Static: []SchemaType{BYTES32}, Dynamic: []SchemaType{}, StaticDataLength: 32},
+		Value: &SchemaTypePair{
+			Static:           []SchemaType{BYTES32, BYTES32, BYTES32},
+			Dynamic:          []SchemaType{BYTES, BYTES},
+			StaticDataLength: 96,
+		},
+	}
+}
+
+func StoreTablesFieldNames() []string {
+	return []string{"fieldlayout", "keyschema", "valueschema", "abiencodedkeynames", "abiencodedfieldnames"}
+}
+
+// FieldLayout is the byte length of each static field and the amount of dynamic fields of a MUD v2 table
+type FieldLayout struct {
+	StaticDataLength   uint64
+	StaticFieldLengths []uint64
+	NumDynamicFields   uint64
+}
+
+func DecodeFieldLayout(encoding []byte) FieldLayout {
+	encoding = common.RightPadBytes(encoding, 32)
+	numStaticFields := uint64(encoding[2])
+	ret := FieldLayout{
+		StaticDataLength:   new(big.Int).SetBytes(encoding[0:2]).Uint64(),
+		StaticFieldLengths: []uint64{},
+		NumDynamicFields:   uint64(encoding[3]),
+	}
+	for i := uint64(0); i < numStaticFields && 4+i < 32; i++ {
+		ret.StaticFieldLengths = append(ret.StaticFieldLengths, uint64(encoding[4+i]))
+	}
+	return ret
+}
+
+// Matches validates that the field layout has the same lengths as the value schema
+func (l FieldLayout) Matches(schemaTypePair *SchemaTypePair) bool {
+	if len(l.StaticFieldLengths) != len(schemaTypePair.Static) || int(l.NumDynamicFields) != len(schemaTypePair.Dynamic) {
+		return false
+	}
+	for i, schemaType := range schemaTypePair.Static {
+		if GetStaticByteLength(schemaType) != l.StaticFieldLengths[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package mudhelpers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeResourceID(t *testing.T) {
+	id := EncodeResourceID(ResourceOffchainTable, "game", "Counter")
+	resource := DecodeResourceID(id)
+	if resource != (ResourceID{Type: ResourceOffchainTable, Namespace: "game", Name: "Counter"}) {
+		t.Fatalf("unexpected resource %+v", resource)
+	}
+	if !resource.IsTable() || resource.TableName() != "game__Counter" || resource.String() != "ot:game:Counter" {
+		t.Errorf("unexpected resource names %s %s", resource.TableName(), resource.String())
+	}
+
+	// The root namespace is empty and the names are truncated to their bytes
+	root := DecodeResourceID(EncodeResourceID(ResourceTable, "", "AVeryLongTableNameThatIsTruncated"))
+	if root.Namespace != "" || root.Name != "AVeryLongTableNa" || root.TableName() != "__AVeryLongTableNa" {
+		t.Errorf("unexpected root resource %+v", root)
+	}
+	if DecodeResourceID(EncodeResourceID(ResourceSystem, "game", "MoveSystem")).IsTable() {
+		t.Error("a system is not a table")
+	}
+}
+
+func TestDecodeFieldLayout(t *testing.T) {
+	// uint32 and bool static fields and two dynamic fields
+	encoding := []byte{0, 5, 2, 2, 4, 1}
+	layout := DecodeFieldLayout(encoding)
+	expected := FieldLayout{StaticDataLength: 5, StaticFieldLengths: []uint64{4, 1}, NumDynamicFields: 2}
+	if !reflect.DeepEqual(layout, expected) {
+		t.Fatalf("unexpected field layout %+v", layout)
+	}
+
+	schema := &SchemaTypePair{Static: []SchemaType{UINT32, BOOL}, Dynamic: []SchemaType{STRING, BYTES}}
+	if !layout.Matches(schema) {
+		t.Error("the field layout does not match its schema")
+	}
+	schema.Static[0] = UINT64
+	if layout.Matches(schema) {
+		t.Error("the field layout matches a schema with other lengths")
+	}
+	if layout.Matches(&SchemaTypePair{Static: []SchemaType{UINT32, BOOL}, Dynamic: []SchemaType{STRING}}) {
+		t.Error("the field layout matches a schema with other dynamic fields")
+	}
+
+	// The static field lengths never read past the word
+	full := make([]byte, 32)
+	full[2] = 255
+	if lengths := DecodeFieldLayout(full).StaticFieldLengths; len(lengths) != 28 {
+		t.Errorf("unexpected static field lengths %v", lengths)
+	}
+}
@@ -0,0 +1,118 @@
+package mudhelpers
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// StoreEventsABI has the events emitted by the released MUD v2 store (IStoreEvents.sol)
+const StoreEventsABI = `[
+	{"anonymous":false,"inputs":[
+		{"indexed":true,"internalType":"ResourceId","name":"tableId","type":"bytes32"},
+		{"indexed":false,"internalType":"bytes32[]","name":"keyTuple","type":"bytes32[]"},
+		{"indexed":false,"internalType":"bytes","name":"staticData","type":"bytes"},
+		{"indexed":false,"internalType":"PackedCounter","name":"encodedLengths","type":"bytes32"},
+		{"indexed":false,"internalType":"bytes","name":"dynamicData","type":"bytes"}
+	],"name":"Store_SetRecord","type":"event"},
+	{"anonymous":false,"inputs":[
+		{"indexed":true,"internalType":"ResourceId","name":"tableId","type":"bytes32"},
+		{"indexed":false,"internalType":"bytes32[]","name":"keyTuple","type":"bytes32[]"},
+		{"indexed":false,"internalType":"uint48","name":"start","type":"uint48"},
+		{"indexed":false,"internalType":"bytes","name":"data","type":"bytes"}
+	],"name":"Store_SpliceStaticData","type":"event"},
+	{"anonymous":false,"inputs":[
+		{"indexed":true,"internalType":"ResourceId","name":"tableId","type":"bytes32"},
+		{"indexed":false,"internalType":"bytes32[]","name":"keyTuple","type":"bytes32[]"},
+		{"indexed":false,"internalType":"uint8","name":"dynamicFieldIndex","type":"uint8"},
+		{"indexed":false,"internalType":"uint48","name":"start","type":"uint48"},
+		{"indexed":false,"internalType":"uint40","name":"deleteCount","type":"uint40"},
+		{"indexed":false,"internalType":"PackedCounter","name":"encodedLengths","type":"bytes32"},
+		{"indexed":false,"internalType":"bytes","name":"data","type":"bytes"}
+	],"name":"Store_SpliceDynamicData","type":"event"},
+	{"anonymous":false,"inputs":[
+		{"indexed":true,"internalType":"ResourceId","name":"tableId","type":"bytes32"},
+		{"indexed":false,"internalType":"bytes32[]","name":"keyTuple","type":"bytes32[]"}
+	],"name":"Store_DeleteRecord","type":"event"}
+]`
+
+// StoreEventsSetRecord represents a Store_SetRecord event
+type StoreEventsSetRecord struct {
+	TableId        [32]byte
+	KeyTuple       [][32]byte
+	StaticData     []byte
+	EncodedLengths [32]byte
+	DynamicData    []byte
+	Raw            types.Log
+}
+
+// StoreEventsSpliceStaticData represents a Store_SpliceStaticData event
+type StoreEventsSpliceStaticData struct {
+	TableId  [32]byte
+	KeyTuple [][32]byte
+	Start    *big.Int
+	Data     []byte
+	Raw      types.Log
+}
+
+// StoreEventsSpliceDynamicData represents a Store_SpliceDynamicData event
+type StoreEventsSpliceDynamicData struct {
+	TableId           [32]byte
+	KeyTuple          [][32]byte
+	DynamicFieldIndex uint8
+	Start             *big.Int
+	DeleteCount       *big.Int
+	EncodedLengths    [32]byte
+	Data              []byte
+	Raw               types.Log
+}
+
+// StoreEventsDeleteRecord represents a Store_DeleteRecord event
+type StoreEventsDeleteRecord struct {
+	TableId  [32]byte
+	KeyTuple [][32]byte
+	Raw      types.Log
+}
+
+func (event *StoreEventsSetRecord) WorldAddress() string {
+	return event.Raw.Address.Hex()
+}
+
+func (event *StoreEventsSpliceStaticData) WorldAddress() string {
+	return event.Raw.Address.Hex()
+}
+
+func (event *StoreEventsSpliceDynamicData) WorldAddress() string {
+	return event.Raw.Address.Hex()
+}
+
+func (event *StoreEventsDeleteRecord) WorldAddress() string {
+	return event.Raw.Address.Hex()
+}
+
+// DecodeEncodedLengths unpacks a PackedCounter: the total length uses the 7 lowest bytes
+// and each dynamic field length uses the next 5 bytes, starting with the first field
+func DecodeEncodedLengths(encoded [32]byte, fields int) (uint64, []uint64) {
+	total := new(big.Int).SetBytes(encoded[25:32]).Uint64()
+	lengths := make([]uint64, fields)
+	for i := 0; i < fields && i < 5; i++ {
+		end := 25 - i*5
+		lengths[i] = new(big.Int).SetBytes(encoded[end-5 : end]).Uint64()
+	}
+	return total, lengths
+}
+
+func EncodeLengths(lengths []uint64) [32]byte {
+	ret := [32]byte{}
+	total := uint64(0)
+	for i, length := range lengths {
+		if i >= 5 {
+			break
+		}
+		total += length
+		end := 25 - i*5
+		new(big.Int).SetUint64(length).FillBytes(ret[end-5 : end])
+	}
+	new(big.Int).SetUint64(total).FillBytes(ret[25:32])
+	return ret
+}
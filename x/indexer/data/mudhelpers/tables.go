@@ -51,6 +51,16 @@ func Namespace(chainID string, worldAddress string) string {
 	return str.String()
 }
 
+// ParseNamespace returns the chain id and the world address used to build the namespace
+func ParseNamespace(namespace string) (string, string, error) {
+	parts := strings.Split(namespace, CONNECTOR)
+	if len(parts) != 3 || parts[0] != TABLE_PREFIX {
+		return "", "", fmt.Errorf("invalid namespace: %s", namespace)
+	}
+	return parts[1], parts[2], nil
+}
+
+// TableIdToTableName decodes the MUD v1 table ids (16 bytes namespace and 16 bytes name), use ResourceIdToTableName for v2
 func TableIdToTableName(tableId string) string {
 	// Table ID comes in as a uint256 in string format comprised of two bytes16s
 	// concatenated.
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bocha-io/logger"
+)
+
+const (
+	TxExpired  = "expired"
+	TxReverted = "reverted"
+)
+
+// SetTxFailedHandler is called when the optimistic overlay of a transaction is discarded because it expired or reverted
+func (db *Database) SetTxFailedHandler(handler func(tx UnconfirmedTransaction, reason string)) {
+	db.txFailedHandler = &handler
+}
+
+func (db *Database) PendingTransactions() []UnconfirmedTransaction {
+	db.txSentMutex.Lock()
+	defer db.txSentMutex.Unlock()
+	ret := make([]UnconfirmedTransaction, len(db.UnconfirmedTransactions))
+	copy(ret, db.UnconfirmedTransactions)
+	return ret
+}
+
+// DiscardUnconfirmedTransaction removes the overlay and notifies the handler, it returns false if the tx was already removed
+func (db *Database) DiscardUnconfirmedTransaction(txHash string, reason string) bool {
+	tx, ok := db.TakeUnconfirmedTransaction(txHash)
+	if !ok {
+		return false
+	}
+
+	logger.LogInfo(fmt.Sprintf("[indexer] discarding the predicted events of tx %s: %s", txHash, reason))
+	if db.txFailedHandler != nil {
+		(*db.txFailedHandler)(tx, reason)
+	}
+	return true
+}
+
+// SetReceiptChecked records when the receipt of the transaction was requested
+func (db *Database) SetReceiptChecked(txHash string, checkedAt time.Time) {
+	db.txSentMutex.Lock()
+	defer db.txSentMutex.Unlock()
+	for k, v := range db.UnconfirmedTransactions {
+		if v.Txhash == txHash {
+			db.UnconfirmedTransactions[k].ReceiptCheckedAt = checkedAt
+			return
+		}
+	}
+}
+
+// ExpireUnconfirmedTransactions discards the transactions sent more than maxBlocks blocks or maxAge ago, zero disables each limit.
+// The transactions sent before the first block was synced count the blocks from the current height.
+func (db *Database) ExpireUnconfirmedTransactions(height uint64, maxBlocks uint64, maxAge time.Duration) int {
+	db.txSentMutex.Lock()
+	for k, v := range db.UnconfirmedTransactions {
+		if v.SentHeight == 0 {
+			db.UnconfirmedTransactions[k].SentHeight = height
+		}
+	}
+	db.txSentMutex.Unlock()
+
+	expired := []string{}
+	for _, tx := range db.PendingTransactions() {
+		if maxBlocks > 0 && height > tx.SentHeight && height-tx.SentHeight > maxBlocks {
+			expired = append(expired, tx.Txhash)
+		} else if maxAge > 0 && time.Since(tx.SentAt) > maxAge {
+			expired = append(expired, tx.Txhash)
+		}
+	}
+
+	count := 0
+	for _, txHash := range expired {
+		if db.DiscardUnconfirmedTransaction(txHash, TxExpired) {
+			count++
+		}
+	}
+	return count
+}
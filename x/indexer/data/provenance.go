@@ -0,0 +1,65 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Provenance is the log that last modified a row
+type Provenance struct {
+	BlockNumber uint64 `json:"block_number"`
+	// Unix time of the block, 0 if the header was not available (replayed logs)
+	Timestamp uint64 `json:"timestamp"`
+	TxHash    string `json:"tx_hash"`
+	LogIndex  uint   `json:"log_index"`
+}
+
+func NewProvenance(log types.Log, timestamp uint64) Provenance {
+	return Provenance{
+		BlockNumber: log.BlockNumber,
+		Timestamp:   timestamp,
+		TxHash:      log.TxHash.Hex(),
+		LogIndex:    log.Index,
+	}
+}
+
+// After reports whether the log was emitted after the other one
+func (p Provenance) After(other Provenance) bool {
+	if p.BlockNumber != other.BlockNumber {
+		return p.BlockNumber > other.BlockNumber
+	}
+	return p.LogIndex > other.LogIndex
+}
+
+func (p Provenance) String() string {
+	when := "unknown time"
+	if p.Timestamp != 0 {
+		when = time.Unix(int64(p.Timestamp), 0).UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("block %d (%s), tx %s, log %d", p.BlockNumber, when, p.TxHash, p.LogIndex)
+}
+
+// SetCurrentLog sets the log that owns the row modifications until the next call, nil stops tracking them.
+// It must be called with the write lock, like the row modifications.
+func (db *Database) SetCurrentLog(provenance *Provenance) {
+	db.currentLog = provenance
+}
+
+// GetProvenance returns the log that last modified the confirmed or unconfirmed row, the mempool is not used
+func (db *Database) GetProvenance(table *Table, key string) (Provenance, error) {
+	if table == nil {
+		return Provenance{}, fmt.Errorf("table not found")
+	}
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	row, ok, err := db.rows().GetRow(storageID(table), key)
+	if err != nil {
+		return Provenance{}, err
+	}
+	if ok && row.Provenance != nil {
+		return *row.Provenance, nil
+	}
+	return Provenance{}, fmt.Errorf("provenance not found")
+}
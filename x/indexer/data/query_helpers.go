@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 // Returns the field object, the key as string and error
@@ -86,3 +88,32 @@ func GetBoolFromTable(db *Database, w *World, rowID string, tableName string) bo
 	}
 	return value == "true"
 }
+
+func GetConfirmedRowFieldsUsingString(db *Database, w *World, rowID string, tableName string) ([]Field, error) {
+	table := w.GetTableByName(tableName)
+	row, err := db.GetConfirmedRow(table, rowID)
+	if err != nil {
+		return []Field{}, fmt.Errorf("error getting the confirmed row from the table %s: %s", tableName, err.Error())
+	}
+	return row, nil
+}
+
+func GetConfirmedRows(db *Database, w *World, tableName string) map[string][]Field {
+	table := w.GetTableByName(tableName)
+	rows := db.GetConfirmedRows(table)
+	return rows
+}
+
+// GetRowProvenanceUsingString returns the block, time, transaction and log that last modified the row
+func GetRowProvenanceUsingString(db *Database, w *World, rowID string, tableName string) (Provenance, error) {
+	table := w.GetTableByName(tableName)
+	provenance, err := db.GetProvenance(table, rowID)
+	if err != nil {
+		return Provenance{}, fmt.Errorf("error getting the provenance of the row from the table %s: %s", tableName, err.Error())
+	}
+	return provenance, nil
+}
+
+func GetRowProvenanceUsingBytes(db *Database, w *World, rowID [32]byte, tableName string) (Provenance, error) {
+	return GetRowProvenanceUsingString(db, w, hexutil.Encode(rowID[:]), tableName)
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/bocha-io/logger"
+)
+
+// PredictionMismatch is sent when the events predicted for a transaction do not match the confirmed logs.
+// Actual is nil when the predicted row was not modified by the transaction.
+type PredictionMismatch struct {
+	TxHash    string
+	Table     string
+	Key       string
+	Predicted []Field
+	Actual    []Field
+}
+
+type PredictionStats struct {
+	Matched    uint64
+	Mismatched uint64
+}
+
+func (db *Database) SetMismatchHandler(handler func(mismatch PredictionMismatch)) {
+	db.mismatchHandler = &handler
+}
+
+func (db *Database) PredictionStats() PredictionStats {
+	db.txSentMutex.Lock()
+	defer db.txSentMutex.Unlock()
+	return db.predictionStats
+}
+
+// TakeUnconfirmedTransaction removes the optimistic overlay of the transaction so the confirmed state is used
+func (db *Database) TakeUnconfirmedTransaction(txHash string) (UnconfirmedTransaction, bool) {
+	db.txSentMutex.Lock()
+	defer db.txSentMutex.Unlock()
+	for k, v := range db.UnconfirmedTransactions {
+		if v.Txhash == txHash {
+			db.UnconfirmedTransactions = append(db.UnconfirmedTransactions[:k], db.UnconfirmedTransactions[k+1:]...)
+			return v, true
+		}
+	}
+	return UnconfirmedTransaction{}, false
+}
+
+func fieldsEqual(a []Field, b []Field) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if (a[i].Data == nil) != (b[i].Data == nil) {
+			return false
+		}
+		if a[i].Data != nil && a[i].Data.String() != b[i].Data.String() {
+			return false
+		}
+	}
+	return true
+}
+
+// ReconcilePrediction compares the predicted events with the last event of each row modified by the transaction logs.
+// The confirmed state is already applied, the mismatches are only reported.
+func (db *Database) ReconcilePrediction(tx UnconfirmedTransaction, actual []MudEvent) {
+	last := map[string]MudEvent{}
+	for _, v := range actual {
+		last[v.Table+"/"+v.Key] = v
+	}
+
+	for _, predicted := range tx.Events {
+		event, ok := last[predicted.Table+"/"+predicted.Key]
+		if ok && fieldsEqual(predicted.Fields, event.Fields) {
+			db.txSentMutex.Lock()
+			db.predictionStats.Matched++
+			db.txSentMutex.Unlock()
+			continue
+		}
+
+		mismatch := PredictionMismatch{TxHash: tx.Txhash, Table: predicted.Table, Key: predicted.Key, Predicted: predicted.Fields}
+		if ok {
+			mismatch.Actual = event.Fields
+		}
+		logger.LogError(fmt.Sprintf("[indexer] wrong prediction for tx %s, table %s, key %s: %v != %v", tx.Txhash, predicted.Table, predicted.Key, mismatch.Predicted, mismatch.Actual))
+
+		db.txSentMutex.Lock()
+		db.predictionStats.Mismatched++
+		db.txSentMutex.Unlock()
+
+		if db.mismatchHandler != nil {
+			(*db.mismatchHandler)(mismatch)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestReconcilePrediction(t *testing.T) {
+	db := NewDatabase()
+	mismatches := []PredictionMismatch{}
+	db.SetMismatchHandler(func(mismatch PredictionMismatch) {
+		mismatches = append(mismatches, mismatch)
+	})
+
+	tx := UnconfirmedTransaction{
+		Txhash: "0xaa",
+		Events: []MudEvent{
+			{Table: "Counter", Key: "0x01", Fields: *uintFields("f0", 2)},
+			{Table: "Counter", Key: "0x02", Fields: nil},
+		},
+	}
+	db.AddTxSent(tx)
+	taken, ok := db.TakeUnconfirmedTransaction("0xaa")
+	if !ok || len(db.PendingTransactions()) != 0 {
+		t.Fatal("the transaction was not taken")
+	}
+
+	// Only the last event of each row is compared, the deleted row matches its nil fields
+	db.ReconcilePrediction(taken, []MudEvent{
+		{Table: "Counter", Key: "0x01", Fields: *uintFields("f0", 1)},
+		{Table: "Counter", Key: "0x01", Fields: *uintFields("f0", 2)},
+		{Table: "Counter", Key: "0x02", Fields: nil},
+	})
+	if stats := db.PredictionStats(); stats != (PredictionStats{Matched: 2, Mismatched: 0}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if len(mismatches) != 0 {
+		t.Fatalf("unexpected mismatches %+v", mismatches)
+	}
+
+	// A different value and a row that was not modified
+	db.ReconcilePrediction(taken, []MudEvent{
+		{Table: "Counter", Key: "0x01", Fields: *uintFields("f0", 3)},
+		{Table: "Other", Key: "0x02", Fields: nil},
+	})
+	if stats := db.PredictionStats(); stats != (PredictionStats{Matched: 2, Mismatched: 2}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if len(mismatches) != 2 {
+		t.Fatalf("unexpected mismatches %+v", mismatches)
+	}
+	if mismatch := mismatches[0]; mismatch.TxHash != "0xaa" || mismatch.Key != "0x01" || mismatch.Predicted[0].String() != `"f0":2` || mismatch.Actual[0].String() != `"f0":3` {
+		t.Errorf("unexpected value mismatch %+v", mismatch)
+	}
+	if mismatch := mismatches[1]; mismatch.Table != "Counter" || mismatch.Key != "0x02" || mismatch.Actual != nil {
+		t.Errorf("unexpected missing row mismatch %+v", mismatch)
+	}
+}
+
+func TestFieldsEqual(t *testing.T) {
+	tests := []struct {
+		a        []Field
+		b        []Field
+		expected bool
+	}{
+		{*uintFields("f0", 1), *uintFields("f0", 1), true},
+		{*uintFields("f0", 1), *uintFields("f0", 2), false},
+		{*uintFields("f0", 1), nil, false},
+		{[]Field{{Key: "f0", Data: nil}}, *uintFields("f0", 1), false},
+		{[]Field{{Key: "f0", Data: nil}}, []Field{{Key: "f0", Data: nil}}, true},
+		{nil, []Field{}, true},
+	}
+	for i, test := range tests {
+		if equal := fieldsEqual(test.a, test.b); equal != test.expected {
+			t.Errorf("the fields %d are equal: %t", i, equal)
+		}
+	}
+}
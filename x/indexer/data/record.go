@@ -0,0 +1,172 @@
+package data
+
+import (
+	"math/big"
+
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MUD v2 records are stored as static data, encoded lengths and dynamic data.
+// The rows are kept decoded, so splices encode the current row, modify the bytes and decode the affected fields again.
+
+func staticDataLength(schemaTypePair mudhelpers.SchemaTypePair) uint64 {
+	length := uint64(0)
+	for _, fieldType := range schemaTypePair.Static {
+		length += mudhelpers.GetStaticByteLength(fieldType)
+	}
+	return length
+}
+
+// padRight avoids out of range panics when the data is shorter than the schema
+func padRight(data []byte, length uint64) []byte {
+	if uint64(len(data)) >= length {
+		return data
+	}
+	return common.RightPadBytes(data, int(length))
+}
+
+func numberToBytes(value *big.Int, length uint64) []byte {
+	if value.Sign() < 0 {
+		// Two's complement
+		value = new(big.Int).Add(value, new(big.Int).Lsh(big.NewInt(1), uint(length*8)))
+	}
+	encoded := common.LeftPadBytes(value.Bytes(), int(length))
+	return encoded[uint64(len(encoded))-length:]
+}
+
+func StaticFieldToBytes(schemaType mudhelpers.SchemaType, field FieldData) []byte {
+	length := mudhelpers.GetStaticByteLength(schemaType)
+	switch v := field.(type) {
+	case UintField:
+		return numberToBytes(&v.Data, length)
+	case IntField:
+		return numberToBytes(&v.Data, length)
+	case BytesField:
+		return common.RightPadBytes(v.Data, int(length))[:length]
+	case BoolField:
+		if v.Data {
+			return []byte{1}
+		}
+		return []byte{0}
+	case AddressField:
+		return v.Data.Bytes()
+	}
+	return make([]byte, length)
+}
+
+func DynamicFieldToBytes(schemaType mudhelpers.SchemaType, field FieldData) []byte {
+	switch v := field.(type) {
+	case BytesField:
+		return v.Data
+	case StringField:
+		return []byte(v.Data)
+	case ArrayField:
+		ret := []byte{}
+		for _, element := range v.Data {
+			ret = append(ret, StaticFieldToBytes(schemaType-98, element)...)
+		}
+		return ret
+	}
+	return []byte{}
+}
+
+// RecordToFields decodes a MUD v2 record, the dynamic data uses the lengths packed in encodedLengths
+func RecordToFields(staticData []byte, encodedLengths [32]byte, dynamicData []byte, schemaTypePair mudhelpers.SchemaTypePair, fieldnames *[]string) *[]Field {
+	ret := []Field{}
+
+	staticData = padRight(staticData, staticDataLength(schemaTypePair))
+	var bytesOffset uint64
+	for _, fieldType := range schemaTypePair.Static {
+		ret = append(ret, Field{Key: "", Data: BytesToStaticField(fieldType, staticData, bytesOffset)})
+		bytesOffset += mudhelpers.GetStaticByteLength(fieldType)
+	}
+
+	_, lengths := mudhelpers.DecodeEncodedLengths(encodedLengths, len(schemaTypePair.Dynamic))
+	bytesOffset = 0
+	for i, fieldType := range schemaTypePair.Dynamic {
+		end := bytesOffset + lengths[i]
+		if end > uint64(len(dynamicData)) {
+			end = uint64(len(dynamicData))
+		}
+		if bytesOffset > end {
+			bytesOffset = end
+		}
+		ret = append(ret, Field{Key: "", Data: BytesToDynamicField(fieldType, dynamicData[bytesOffset:end])})
+		bytesOffset = end
+	}
+
+	for idx, fieldName := range *fieldnames {
+		if idx < len(ret) {
+			ret[idx].Key = fieldName
+		}
+	}
+
+	return &ret
+}
+
+// rowWithDefaults returns a copy of the row, or an empty row if it does not exist (nil) or does not match the schema
+func rowWithDefaults(table *Table, row []Field) []Field {
+	schemaTypePair := table.Schema.Schema.Value
+	types := schemaTypePair.Flatten()
+
+	if row != nil && len(row) == len(types) {
+		ret := make([]Field, len(row))
+		copy(ret, row)
+		return ret
+	}
+
+	ret := make([]Field, 0, len(types))
+	for _, fieldType := range types {
+		ret = append(ret, Field{Key: "", Data: FieldWithDefautValue(fieldType)})
+	}
+	for idx, fieldName := range *table.Schema.FieldNames {
+		if idx < len(ret) {
+			ret[idx].Key = fieldName
+		}
+	}
+	return ret
+}
+
+// SpliceStaticFields replaces the static data bytes starting at `start` and decodes the modified fields
+func SpliceStaticFields(row []Field, schemaTypePair mudhelpers.SchemaTypePair, start uint64, data []byte) {
+	staticData := []byte{}
+	for i, fieldType := range schemaTypePair.Static {
+		staticData = append(staticData, StaticFieldToBytes(fieldType, row[i].Data)...)
+	}
+	staticData = padRight(staticData, start+uint64(len(data)))
+	copy(staticData[start:], data)
+
+	end := start + uint64(len(data))
+	var bytesOffset uint64
+	for i, fieldType := range schemaTypePair.Static {
+		length := mudhelpers.GetStaticByteLength(fieldType)
+		if bytesOffset < end && bytesOffset+length > start {
+			row[i].Data = BytesToStaticField(fieldType, staticData, bytesOffset)
+		}
+		bytesOffset += length
+	}
+}
+
+// SpliceDynamicField replaces `deleteCount` bytes of the dynamic field starting at `start` and decodes it, it returns the new field length
+func SpliceDynamicField(row []Field, schemaTypePair mudhelpers.SchemaTypePair, index uint8, start uint64, deleteCount uint64, data []byte) uint64 {
+	fieldType := schemaTypePair.Dynamic[index]
+	position := len(schemaTypePair.Static) + int(index)
+	current := DynamicFieldToBytes(fieldType, row[position].Data)
+
+	if start > uint64(len(current)) {
+		start = uint64(len(current))
+	}
+	deleteEnd := start + deleteCount
+	if deleteEnd > uint64(len(current)) {
+		deleteEnd = uint64(len(current))
+	}
+
+	updated := make([]byte, 0, uint64(len(current))-(deleteEnd-start)+uint64(len(data)))
+	updated = append(updated, current[:start]...)
+	updated = append(updated, data...)
+	updated = append(updated, current[deleteEnd:]...)
+
+	row[position].Data = BytesToDynamicField(fieldType, updated)
+	return uint64(len(updated))
+}
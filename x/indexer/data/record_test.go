@@ -0,0 +1,232 @@
+package data
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// spliceSchema has the static fields uint8, uint32, address and bool (26 bytes) and the dynamic fields string and uint32[]
+var spliceSchema = mudhelpers.SchemaTypePair{
+	Static:           []mudhelpers.SchemaType{mudhelpers.UINT8, mudhelpers.UINT32, mudhelpers.ADDRESS, mudhelpers.BOOL},
+	Dynamic:          []mudhelpers.SchemaType{mudhelpers.STRING, mudhelpers.UINT32 + 98},
+	StaticDataLength: 26,
+}
+
+func spliceRow() []Field {
+	return []Field{
+		{Key: "small", Data: NewUintFieldFromNumber(1)},
+		{Key: "amount", Data: NewUintFieldFromNumber(0x01020304)},
+		{Key: "owner", Data: NewAddressField(common.HexToAddress("0x1111111111111111111111111111111111111111").Bytes())},
+		{Key: "active", Data: NewBoolFromValue(false)},
+		{Key: "name", Data: NewStringFieldFromValue("hello")},
+		{Key: "values", Data: ArrayField{Data: []FieldData{NewUintFieldFromNumber(1), NewUintFieldFromNumber(2)}}},
+	}
+}
+
+func rowStrings(row []Field) []string {
+	values := make([]string, len(row))
+	for i, field := range row {
+		values[i] = field.Data.String()
+	}
+	return values
+}
+
+func TestSpliceStaticFields(t *testing.T) {
+	owner := `"0x1111111111111111111111111111111111111111"`
+	cases := []struct {
+		name     string
+		start    uint64
+		data     []byte
+		expected []string
+	}{
+		{
+			name:     "first field",
+			start:    0,
+			data:     []byte{0x05},
+			expected: []string{"5", "16909060", owner, "false"},
+		},
+		{
+			name:     "whole field at a boundary",
+			start:    1,
+			data:     []byte{0, 0, 0, 0x2a},
+			expected: []string{"1", "42", owner, "false"},
+		},
+		{
+			name:     "inside a field",
+			start:    3,
+			data:     []byte{0xff},
+			expected: []string{"1", "16973572", owner, "false"},
+		},
+		{
+			name:     "spanning fields",
+			start:    4,
+			data:     []byte{0x00, 0x22, 0x22},
+			expected: []string{"1", "16909056", `"0x2222111111111111111111111111111111111111"`, "false"},
+		},
+		{
+			name:     "every field",
+			start:    0,
+			data:     append(append([]byte{7, 0, 0, 0, 8}, common.HexToAddress("0x3333333333333333333333333333333333333333").Bytes()...), 1),
+			expected: []string{"7", "8", `"0x3333333333333333333333333333333333333333"`, "true"},
+		},
+		{
+			name:     "last field",
+			start:    25,
+			data:     []byte{1},
+			expected: []string{"1", "16909060", owner, "true"},
+		},
+		{
+			name:     "past the static data",
+			start:    25,
+			data:     []byte{1, 9, 9},
+			expected: []string{"1", "16909060", owner, "true"},
+		},
+		{
+			name:     "empty data",
+			start:    2,
+			data:     []byte{},
+			expected: []string{"1", "16909060", owner, "false"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			row := spliceRow()
+			SpliceStaticFields(row, spliceSchema, c.start, c.data)
+			values := rowStrings(row)[:len(spliceSchema.Static)]
+			if strings.Join(values, " ") != strings.Join(c.expected, " ") {
+				t.Fatalf("expected %v, got %v", c.expected, values)
+			}
+			if dynamic := rowStrings(row)[len(spliceSchema.Static):]; dynamic[0] != `"hello"` || dynamic[1] != "[1,2]" {
+				t.Fatalf("the dynamic fields were modified: %v", dynamic)
+			}
+		})
+	}
+}
+
+func TestSpliceDynamicField(t *testing.T) {
+	cases := []struct {
+		name           string
+		index          uint8
+		start          uint64
+		deleteCount    uint64
+		data           []byte
+		expected       string
+		expectedLength uint64
+	}{
+		{
+			name:           "replace",
+			index:          0,
+			start:          0,
+			deleteCount:    5,
+			data:           []byte("howdy"),
+			expected:       `"howdy"`,
+			expectedLength: 5,
+		},
+		{
+			name:           "append grows the field",
+			index:          0,
+			start:          5,
+			deleteCount:    0,
+			data:           []byte(" world"),
+			expected:       `"hello world"`,
+			expectedLength: 11,
+		},
+		{
+			name:           "insert in the middle",
+			index:          0,
+			start:          2,
+			deleteCount:    0,
+			data:           []byte("--"),
+			expected:       `"he--llo"`,
+			expectedLength: 7,
+		},
+		{
+			name:           "delete shrinks the field",
+			index:          0,
+			start:          1,
+			deleteCount:    3,
+			data:           []byte{},
+			expected:       `"ho"`,
+			expectedLength: 2,
+		},
+		{
+			name:           "replace with shorter data",
+			index:          0,
+			start:          0,
+			deleteCount:    5,
+			data:           []byte("hi"),
+			expected:       `"hi"`,
+			expectedLength: 2,
+		},
+		{
+			name:           "delete past the end is clamped",
+			index:          0,
+			start:          3,
+			deleteCount:    10,
+			data:           []byte{},
+			expected:       `"hel"`,
+			expectedLength: 3,
+		},
+		{
+			name:           "start past the end appends",
+			index:          0,
+			start:          20,
+			deleteCount:    1,
+			data:           []byte("!"),
+			expected:       `"hello!"`,
+			expectedLength: 6,
+		},
+		{
+			name:           "push to an array",
+			index:          1,
+			start:          8,
+			deleteCount:    0,
+			data:           []byte{0, 0, 0, 3},
+			expected:       "[1,2,3]",
+			expectedLength: 12,
+		},
+		{
+			name:           "pop from an array",
+			index:          1,
+			start:          4,
+			deleteCount:    4,
+			data:           []byte{},
+			expected:       "[1]",
+			expectedLength: 4,
+		},
+		{
+			name:           "update an array element",
+			index:          1,
+			start:          0,
+			deleteCount:    4,
+			data:           []byte{0, 0, 0, 9},
+			expected:       "[9,2]",
+			expectedLength: 8,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			row := spliceRow()
+			length := SpliceDynamicField(row, spliceSchema, c.index, c.start, c.deleteCount, c.data)
+			if length != c.expectedLength {
+				t.Fatalf("expected the length %d, got %d", c.expectedLength, length)
+			}
+			position := len(spliceSchema.Static) + int(c.index)
+			if value := row[position].Data.String(); value != c.expected {
+				t.Fatalf("expected %s, got %s", c.expected, value)
+			}
+			// The other fields are not modified
+			expected := rowStrings(spliceRow())
+			for i, value := range rowStrings(row) {
+				if i != position && value != expected[i] {
+					t.Fatalf("the field %d was modified: %s", i, value)
+				}
+			}
+		})
+	}
+}
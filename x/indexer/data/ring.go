@@ -0,0 +1,63 @@
+package data
+
+// ringBuffer keeps the last values, the oldest one is replaced once it is full. The users keep their own lock.
+type ringBuffer[T any] struct {
+	values   []T
+	start    int
+	capacity int
+}
+
+func newRingBuffer[T any](capacity int) *ringBuffer[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ringBuffer[T]{
+		values:   make([]T, 0, capacity),
+		start:    0,
+		capacity: capacity,
+	}
+}
+
+func (r *ringBuffer[T]) push(value T) {
+	if len(r.values) < r.capacity {
+		r.values = append(r.values, value)
+		return
+	}
+	r.values[r.start] = value
+	r.start = (r.start + 1) % r.capacity
+}
+
+// list returns the values from the oldest to the newest one
+func (r *ringBuffer[T]) list() []T {
+	ret := make([]T, 0, len(r.values))
+	for i := 0; i < len(r.values); i++ {
+		ret = append(ret, r.values[(r.start+i)%len(r.values)])
+	}
+	return ret
+}
+
+// setCapacity keeps the newest values that fit in the new capacity
+func (r *ringBuffer[T]) setCapacity(capacity int) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	values := r.list()
+	if len(values) > capacity {
+		values = values[len(values)-capacity:]
+	}
+	r.values = make([]T, 0, capacity)
+	r.values = append(r.values, values...)
+	r.start = 0
+	r.capacity = capacity
+}
+
+func (r *ringBuffer[T]) clear() {
+	r.values = make([]T, 0, r.capacity)
+	r.start = 0
+}
+
+func (r *ringBuffer[T]) copy() *ringBuffer[T] {
+	ret := newRingBuffer[T](r.capacity)
+	ret.values = append(ret.values, r.list()...)
+	return ret
+}
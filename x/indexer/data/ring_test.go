@@ -0,0 +1,40 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	ring := newRingBuffer[int](3)
+	for i := 1; i <= 5; i++ {
+		ring.push(i)
+	}
+	if values := ring.list(); !reflect.DeepEqual(values, []int{3, 4, 5}) {
+		t.Fatalf("unexpected values %v", values)
+	}
+
+	copied := ring.copy()
+	ring.push(6)
+	if values := copied.list(); !reflect.DeepEqual(values, []int{3, 4, 5}) {
+		t.Fatalf("the copy shares the values: %v", values)
+	}
+
+	// The newest values are kept
+	ring.setCapacity(2)
+	if values := ring.list(); !reflect.DeepEqual(values, []int{5, 6}) {
+		t.Fatalf("unexpected values after shrinking %v", values)
+	}
+	ring.setCapacity(4)
+	ring.push(7)
+	ring.push(8)
+	ring.push(9)
+	if values := ring.list(); !reflect.DeepEqual(values, []int{6, 7, 8, 9}) {
+		t.Fatalf("unexpected values after growing %v", values)
+	}
+
+	ring.clear()
+	if values := ring.list(); len(values) != 0 {
+		t.Fatalf("the values were not removed: %v", values)
+	}
+}
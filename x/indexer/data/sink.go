@@ -0,0 +1,287 @@
+package data
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bocha-io/logger"
+)
+
+// SinkTable is a table that the sink must rewrite, it is sent the first time the table is used and when its schema or names change
+type SinkTable struct {
+	// Copy of the table at the end of the block
+	Table *Table
+	// Every row of the table, by key
+	Rows map[string][]Field
+}
+
+// SinkChange is a row modification, the fields are nil if the row was deleted
+type SinkChange struct {
+	// Copy of the table at the end of the block
+	Table  *Table
+	Key    string
+	Fields []Field
+}
+
+// SinkBatch has the changes committed together by a block (or an Update), they are sent in order
+type SinkBatch struct {
+	ChainID string
+	// Set when the tables were removed (Reset, ImportState or a new sink), the mirrored tables must be dropped first
+	Reset bool
+	// The changes of these tables are not included, their rows already have them
+	Tables  []SinkTable
+	Changes []SinkChange
+}
+
+// Sink mirrors the committed rows in another database
+type Sink interface {
+	// Apply is called by the sink worker in the order of the batches, if it fails the tables of the batch are rewritten after a backoff
+	Apply(batch SinkBatch) error
+}
+
+const (
+	sinkMinBackoff = 500 * time.Millisecond
+	sinkMaxBackoff = time.Minute
+)
+
+// sinkJob is a queued batch with the tables that it modifies, they are rewritten if it fails
+type sinkJob struct {
+	batch  SinkBatch
+	tables map[*Table]bool
+}
+
+// sinkWorker applies the batches outside of the write lock, a slow or failing sink does not block the writer
+type sinkWorker struct {
+	db   *Database
+	sink Sink
+
+	mutex *sync.Mutex
+	cond  *sync.Cond
+	queue []sinkJob
+	busy  bool
+	// Error of the last applied batch, cleared by the next successful one
+	err error
+	// Tables to be rewritten by the next batch because a batch failed
+	failed      map[*Table]bool
+	failedReset bool
+	stopped     bool
+	stop        chan struct{}
+}
+
+func newSinkWorker(db *Database, sink Sink) *sinkWorker {
+	mutex := &sync.Mutex{}
+	w := &sinkWorker{
+		db:     db,
+		sink:   sink,
+		mutex:  mutex,
+		cond:   sync.NewCond(mutex),
+		queue:  []sinkJob{},
+		failed: map[*Table]bool{},
+		stop:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *sinkWorker) enqueue(job sinkJob) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.queue = append(w.queue, job)
+	w.cond.Broadcast()
+}
+
+// takeFailed returns the tables that must be rewritten, must be called with the write lock of the database
+func (w *sinkWorker) takeFailed() (map[*Table]bool, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	failed, reset := w.failed, w.failedReset
+	w.failed = map[*Table]bool{}
+	w.failedReset = false
+	return failed, reset
+}
+
+func (w *sinkWorker) close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.stop)
+		w.cond.Broadcast()
+	}
+}
+
+// wait blocks until the queue is empty
+func (w *sinkWorker) wait() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for (len(w.queue) > 0 || w.busy) && !w.stopped {
+		w.cond.Wait()
+	}
+	return w.err
+}
+
+func (w *sinkWorker) run() {
+	backoff := sinkMinBackoff
+	for {
+		w.mutex.Lock()
+		for len(w.queue) == 0 && !w.stopped {
+			w.cond.Wait()
+		}
+		if w.stopped {
+			w.mutex.Unlock()
+			return
+		}
+		job := w.queue[0]
+		w.busy = true
+		w.mutex.Unlock()
+
+		err := w.sink.Apply(job.batch)
+
+		w.mutex.Lock()
+		w.queue = w.queue[1:]
+		w.busy = false
+		w.err = err
+		if err == nil {
+			backoff = sinkMinBackoff
+			w.cond.Broadcast()
+			w.mutex.Unlock()
+			continue
+		}
+		// The queued batches depend on the failed one, their tables are rewritten instead
+		for _, queued := range append([]sinkJob{job}, w.queue...) {
+			for table := range queued.tables {
+				w.failed[table] = true
+			}
+			w.failedReset = w.failedReset || queued.batch.Reset
+		}
+		w.queue = []sinkJob{}
+		w.cond.Broadcast()
+		w.mutex.Unlock()
+
+		logger.LogError(fmt.Sprintf("[indexer] error writing the changes to the sink, the modified tables will be rewritten in %s: %s", backoff, err))
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > sinkMaxBackoff {
+			backoff = sinkMaxBackoff
+		}
+		// An empty update sends the rewrite even if no block is applied
+		w.db.Update(func() {})
+	}
+}
+
+// SetSink sends every table to the sink and then the changes of each block, nil removes it
+func (db *Database) SetSink(sink Sink) {
+	db.lock()
+	defer db.unlock()
+	if db.sinkWorker != nil {
+		db.sinkWorker.close()
+		db.sinkWorker = nil
+	}
+	db.sink = sink
+	if sink != nil {
+		db.sinkWorker = newSinkWorker(db, sink)
+	}
+	db.sinkReset = true
+	db.sinkChanges = []sinkChange{}
+	db.sinkTables = map[*Table]bool{}
+	db.sinkSent = map[*Table]bool{}
+}
+
+// WaitSink blocks until the sink applied every committed batch, it returns the error of the last batch if the sink is failing
+func (db *Database) WaitSink() error {
+	db.mutex.RLock()
+	worker := db.sinkWorker
+	db.mutex.RUnlock()
+	if worker == nil {
+		return nil
+	}
+	return worker.wait()
+}
+
+type sinkChange struct {
+	table  *Table
+	key    string
+	fields []Field
+}
+
+// sinkReady is false until the table schema is registered
+func sinkReady(table *Table) bool {
+	return table.Schema.Schema != nil && table.Schema.Schema.Key != nil && table.Schema.Schema.Value != nil
+}
+
+// sinkRow keeps the row modification until the end of the block, nil fields delete it
+func (db *Database) sinkRow(table *Table, key string, fields []Field) {
+	if db.sink == nil {
+		return
+	}
+	db.sinkChanges = append(db.sinkChanges, sinkChange{table: table, key: key, fields: fields})
+}
+
+// sinkSchema marks the table to be rewritten, its schema or names may have changed
+func (db *Database) sinkSchema(table *Table) {
+	if db.sink == nil {
+		return
+	}
+	db.sinkTables[table] = true
+}
+
+// sinkBatch must be called with the write lock, after the storage transaction is committed
+func (db *Database) sinkBatch() (sinkJob, bool) {
+	failed, failedReset := db.sinkWorker.takeFailed()
+	if failedReset {
+		db.sinkReset = true
+	}
+
+	batch := SinkBatch{ChainID: db.ChainID, Reset: db.sinkReset, Tables: []SinkTable{}, Changes: []SinkChange{}}
+	rewrite := db.sinkTables
+	if db.sinkReset {
+		db.sinkSent = map[*Table]bool{}
+		for _, world := range db.worldList() {
+			for _, table := range world.tableList() {
+				rewrite[table] = true
+			}
+		}
+	} else {
+		// Only the tables modified by the failed batches, the other mirrored tables are up to date
+		for table := range failed {
+			rewrite[table] = true
+		}
+	}
+	for _, change := range db.sinkChanges {
+		if !db.sinkSent[change.table] {
+			rewrite[change.table] = true
+		}
+	}
+
+	tables := map[*Table]bool{}
+	copies := map[*Table]*Table{}
+	for table := range rewrite {
+		if !sinkReady(table) {
+			continue
+		}
+		copies[table] = copyTable(table)
+		batch.Tables = append(batch.Tables, SinkTable{Table: copies[table], Rows: db.copyRows(table)})
+		db.sinkSent[table] = true
+		tables[table] = true
+	}
+	for _, change := range db.sinkChanges {
+		if rewrite[change.table] || !sinkReady(change.table) {
+			continue
+		}
+		if _, ok := copies[change.table]; !ok {
+			copies[change.table] = copyTable(change.table)
+		}
+		batch.Changes = append(batch.Changes, SinkChange{Table: copies[change.table], Key: change.key, Fields: change.fields})
+		tables[change.table] = true
+	}
+
+	db.sinkReset = false
+	db.sinkChanges = []sinkChange{}
+	db.sinkTables = map[*Table]bool{}
+	return sinkJob{batch: batch, tables: tables}, batch.Reset || len(batch.Tables) > 0 || len(batch.Changes) > 0
+}
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+)
+
+// testSink records the applied batches, the batches are rejected while fail is set
+type testSink struct {
+	mutex   *sync.Mutex
+	fail    bool
+	batches []SinkBatch
+}
+
+func (s *testSink) Apply(batch SinkBatch) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.fail {
+		return errors.New("the sink is down")
+	}
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *testSink) setFail(fail bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fail = fail
+	s.batches = []SinkBatch{}
+}
+
+func (s *testSink) applied() []SinkBatch {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]SinkBatch{}, s.batches...)
+}
+
+func TestSinkRewritesTheFailedTables(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	other := db.GetTable("0x01", "0x7462000000000000000000000000000000000000000000000000000000000002")
+	other.Schema = &TableSchema{FieldNames: &[]string{"f0"}, KeyNames: &[]string{"k0"}, Schema: table.Schema.Schema, NamedFields: &map[string]mudhelpers.SchemaType{}}
+
+	sink := &testSink{mutex: &sync.Mutex{}}
+	db.SetSink(sink)
+	defer db.SetSink(nil)
+	applyBlock(db, table, 1, map[byte]int64{1: 1})
+	applyBlock(db, other, 2, map[byte]int64{1: 1})
+	if err := db.WaitSink(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.setFail(true)
+	applyBlock(db, table, 3, map[byte]int64{1: 2})
+	if err := db.WaitSink(); err == nil {
+		t.Fatal("the sink error was not returned")
+	}
+	sink.setFail(false)
+
+	// The worker sends the rewrite after the backoff without waiting for a new block
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.applied()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the failed batch was not retried")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := db.WaitSink(); err != nil {
+		t.Fatal(err)
+	}
+
+	batches := sink.applied()
+	if len(batches) != 1 || batches[0].Reset || len(batches[0].Tables) != 1 || len(batches[0].Changes) != 0 {
+		t.Fatalf("unexpected retry batches %+v", batches)
+	}
+	rewritten := batches[0].Tables[0]
+	if rewritten.Table.Metadata.TableID != table.Metadata.TableID {
+		t.Fatalf("the table that did not fail was rewritten: %s", rewritten.Table.Metadata.TableID)
+	}
+	if fields := rewritten.Rows["0x01"]; len(fields) != 1 || fields[0].String() != `"f0":2` {
+		t.Fatalf("unexpected rewritten rows %v", rewritten.Rows)
+	}
+}
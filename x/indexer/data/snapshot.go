@@ -0,0 +1,457 @@
+package data
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SnapshotVersion must be increased every time that the snapshot file format changes
+const SnapshotVersion = 1
+
+// SnapshotExtension is used by the snapshot files written by the Snapshotter
+const SnapshotExtension = ".snapshot"
+
+// SnapshotHeader is the first line of a snapshot file, it is followed by the gzipped StoredState
+type SnapshotHeader struct {
+	Version      int         `json:"version"`
+	StateVersion int         `json:"state_version"`
+	ChainID      string      `json:"chain_id"`
+	Height       uint64      `json:"height"`
+	Hash         common.Hash `json:"hash"`
+	CreatedAt    time.Time   `json:"created_at"`
+	Worlds       int         `json:"worlds"`
+	Tables       int         `json:"tables"`
+	Rows         int         `json:"rows"`
+	// Hex encoded sha256 of the encoded state, before it is compressed
+	Checksum string `json:"checksum"`
+}
+
+// jsonStream writes a json document piece by piece, the first error stops the writes
+type jsonStream struct {
+	w   io.Writer
+	err error
+}
+
+func (s *jsonStream) raw(text string) {
+	if s.err == nil {
+		_, s.err = io.WriteString(s.w, text)
+	}
+}
+
+func (s *jsonStream) value(v interface{}) {
+	if s.err != nil {
+		return
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		s.err = err
+		return
+	}
+	_, s.err = s.w.Write(encoded)
+}
+
+// field writes `,"name":value`, or without the comma for the first field of an object
+func (s *jsonStream) field(first bool, name string, v interface{}) {
+	if !first {
+		s.raw(",")
+	}
+	s.value(name)
+	s.raw(":")
+	s.value(v)
+}
+
+// snapshotTable is a table to be streamed, the rows are read from the storage snapshot
+type snapshotTable struct {
+	stored StoredTable
+	id     string
+}
+
+type snapshotWorld struct {
+	address string
+	tables  []snapshotTable
+}
+
+// streamTable writes a StoredTable with the rows of the storage, the rows are never kept in memory
+func streamTable(s *jsonStream, storage StorageReader, table snapshotTable) int {
+	stored := table.stored
+	s.field(true, "metadata", stored.Metadata)
+	s.field(false, "field_names", stored.FieldNames)
+	s.field(false, "key_names", stored.KeyNames)
+	s.field(false, "schema", stored.Schema)
+	s.field(false, "named_fields", stored.NamedFields)
+
+	rows := 0
+	s.raw(`,"rows":{`)
+	err := storage.IterateRows(table.id, func(key string, row Row) bool {
+		fields, err := encodeFields(row.Fields)
+		if err != nil {
+			s.err = fmt.Errorf("error encoding the row %s of table %s: %w", key, stored.Metadata.TableName, err)
+			return false
+		}
+		s.field(rows == 0, key, fields)
+		rows++
+		return s.err == nil
+	})
+	s.raw(`},"provenance":{`)
+	first := true
+	if err == nil {
+		err = storage.IterateRows(table.id, func(key string, row Row) bool {
+			if row.Provenance != nil {
+				s.field(first, key, row.Provenance)
+				first = false
+			}
+			return s.err == nil
+		})
+	}
+	s.raw("}")
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return rows
+}
+
+// streamState writes the StoredState of the database and fills the header counts.
+// The worlds, the tracked blocks and the dead letters are copied with the read lock, the rows are streamed from a storage snapshot.
+func (db *Database) streamState(w io.Writer, header *SnapshotHeader) error {
+	db.mutex.RLock()
+	state, err := db.exportState(false)
+	var blocks []StoredBlock
+	if err == nil {
+		blocks, err = db.exportBlocks()
+	}
+	var storage Storage
+	if err == nil {
+		storage, err = db.storage.Snapshot()
+	}
+	worlds := []snapshotWorld{}
+	for _, world := range db.worldList() {
+		copied := snapshotWorld{address: world.Address, tables: []snapshotTable{}}
+		for _, table := range world.tableList() {
+			copied.tables = append(copied.tables, snapshotTable{stored: encodeSchema(table), id: storageID(table)})
+		}
+		worlds = append(worlds, copied)
+	}
+	db.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	header.StateVersion = state.Version
+	header.ChainID = state.ChainID
+	header.Height = state.Height
+	header.Hash = state.Hash
+	header.Worlds = len(worlds)
+
+	s := &jsonStream{w: w}
+	s.raw("{")
+	s.field(true, "version", state.Version)
+	s.field(false, "chain_id", state.ChainID)
+	s.field(false, "height", state.Height)
+	s.field(false, "hash", state.Hash)
+	s.field(false, "confirmed_height", state.ConfirmedHeight)
+	s.raw(`,"worlds":[`)
+	for i, world := range worlds {
+		if i > 0 {
+			s.raw(",")
+		}
+		s.raw("{")
+		s.field(true, "address", world.address)
+		s.raw(`,"tables":[`)
+		for j, table := range world.tables {
+			if j > 0 {
+				s.raw(",")
+			}
+			s.raw("{")
+			header.Rows += streamTable(s, storage, table)
+			s.raw("}")
+			header.Tables++
+		}
+		s.raw("]}")
+	}
+	s.raw("]")
+	s.field(false, "blocks", blocks)
+	s.field(false, "dead_letters", state.DeadLetters)
+	s.raw("}")
+	return s.err
+}
+
+// WriteSnapshot saves the whole database to the file, the rows of a block are never written partially.
+// The state is compressed to a temporary file while its checksum is computed, then it is copied after the header.
+func WriteSnapshot(db *Database, path string) (*SnapshotHeader, error) {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.state")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		temp.Close()
+		os.Remove(temp.Name())
+	}()
+
+	header := &SnapshotHeader{Version: SnapshotVersion, CreatedAt: time.Now().UTC()}
+	checksum := sha256.New()
+	compressed := gzip.NewWriter(temp)
+	buffered := bufio.NewWriter(io.MultiWriter(checksum, compressed))
+	if err := db.streamState(buffered, header); err != nil {
+		return nil, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, err
+	}
+	if err := compressed.Close(); err != nil {
+		return nil, err
+	}
+	header.Checksum = hex.EncodeToString(checksum.Sum(nil))
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		if _, err := w.Write(append(encodedHeader, '\n')); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, temp)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.LogInfo(fmt.Sprintf("[indexer] snapshot %s saved at block %d (%s)", path, header.Height, header.Hash.Hex()))
+	return header, nil
+}
+
+// openSnapshot reads the header and returns the reader of the compressed state, the file must be closed
+func openSnapshot(path string) (*os.File, *SnapshotHeader, *bufio.Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, fmt.Errorf("error reading the snapshot header of %s: %w", path, err)
+	}
+	header := &SnapshotHeader{}
+	if err := json.Unmarshal(line, header); err != nil {
+		file.Close()
+		return nil, nil, nil, fmt.Errorf("error decoding the snapshot header of %s: %w", path, err)
+	}
+	if header.Version != SnapshotVersion {
+		file.Close()
+		return nil, nil, nil, fmt.Errorf("unsupported snapshot version %d, expected %d", header.Version, SnapshotVersion)
+	}
+	return file, header, reader, nil
+}
+
+// readSnapshotState decompresses the state and verifies its checksum, the state is decoded while it is read if it is not nil
+func readSnapshotState(path string, header *SnapshotHeader, reader io.Reader, state *StoredState) error {
+	decompressed, err := gzip.NewReader(reader)
+	if err != nil {
+		return fmt.Errorf("error reading the snapshot %s: %w", path, err)
+	}
+	checksum := sha256.New()
+	encoded := io.TeeReader(decompressed, checksum)
+	if state != nil {
+		if err := json.NewDecoder(encoded).Decode(state); err != nil {
+			return fmt.Errorf("error decoding the snapshot %s: %w", path, err)
+		}
+	}
+	// The rest of the state is hashed too, the decoder may not read it
+	if _, err := io.Copy(io.Discard, encoded); err != nil {
+		return fmt.Errorf("error reading the snapshot %s: %w", path, err)
+	}
+	if hex.EncodeToString(checksum.Sum(nil)) != header.Checksum {
+		return fmt.Errorf("the checksum of the snapshot %s does not match, the file is corrupted", path)
+	}
+	return nil
+}
+
+// ReadSnapshotHeader returns the header without reading the state
+func ReadSnapshotHeader(path string) (*SnapshotHeader, error) {
+	file, header, _, err := openSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return header, nil
+}
+
+// VerifySnapshot reads the header and checks the state checksum without decoding it
+func VerifySnapshot(path string) (*SnapshotHeader, error) {
+	file, header, reader, err := openSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := readSnapshotState(path, header, reader, nil); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// ReadSnapshot returns the verified state of the snapshot
+func ReadSnapshot(path string) (*SnapshotHeader, *StoredState, error) {
+	file, header, reader, err := openSnapshot(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	state := &StoredState{}
+	if err := readSnapshotState(path, header, reader, state); err != nil {
+		return nil, nil, err
+	}
+	return header, state, nil
+}
+
+// LoadSnapshot replaces the database content with the snapshot, the snapshot block is tracked as the last processed one
+func LoadSnapshot(db *Database, path string) (*SnapshotHeader, error) {
+	header, state, err := ReadSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.ImportState(state); err != nil {
+		return nil, err
+	}
+	logger.LogInfo(fmt.Sprintf("[indexer] snapshot %s loaded at block %d (%s)", path, header.Height, header.Hash.Hex()))
+	return header, nil
+}
+
+// Snapshotter writes a snapshot every `Every` blocks to the directory, only the last `Keep` files are kept
+type Snapshotter struct {
+	Dir   string
+	Every uint64
+	// Zero keeps every snapshot
+	Keep int
+	// Save can be called by the indexer and by a user at the same time
+	mutex      *sync.Mutex
+	lastHeight uint64
+}
+
+func NewSnapshotter(dir string, every uint64, keep int) *Snapshotter {
+	return &Snapshotter{Dir: dir, Every: every, Keep: keep, mutex: &sync.Mutex{}, lastHeight: 0}
+}
+
+// SnapshotPath returns the file used for the snapshot of the block, the names are sorted by height
+func (s *Snapshotter) SnapshotPath(height uint64) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%020d%s", height, SnapshotExtension))
+}
+
+// Snapshots returns the snapshot files of the directory, from the newest to the oldest one
+func (s *Snapshotter) Snapshots() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), SnapshotExtension) {
+			ret = append(ret, filepath.Join(s.Dir, entry.Name()))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ret)))
+	return ret, nil
+}
+
+// Save writes a snapshot of the last processed block and removes the old ones, it can be called while the indexer is running
+func (s *Snapshotter) Save(db *Database) (*SnapshotHeader, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	if db.LastProcessedBlock() == nil {
+		return nil, fmt.Errorf("there are no processed blocks")
+	}
+	// More blocks may be applied before the state is exported, the file is named after the exported one
+	pending := filepath.Join(s.Dir, "pending"+SnapshotExtension+".tmp")
+	header, err := WriteSnapshot(db, pending)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(pending, s.SnapshotPath(header.Height)); err != nil {
+		os.Remove(pending)
+		return nil, err
+	}
+	s.lastHeight = header.Height
+
+	if s.Keep > 0 {
+		snapshots, err := s.Snapshots()
+		if err != nil {
+			return header, err
+		}
+		for i := s.Keep; i < len(snapshots); i++ {
+			if err := os.Remove(snapshots[i]); err != nil {
+				logger.LogError(fmt.Sprintf("[indexer] error removing the snapshot %s: %s", snapshots[i], err))
+			}
+		}
+	}
+	return header, nil
+}
+
+// SaveIfDue is called after each applied block, it only saves when `Every` blocks were processed since the last snapshot
+func (s *Snapshotter) SaveIfDue(db *Database) {
+	if s == nil || s.Every == 0 {
+		return
+	}
+	last := db.LastProcessedBlock()
+	if last == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.lastHeight == 0 {
+		// The first snapshot is written after `Every` blocks too
+		s.lastHeight = last.Height
+	}
+	due := last.Height >= s.lastHeight+s.Every
+	s.mutex.Unlock()
+	if !due {
+		return
+	}
+	if _, err := s.Save(db); err != nil {
+		logger.LogError(fmt.Sprintf("[indexer] error saving the snapshot: %s", err))
+	}
+}
+
+// Load restores the newest valid snapshot of the directory, the corrupted ones are skipped. It returns false if there are none.
+func (s *Snapshotter) Load(db *Database) (bool, error) {
+	snapshots, err := s.Snapshots()
+	if err != nil {
+		return false, err
+	}
+	for _, path := range snapshots {
+		header, err := LoadSnapshot(db, path)
+		if err != nil {
+			logger.LogError(fmt.Sprintf("[indexer] error loading the snapshot %s, trying the previous one: %s", path, err))
+			continue
+		}
+		s.mutex.Lock()
+		s.lastHeight = header.Height
+		s.mutex.Unlock()
+		return true, nil
+	}
+	return false, nil
+}
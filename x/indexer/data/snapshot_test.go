@@ -0,0 +1,125 @@
+package data
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	db := NewDatabase()
+	db.SetChainID("1")
+	table := testTable(db)
+	applyBlock(db, table, 1, map[byte]int64{1: 1, 2: 2})
+	applyBlock(db, table, 2, map[byte]int64{2: 20})
+	addDeadLetter(db, 2, 5)
+	db.SetConfirmedHeight(1)
+
+	path := filepath.Join(t.TempDir(), "state"+SnapshotExtension)
+	header, err := WriteSnapshot(db, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.ChainID != "1" || header.Height != 2 || header.Hash != (common.Hash{31: 2}) || header.Worlds != 1 || header.Tables != 1 || header.Rows != 2 {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if verified, err := VerifySnapshot(path); err != nil || verified.Checksum != header.Checksum {
+		t.Fatalf("the snapshot was not verified: %v", err)
+	}
+
+	loaded := NewDatabase()
+	if _, err := LoadSnapshot(loaded, path); err != nil {
+		t.Fatal(err)
+	}
+	loadedTable := loaded.GetTable(table.Metadata.WorldAddress, table.Metadata.TableID)
+	key2 := "0x" + common.Bytes2Hex([]byte{2})
+	if value := rowValue(t, loaded, loadedTable, key2); value != `"f0":20` {
+		t.Errorf("unexpected row %q", value)
+	}
+	if provenance, err := loaded.GetProvenance(loadedTable, key2); err != nil || provenance.BlockNumber != 2 {
+		t.Errorf("unexpected provenance %v: %v", provenance, err)
+	}
+	if info := loaded.Info(); info.ChainID != "1" || info.ConfirmedHeight != 1 {
+		t.Errorf("unexpected chain status %+v", info)
+	}
+	if deadLetters := loaded.DeadLetters(); len(deadLetters) != 1 || deadLetters[0].LogIndex != 5 {
+		t.Errorf("unexpected dead letters %+v", deadLetters)
+	}
+
+	// The tracked blocks are streamed too
+	if err := loaded.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if value := rowValue(t, loaded, loadedTable, key2); value != `"f0":2` {
+		t.Errorf("the block was not reverted: %q", value)
+	}
+}
+
+func TestVerifySnapshotDetectsCorruption(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	applyBlock(db, table, 1, map[byte]int64{1: 1})
+	path := filepath.Join(t.TempDir(), "state"+SnapshotExtension)
+	header, err := WriteSnapshot(db, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another checksum in the header
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := bytes.Replace(content, []byte(header.Checksum), []byte(strings.Repeat("0", len(header.Checksum))), 1)
+	if err := os.WriteFile(path, corrupted, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifySnapshot(path); err == nil {
+		t.Fatal("the corrupted snapshot was verified")
+	}
+}
+
+func TestSnapshotterWhileWriting(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	applyBlock(db, table, 1, map[byte]int64{1: 1})
+	snapshots := NewSnapshotter(t.TempDir(), 0, 0)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for height := uint64(2); height < 50; height++ {
+			applyBlock(db, table, height, map[byte]int64{1: int64(height)})
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if _, err := snapshots.Save(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	// Each file is named after the block of its state
+	paths, err := snapshots.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no snapshots were written")
+	}
+	for _, path := range paths {
+		header, err := VerifySnapshot(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != snapshots.SnapshotPath(header.Height) {
+			t.Errorf("the snapshot %s has the block %d", path, header.Height)
+		}
+	}
+}
@@ -0,0 +1,469 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// StateVersion must be increased every time that the stored state format changes.
+// Version 1 did not have the confirmed height and the tracked blocks, it is still imported.
+const StateVersion = 2
+
+type StoredFieldData struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type StoredField struct {
+	Key  string          `json:"key"`
+	Data StoredFieldData `json:"data"`
+}
+
+type StoredTable struct {
+	Metadata    TableMetadata                    `json:"metadata"`
+	FieldNames  []string                         `json:"field_names"`
+	KeyNames    []string                         `json:"key_names"`
+	Schema      mudhelpers.SchemaTypeKV          `json:"schema"`
+	NamedFields map[string]mudhelpers.SchemaType `json:"named_fields"`
+	Rows        map[string][]StoredField         `json:"rows"`
+	Provenance  map[string]Provenance            `json:"provenance,omitempty"`
+}
+
+type StoredWorld struct {
+	Address string        `json:"address"`
+	Tables  []StoredTable `json:"tables"`
+}
+
+// StoredChange is the value of a row before it was modified by a tracked block
+type StoredChange struct {
+	World      string        `json:"world"`
+	TableID    string        `json:"table_id"`
+	Key        string        `json:"key"`
+	Existed    bool          `json:"existed"`
+	Fields     []StoredField `json:"fields,omitempty"`
+	Provenance *Provenance   `json:"provenance,omitempty"`
+}
+
+// StoredBlock is a tracked block with the changes needed to revert it
+type StoredBlock struct {
+	Height  uint64         `json:"height"`
+	Hash    common.Hash    `json:"hash"`
+	Changes []StoredChange `json:"changes,omitempty"`
+	// Tables before they were registered or renamed by the block, without rows
+	Tables []StoredTable `json:"tables,omitempty"`
+}
+
+// StoredState is the database content and the last block that was applied to it
+type StoredState struct {
+	Version         int           `json:"version"`
+	ChainID         string        `json:"chain_id"`
+	Height          uint64        `json:"height"`
+	Hash            common.Hash   `json:"hash"`
+	ConfirmedHeight uint64        `json:"confirmed_height"`
+	Worlds          []StoredWorld `json:"worlds"`
+	// Tracked blocks from the oldest to the newest one, they are needed to revert a reorg after a restart
+	Blocks []StoredBlock `json:"blocks,omitempty"`
+	// Logs that could not be applied, they can still be retried after a restart
+	DeadLetters []DeadLetter `json:"dead_letters,omitempty"`
+	// Logs that deleted rows after the dead letters failed, by table storage id and key
+	Tombstones map[string]Provenance `json:"tombstones,omitempty"`
+}
+
+func EncodeFieldData(f FieldData) (StoredFieldData, error) {
+	// Fields with unknown schema types are decoded as nil
+	if f == nil {
+		return StoredFieldData{Type: "", Value: json.RawMessage("null")}, nil
+	}
+
+	var value interface{}
+	switch v := f.(type) {
+	case BytesField:
+		value = hexutil.Encode(v.Data)
+	case StringField:
+		value = v.Data
+	case UintField:
+		value = v.Data.String()
+	case IntField:
+		value = v.Data.String()
+	case BoolField:
+		value = v.Data
+	case AddressField:
+		value = v.Data.Hex()
+	case ArrayField:
+		elements := make([]StoredFieldData, 0, len(v.Data))
+		for _, element := range v.Data {
+			encoded, err := EncodeFieldData(element)
+			if err != nil {
+				return StoredFieldData{}, err
+			}
+			elements = append(elements, encoded)
+		}
+		value = elements
+	default:
+		return StoredFieldData{}, fmt.Errorf("unknown field type %T", f)
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return StoredFieldData{}, err
+	}
+	return StoredFieldData{Type: f.Type(), Value: raw}, nil
+}
+
+func decodeBigInt(raw json.RawMessage) (big.Int, error) {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return big.Int{}, err
+	}
+	ret, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return big.Int{}, fmt.Errorf("invalid number %s", value)
+	}
+	return *ret, nil
+}
+
+func DecodeFieldData(stored StoredFieldData) (FieldData, error) {
+	switch stored.Type {
+	case "":
+		return nil, nil
+	case BytesField{}.Type():
+		var value string
+		if err := json.Unmarshal(stored.Value, &value); err != nil {
+			return nil, err
+		}
+		decoded, err := hexutil.Decode(value)
+		if err != nil {
+			return nil, err
+		}
+		return NewBytesField(decoded), nil
+	case StringField{}.Type():
+		var value string
+		if err := json.Unmarshal(stored.Value, &value); err != nil {
+			return nil, err
+		}
+		return NewStringFieldFromValue(value), nil
+	case UintField{}.Type():
+		value, err := decodeBigInt(stored.Value)
+		if err != nil {
+			return nil, err
+		}
+		return UintField{Data: value}, nil
+	case IntField{}.Type():
+		value, err := decodeBigInt(stored.Value)
+		if err != nil {
+			return nil, err
+		}
+		return IntField{Data: value}, nil
+	case BoolField{}.Type():
+		var value bool
+		if err := json.Unmarshal(stored.Value, &value); err != nil {
+			return nil, err
+		}
+		return NewBoolFromValue(value), nil
+	case AddressField{}.Type():
+		var value string
+		if err := json.Unmarshal(stored.Value, &value); err != nil {
+			return nil, err
+		}
+		return AddressField{Data: common.HexToAddress(value)}, nil
+	case ArrayField{}.Type():
+		var elements []StoredFieldData
+		if err := json.Unmarshal(stored.Value, &elements); err != nil {
+			return nil, err
+		}
+		array := NewArrayField(len(elements))
+		for i, element := range elements {
+			decoded, err := DecodeFieldData(element)
+			if err != nil {
+				return nil, err
+			}
+			array.Data[i] = decoded
+		}
+		return array, nil
+	default:
+		return nil, fmt.Errorf("unknown field type %s", stored.Type)
+	}
+}
+
+func encodeFields(fields []Field) ([]StoredField, error) {
+	ret := make([]StoredField, 0, len(fields))
+	for _, field := range fields {
+		encoded, err := EncodeFieldData(field.Data)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, StoredField{Key: field.Key, Data: encoded})
+	}
+	return ret, nil
+}
+
+func decodeFields(stored []StoredField) ([]Field, error) {
+	ret := make([]Field, 0, len(stored))
+	for _, field := range stored {
+		decoded, err := DecodeFieldData(field.Data)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, Field{Key: field.Key, Data: decoded})
+	}
+	return ret, nil
+}
+
+// encodeSchema encodes the table without its rows
+func encodeSchema(table *Table) StoredTable {
+	return StoredTable{
+		Metadata:    *table.Metadata,
+		FieldNames:  *table.Schema.FieldNames,
+		KeyNames:    *table.Schema.KeyNames,
+		Schema:      *table.Schema.Schema,
+		NamedFields: *table.Schema.NamedFields,
+		Rows:        map[string][]StoredField{},
+		Provenance:  map[string]Provenance{},
+	}
+}
+
+func (db *Database) encodeTable(table *Table) (StoredTable, error) {
+	ret := encodeSchema(table)
+	var encodeErr error
+	err := db.rows().IterateRows(storageID(table), func(key string, row Row) bool {
+		fields, err := encodeFields(row.Fields)
+		if err != nil {
+			encodeErr = fmt.Errorf("error encoding the row %s of table %s: %w", key, table.Metadata.TableName, err)
+			return false
+		}
+		ret.Rows[key] = fields
+		if row.Provenance != nil {
+			ret.Provenance[key] = *row.Provenance
+		}
+		return true
+	})
+	if err != nil {
+		return StoredTable{}, err
+	}
+	return ret, encodeErr
+}
+
+func decodeTable(stored StoredTable) *Table {
+	metadata := stored.Metadata
+	schema := stored.Schema
+	fieldNames := stored.FieldNames
+	keyNames := stored.KeyNames
+	namedFields := stored.NamedFields
+	if namedFields == nil {
+		namedFields = map[string]mudhelpers.SchemaType{}
+	}
+
+	return &Table{
+		Metadata: &metadata,
+		Schema:   &TableSchema{FieldNames: &fieldNames, KeyNames: &keyNames, Schema: &schema, NamedFields: &namedFields},
+	}
+}
+
+func decodeRows(stored StoredTable) (map[string]Row, error) {
+	rows := map[string]Row{}
+	for key, storedFields := range stored.Rows {
+		fields, err := decodeFields(storedFields)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding the row %s of table %s: %w", key, stored.Metadata.TableName, err)
+		}
+		row := Row{Fields: fields}
+		if v, ok := stored.Provenance[key]; ok {
+			row.Provenance = &v
+		}
+		rows[key] = row
+	}
+	return rows, nil
+}
+
+func (db *Database) decodeWorlds(storedWorlds []StoredWorld) map[string]*World {
+	worlds := map[string]*World{}
+	for _, storedWorld := range storedWorlds {
+		world := &World{Address: storedWorld.Address, Tables: map[string]*Table{}, db: db}
+		for _, storedTable := range storedWorld.Tables {
+			table := decodeTable(storedTable)
+			world.Tables[table.Metadata.TableID] = table
+		}
+		worlds[world.Address] = world
+	}
+	return worlds
+}
+
+func encodeBlock(block *ProcessedBlock) (StoredBlock, error) {
+	ret := StoredBlock{Height: block.Height, Hash: block.Hash, Changes: []StoredChange{}, Tables: []StoredTable{}}
+	for _, change := range block.changes {
+		fields, err := encodeFields(change.fields)
+		if err != nil {
+			return StoredBlock{}, fmt.Errorf("error encoding the change of row %s in block %d: %w", change.key, block.Height, err)
+		}
+		ret.Changes = append(ret.Changes, StoredChange{
+			World:      change.table.Metadata.WorldAddress,
+			TableID:    change.table.Metadata.TableID,
+			Key:        change.key,
+			Existed:    change.existed,
+			Fields:     fields,
+			Provenance: change.provenance,
+		})
+	}
+	for _, change := range block.tables {
+		ret.Tables = append(ret.Tables, encodeSchema(change.previous))
+	}
+	return ret, nil
+}
+
+// decodeBlock uses the tables of the decoded worlds, the changes of unknown tables are dropped
+func decodeBlock(stored StoredBlock, worlds map[string]*World) (*ProcessedBlock, error) {
+	ret := &ProcessedBlock{Height: stored.Height, Hash: stored.Hash, changes: []rowChange{}}
+	for _, change := range stored.Changes {
+		world, ok := worlds[change.World]
+		if !ok {
+			continue
+		}
+		table, ok := world.Tables[change.TableID]
+		if !ok {
+			continue
+		}
+		decoded := rowChange{table: table, key: change.Key, existed: change.Existed, provenance: change.Provenance}
+		if change.Existed {
+			fields, err := decodeFields(change.Fields)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding the change of row %s in block %d: %w", change.Key, stored.Height, err)
+			}
+			decoded.fields = fields
+		}
+		ret.changes = append(ret.changes, decoded)
+	}
+	for _, previous := range stored.Tables {
+		world, ok := worlds[previous.Metadata.WorldAddress]
+		if !ok {
+			continue
+		}
+		if table, ok := world.Tables[previous.Metadata.TableID]; ok {
+			ret.tables = append(ret.tables, tableChange{table: table, previous: decodeTable(previous)})
+		}
+	}
+	return ret, nil
+}
+
+// exportBlocks must be called with the lock
+func (db *Database) exportBlocks() ([]StoredBlock, error) {
+	ret := make([]StoredBlock, 0, len(db.processedBlocks))
+	for _, block := range db.processedBlocks {
+		stored, err := encodeBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, stored)
+	}
+	return ret, nil
+}
+
+// checkStateVersion accepts the current and the previous state versions
+func checkStateVersion(version int) error {
+	if version < 1 || version > StateVersion {
+		return fmt.Errorf("unsupported state version %d, expected %d", version, StateVersion)
+	}
+	return nil
+}
+
+// exportState must be called with the lock, the durable storages save the state and the dead letters without the rows and the tracked blocks
+func (db *Database) exportState(withRows bool) (*StoredState, error) {
+	state := &StoredState{Version: StateVersion, ChainID: db.ChainID, ConfirmedHeight: db.ConfirmedHeight, Worlds: []StoredWorld{}, DeadLetters: db.DeadLetters(), Tombstones: db.Tombstones()}
+	if last := db.lastProcessedBlock(); last != nil {
+		state.Height = last.Height
+		state.Hash = last.Hash
+	}
+
+	for _, world := range db.worldList() {
+		storedWorld := StoredWorld{Address: world.Address, Tables: []StoredTable{}}
+		for _, table := range world.tableList() {
+			storedTable := encodeSchema(table)
+			if withRows {
+				var err error
+				if storedTable, err = db.encodeTable(table); err != nil {
+					return nil, err
+				}
+			}
+			storedWorld.Tables = append(storedWorld.Tables, storedTable)
+		}
+		state.Worlds = append(state.Worlds, storedWorld)
+	}
+
+	if withRows {
+		blocks, err := db.exportBlocks()
+		if err != nil {
+			return nil, err
+		}
+		state.Blocks = blocks
+	}
+	return state, nil
+}
+
+// ExportState encodes the tables, the confirmed height, the tracked blocks and the dead letters
+func (db *Database) ExportState() (*StoredState, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.exportState(true)
+}
+
+// ImportState replaces the database tables and the tracked blocks, so the blocks applied before the state was saved can still be reverted
+func (db *Database) ImportState(state *StoredState) error {
+	if err := checkStateVersion(state.Version); err != nil {
+		return err
+	}
+
+	worlds := db.decodeWorlds(state.Worlds)
+	rows := map[*Table]map[string]Row{}
+	for _, storedWorld := range state.Worlds {
+		for _, storedTable := range storedWorld.Tables {
+			decoded, err := decodeRows(storedTable)
+			if err != nil {
+				return err
+			}
+			rows[worlds[storedWorld.Address].Tables[storedTable.Metadata.TableID]] = decoded
+		}
+	}
+	blocks := make([]*ProcessedBlock, 0, len(state.Blocks))
+	for _, storedBlock := range state.Blocks {
+		block, err := decodeBlock(storedBlock, worlds)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+	}
+
+	db.lock()
+	defer db.unlock()
+	db.reset()
+	db.worldsMutex.Lock()
+	db.Worlds = worlds
+	db.worldsMutex.Unlock()
+	for table, tableRows := range rows {
+		for key, row := range tableRows {
+			if err := db.tx.PutRow(storageID(table), key, row); err != nil {
+				return err
+			}
+		}
+	}
+	db.ChainID = state.ChainID
+	db.processedBlocks = blocks
+	for _, block := range blocks {
+		db.dirtyBlocks[block] = true
+	}
+	db.setDeadLetters(state.DeadLetters, state.Tombstones)
+	// The first state version only had the last block, it was considered confirmed
+	db.ConfirmedHeight = state.ConfirmedHeight
+	if state.Version == 1 {
+		db.ConfirmedHeight = state.Height
+	}
+	if last := db.lastProcessedBlock(); last == nil || last.Height != state.Height {
+		db.startBlock(state.Height, state.Hash)
+	}
+	db.LastUpdate = time.Now()
+	return nil
+}
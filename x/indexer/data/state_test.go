@@ -0,0 +1,104 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// reimport encodes the state like a checkpoint and loads it in a new database
+func reimport(t *testing.T, db *Database) *Database {
+	t.Helper()
+	state, err := db.ExportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &StoredState{}
+	if err := json.Unmarshal(encoded, decoded); err != nil {
+		t.Fatal(err)
+	}
+	ret := NewDatabase()
+	if err := ret.ImportState(decoded); err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestImportStateKeepsTrackedBlocks(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	for height := uint64(1); height <= 4; height++ {
+		db.BeginBlock(height, common.Hash{31: byte(height)})
+		db.AddRow(table, []byte{1}, uintFields("f0", int64(height)))
+		if height == 3 {
+			db.JournalTable(table)
+			table.Metadata.TableName = "Renamed"
+		}
+		db.EndBlock()
+	}
+	db.SetConfirmedHeight(2)
+
+	imported := reimport(t, db)
+	if info := imported.Info(); info.ConfirmedHeight != 2 {
+		t.Fatalf("the confirmed height was not restored: %d", info.ConfirmedHeight)
+	}
+	if blocks := imported.ProcessedBlocks(); len(blocks) != 4 || blocks[0].Height != 4 {
+		t.Fatalf("the tracked blocks were not restored: %v", blocks)
+	}
+	importedTable := imported.GetTable(table.Metadata.WorldAddress, table.Metadata.TableID)
+	if fields, err := imported.GetConfirmedRow(importedTable, "0x01"); err != nil || fields[0].String() != `"f0":2` {
+		t.Fatalf("unexpected confirmed row %v (%v)", fields, err)
+	}
+
+	// A reorg deeper than one block can be reverted after the restart
+	if err := imported.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if value := rowValue(t, imported, importedTable, "0x01"); value != `"f0":1` {
+		t.Errorf("the reverted row has the value %q", value)
+	}
+	if importedTable.Metadata.TableName != "Counter" {
+		t.Errorf("the table rename was not reverted: %s", importedTable.Metadata.TableName)
+	}
+	if info := imported.Info(); info.ConfirmedHeight != 1 {
+		t.Errorf("unexpected confirmed height %d", info.ConfirmedHeight)
+	}
+}
+
+func TestImportStateVersion1(t *testing.T) {
+	db := NewDatabase()
+	table := testTable(db)
+	db.BeginBlock(7, common.HexToHash("0x07"))
+	db.AddRow(table, []byte{1}, uintFields("f0", 1))
+	db.EndBlock()
+
+	state, err := db.ExportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Version = 1
+	state.Blocks = nil
+	state.ConfirmedHeight = 0
+
+	imported := NewDatabase()
+	if err := imported.ImportState(state); err != nil {
+		t.Fatal(err)
+	}
+	last := imported.LastProcessedBlock()
+	if last == nil || last.Height != 7 || last.Hash != common.HexToHash("0x07") {
+		t.Fatalf("unexpected last block %v", last)
+	}
+	if info := imported.Info(); info.ConfirmedHeight != 7 {
+		t.Errorf("the block of a version 1 state must be confirmed, got %d", info.ConfirmedHeight)
+	}
+
+	state.Version = StateVersion + 1
+	if err := imported.ImportState(state); err == nil {
+		t.Error("expected an error importing an unknown state version")
+	}
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/bocha-io/logger"
+)
+
+// LogStats counts the store logs processed by the indexer, used to export metrics
+type LogStats struct {
+	// Applied logs by event name
+	Logs         map[string]uint64
+	DecodeErrors uint64
+	ApplyErrors  uint64
+}
+
+func (db *Database) CountLog(eventName string) {
+	db.statsMutex.Lock()
+	defer db.statsMutex.Unlock()
+	db.logStats.Logs[eventName]++
+}
+
+func (db *Database) CountDecodeError() {
+	db.statsMutex.Lock()
+	defer db.statsMutex.Unlock()
+	db.logStats.DecodeErrors++
+}
+
+func (db *Database) CountApplyError() {
+	db.statsMutex.Lock()
+	defer db.statsMutex.Unlock()
+	db.logStats.ApplyErrors++
+}
+
+func (db *Database) LogStats() LogStats {
+	db.statsMutex.Lock()
+	defer db.statsMutex.Unlock()
+	ret := LogStats{Logs: map[string]uint64{}, DecodeErrors: db.logStats.DecodeErrors, ApplyErrors: db.logStats.ApplyErrors}
+	for k, v := range db.logStats.Logs {
+		ret.Logs[k] = v
+	}
+	return ret
+}
+
+// RowsPerTable returns the amount of confirmed rows of each table, grouped by world address
+func (db *Database) RowsPerTable() map[string]map[string]int {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	ret := map[string]map[string]int{}
+	for _, world := range db.worldList() {
+		worldID := world.Address
+		ret[worldID] = map[string]int{}
+		for _, table := range world.tableList() {
+			name := table.Metadata.TableName
+			if name == "" {
+				name = table.Metadata.TableID
+			}
+			count, err := db.rows().CountRows(storageID(table))
+			if err != nil {
+				logger.LogError(fmt.Sprintf("[indexer] error counting the rows of table %s: %s", name, err))
+			}
+			ret[worldID][name] = count
+		}
+	}
+	return ret
+}
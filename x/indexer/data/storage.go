@@ -0,0 +1,457 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Row is a table row as it is kept by the storage
+type Row struct {
+	Fields []Field
+	// Log that last modified the row, nil if it was not tracked
+	Provenance *Provenance
+}
+
+type StorageReader interface {
+	// GetRow returns false if the row does not exist
+	GetRow(table string, key string) (Row, bool, error)
+	// IterateRows stops when fn returns false, the order is not defined
+	IterateRows(table string, fn func(key string, row Row) bool) error
+	CountRows(table string) (int, error)
+}
+
+type StorageWriter interface {
+	PutRow(table string, key string, row Row) error
+	DeleteRow(table string, key string) error
+}
+
+// StorageTransaction keeps the writes until Commit, its reads already include them
+type StorageTransaction interface {
+	StorageReader
+	StorageWriter
+	// PutMetadata replaces the worlds and tables saved by a durable storage
+	PutMetadata(value []byte)
+	// PutStatus replaces the last block saved by a durable storage, it is written on every commit
+	PutStatus(value []byte)
+	// PutBlock replaces the journal of a tracked block saved by a durable storage, nil removes it
+	PutBlock(height uint64, value []byte)
+	// Clear removes every row, the metadata and the blocks before the other writes of the transaction
+	Clear()
+	Commit() error
+	Discard()
+}
+
+// Storage keeps the rows of every table, the tables are identified by their world address and table id.
+// The worlds, schemas and the blocks used for reorgs are kept by the database.
+type Storage interface {
+	StorageReader
+	StorageWriter
+	// Begin starts a transaction, the other readers see its writes at once after Commit
+	Begin() StorageTransaction
+	// Snapshot returns a read only copy of the committed rows, it must be closed
+	Snapshot() (Storage, error)
+	// Clear removes every row
+	Clear() error
+	Close() error
+}
+
+// DurableStorage keeps the rows after a restart, the database saves its worlds and tables in it to restore them
+type DurableStorage interface {
+	Storage
+	// GetMetadata returns false if nothing was saved yet
+	GetMetadata() ([]byte, bool, error)
+	// GetStatus returns false if nothing was saved yet
+	GetStatus() ([]byte, bool, error)
+	// GetBlocks returns the journals of the tracked blocks from the oldest to the newest one
+	GetBlocks() ([][]byte, error)
+}
+
+type rowWrite struct {
+	table   string
+	key     string
+	row     Row
+	deleted bool
+}
+
+// transactionWrites are the writes of a transaction, applied together by the storage
+type transactionWrites struct {
+	rows []rowWrite
+	// The storage is emptied before the other writes are applied
+	cleared  bool
+	metadata []byte
+	status   []byte
+	// Journals of the tracked blocks by height, nil removes the block
+	blocks map[uint64][]byte
+}
+
+// transaction is the StorageTransaction of the included storages, the writes are applied together by commit
+type transaction struct {
+	base     StorageReader
+	writes   map[string]map[string]*rowWrite
+	cleared  bool
+	metadata []byte
+	status   []byte
+	blocks   map[uint64][]byte
+	commit   func(writes transactionWrites) error
+}
+
+func newTransaction(base StorageReader, commit func(writes transactionWrites) error) *transaction {
+	return &transaction{base: base, writes: map[string]map[string]*rowWrite{}, cleared: false, metadata: nil, status: nil, blocks: map[uint64][]byte{}, commit: commit}
+}
+
+func (t *transaction) GetRow(table string, key string) (Row, bool, error) {
+	if write, ok := t.writes[table][key]; ok {
+		return write.row, !write.deleted, nil
+	}
+	if t.cleared {
+		return Row{}, false, nil
+	}
+	return t.base.GetRow(table, key)
+}
+
+func (t *transaction) IterateRows(table string, fn func(key string, row Row) bool) error {
+	pending := t.writes[table]
+	stopped := false
+	var err error
+	if !t.cleared {
+		err = t.base.IterateRows(table, func(key string, row Row) bool {
+			if _, ok := pending[key]; ok {
+				return true
+			}
+			stopped = !fn(key, row)
+			return !stopped
+		})
+	}
+	if err != nil || stopped {
+		return err
+	}
+	for key, write := range pending {
+		if !write.deleted && !fn(key, write.row) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (t *transaction) CountRows(table string) (int, error) {
+	count := 0
+	err := t.IterateRows(table, func(string, Row) bool {
+		count++
+		return true
+	})
+	return count, err
+}
+
+func (t *transaction) set(write rowWrite) {
+	if _, ok := t.writes[write.table]; !ok {
+		t.writes[write.table] = map[string]*rowWrite{}
+	}
+	t.writes[write.table][write.key] = &write
+}
+
+func (t *transaction) PutRow(table string, key string, row Row) error {
+	t.set(rowWrite{table: table, key: key, row: row})
+	return nil
+}
+
+func (t *transaction) DeleteRow(table string, key string) error {
+	t.set(rowWrite{table: table, key: key, deleted: true})
+	return nil
+}
+
+func (t *transaction) PutMetadata(value []byte) {
+	t.metadata = value
+}
+
+func (t *transaction) PutStatus(value []byte) {
+	t.status = value
+}
+
+func (t *transaction) PutBlock(height uint64, value []byte) {
+	t.blocks[height] = value
+}
+
+func (t *transaction) Clear() {
+	t.Discard()
+	t.cleared = true
+}
+
+func (t *transaction) Commit() error {
+	writes := transactionWrites{rows: []rowWrite{}, cleared: t.cleared, metadata: t.metadata, status: t.status, blocks: t.blocks}
+	for _, rows := range t.writes {
+		for _, write := range rows {
+			writes.rows = append(writes.rows, *write)
+		}
+	}
+	err := t.commit(writes)
+	t.Discard()
+	return err
+}
+
+func (t *transaction) Discard() {
+	t.writes = map[string]map[string]*rowWrite{}
+	t.cleared = false
+	t.metadata = nil
+	t.status = nil
+	t.blocks = map[uint64][]byte{}
+}
+
+// storageID identifies the table in the storage
+func storageID(table *Table) string {
+	return table.Metadata.WorldAddress + "/" + table.Metadata.TableID
+}
+
+// rows returns the open transaction while the write lock is held
+func (db *Database) rows() StorageReader {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.storage
+}
+
+func (db *Database) writer() StorageWriter {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.storage
+}
+
+// storedRow is used by the row modifications, the handler panic is turned into a dead letter by the indexer
+func (db *Database) storedRow(table *Table, key string) (Row, bool) {
+	row, ok, err := db.rows().GetRow(storageID(table), key)
+	if err != nil {
+		panic(fmt.Errorf("error reading the row %s of table %s: %w", key, table.Metadata.TableName, err))
+	}
+	return row, ok
+}
+
+// putRow keeps the previous provenance if the current log is not tracked
+func (db *Database) putRow(table *Table, key string, fields []Field) {
+	row := Row{Fields: fields, Provenance: nil}
+	if db.currentLog != nil {
+		provenance := *db.currentLog
+		row.Provenance = &provenance
+	} else {
+		if previous, ok := db.storedRow(table, key); ok {
+			row.Provenance = previous.Provenance
+		}
+	}
+	if err := db.writer().PutRow(storageID(table), key, row); err != nil {
+		panic(fmt.Errorf("error writing the row %s of table %s: %w", key, table.Metadata.TableName, err))
+	}
+	db.sinkRow(table, key, fields)
+}
+
+func (db *Database) removeRow(table *Table, key string) {
+	if err := db.writer().DeleteRow(storageID(table), key); err != nil {
+		panic(fmt.Errorf("error deleting the row %s of table %s: %w", key, table.Metadata.TableName, err))
+	}
+	db.addTombstone(table, key)
+	db.sinkRow(table, key, nil)
+}
+
+// copyRows returns a copy of the rows of the table, the storage errors are logged
+func (db *Database) copyRows(table *Table) map[string][]Field {
+	ret := map[string][]Field{}
+	err := db.rows().IterateRows(storageID(table), func(key string, row Row) bool {
+		temp := make([]Field, len(row.Fields))
+		copy(temp, row.Fields)
+		ret[key] = temp
+		return true
+	})
+	if err != nil {
+		logger.LogError(fmt.Sprintf("[indexer] error reading the rows of table %s: %s", table.Metadata.TableName, err))
+	}
+	return ret
+}
+
+// RewriteRows replaces the fields of every row of the table, used when the columns are renamed.
+// It must be called with the write lock, like the row modifications, and the rows are restored if the block is reverted.
+func (db *Database) RewriteRows(table *Table, fn func(fields []Field) []Field) {
+	rows := map[string]Row{}
+	err := db.rows().IterateRows(storageID(table), func(key string, row Row) bool {
+		rows[key] = row
+		return true
+	})
+	if err != nil {
+		panic(fmt.Errorf("error reading the rows of table %s: %w", table.Metadata.TableName, err))
+	}
+
+	db.sinkSchema(table)
+	for key, row := range rows {
+		// The rows are never modified in place, the readers may still use them
+		fields := make([]Field, len(row.Fields))
+		copy(fields, row.Fields)
+		row.Fields = fn(fields)
+		db.journalRow(table, key)
+		if err := db.writer().PutRow(storageID(table), key, row); err != nil {
+			panic(fmt.Errorf("error writing the row %s of table %s: %w", key, table.Metadata.TableName, err))
+		}
+	}
+}
+
+// storedStatus is the last block and the confirmed height, they change with every block so they are saved apart from the tables
+type storedStatus struct {
+	Height          uint64      `json:"height"`
+	Hash            common.Hash `json:"hash"`
+	ConfirmedHeight uint64      `json:"confirmed_height"`
+}
+
+// setMetadataDirty saves the worlds, the tables and the dead letters with the next commit
+func (db *Database) setMetadataDirty() {
+	db.metadataDirty.Store(true)
+}
+
+// saveMetadata stores the last block and the modified blocks of the journal in the transaction, the worlds and the tables are only
+// encoded when they changed. Only used by the durable storages.
+func (db *Database) saveMetadata() {
+	dirty := db.dirtyBlocks
+	db.dirtyBlocks = map[*ProcessedBlock]bool{}
+	if _, ok := db.storage.(DurableStorage); !ok || db.tx == nil {
+		return
+	}
+	db.saveBlocks(dirty)
+
+	status := storedStatus{ConfirmedHeight: db.ConfirmedHeight}
+	if last := db.lastProcessedBlock(); last != nil {
+		status.Height = last.Height
+		status.Hash = last.Hash
+	}
+	if encoded, err := json.Marshal(status); err == nil {
+		db.tx.PutStatus(encoded)
+	} else {
+		logger.LogError(fmt.Sprintf("[indexer] error encoding the last block: %s", err))
+	}
+
+	if !db.metadataDirty.Swap(false) {
+		return
+	}
+	state, err := db.exportState(false)
+	if err == nil {
+		var encoded []byte
+		if encoded, err = json.Marshal(state); err == nil {
+			db.tx.PutMetadata(encoded)
+			return
+		}
+	}
+	logger.LogError(fmt.Sprintf("[indexer] error encoding the database tables: %s", err))
+	db.setMetadataDirty()
+}
+
+// saveBlocks writes the modified blocks and removes the stored blocks that are not tracked anymore, so a reorg can be reverted after a restart
+func (db *Database) saveBlocks(dirty map[*ProcessedBlock]bool) {
+	tracked := map[uint64]bool{}
+	for _, block := range db.processedBlocks {
+		tracked[block.Height] = true
+		if !dirty[block] && db.storedBlocks[block.Height] {
+			continue
+		}
+		stored, err := encodeBlock(block)
+		if err == nil {
+			var encoded []byte
+			if encoded, err = json.Marshal(stored); err == nil {
+				db.tx.PutBlock(block.Height, encoded)
+				db.storedBlocks[block.Height] = true
+				continue
+			}
+		}
+		logger.LogError(fmt.Sprintf("[indexer] error encoding the block %d: %s", block.Height, err))
+	}
+	for height := range db.storedBlocks {
+		if !tracked[height] {
+			db.tx.PutBlock(height, nil)
+			delete(db.storedBlocks, height)
+		}
+	}
+}
+
+// loadBlocks restores the journal saved by saveBlocks, must be called after the worlds are decoded
+func (db *Database) loadBlocks(durable DurableStorage) error {
+	encoded, err := durable.GetBlocks()
+	if err != nil {
+		return err
+	}
+	for _, value := range encoded {
+		stored := StoredBlock{}
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return fmt.Errorf("error decoding the stored block: %w", err)
+		}
+		block, err := decodeBlock(stored, db.Worlds)
+		if err != nil {
+			return err
+		}
+		db.processedBlocks = append(db.processedBlocks, block)
+		db.storedBlocks[block.Height] = true
+	}
+	return nil
+}
+
+// loadMetadata restores the worlds, tables and last block saved in a durable storage
+func (db *Database) loadMetadata() error {
+	durable, ok := db.storage.(DurableStorage)
+	if !ok {
+		return nil
+	}
+	encoded, found, err := durable.GetMetadata()
+	if err != nil || !found {
+		return err
+	}
+
+	state := StoredState{}
+	if err := json.Unmarshal(encoded, &state); err != nil {
+		return fmt.Errorf("error decoding the stored tables: %w", err)
+	}
+	if err := checkStateVersion(state.Version); err != nil {
+		return err
+	}
+	// The last block is saved apart from the tables since the metadata is only written when they change
+	if encoded, found, err := durable.GetStatus(); err != nil {
+		return err
+	} else if found {
+		status := storedStatus{}
+		if err := json.Unmarshal(encoded, &status); err != nil {
+			return fmt.Errorf("error decoding the stored status: %w", err)
+		}
+		state.Height = status.Height
+		state.Hash = status.Hash
+		state.ConfirmedHeight = status.ConfirmedHeight
+	}
+
+	db.Worlds = db.decodeWorlds(state.Worlds)
+	db.ChainID = state.ChainID
+	db.setDeadLetters(state.DeadLetters, state.Tombstones)
+	if err := db.loadBlocks(durable); err != nil {
+		return err
+	}
+	// The first state version did not save the journal, its last block was considered confirmed
+	db.ConfirmedHeight = state.ConfirmedHeight
+	if state.Version == 1 {
+		db.ConfirmedHeight = state.Height
+	}
+	// Nothing was applied after a reset
+	if state.Hash != (common.Hash{}) {
+		db.startBlock(state.Height, state.Hash)
+	}
+	db.dirtyBlocks = map[*ProcessedBlock]bool{}
+	db.metadataDirty.Store(false)
+	logger.LogInfo(fmt.Sprintf("[indexer] storage loaded at block %d (%s)", state.Height, state.Hash.Hex()))
+	return nil
+}
+
+// Close waits for the sink and releases the storage, the database can not be used after it
+func (db *Database) Close() error {
+	db.mutex.Lock()
+	worker := db.sinkWorker
+	db.sinkWorker = nil
+	db.mutex.Unlock()
+	if worker != nil {
+		if err := worker.wait(); err != nil {
+			logger.LogError(fmt.Sprintf("[indexer] the sink did not apply the last changes: %s", err))
+		}
+		worker.close()
+	}
+	return db.storage.Close()
+}
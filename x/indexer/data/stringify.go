@@ -19,6 +19,14 @@ var SystemTables = []string{
 	"SystemRegistry",
 	"ResourceType",
 	"KeysWithValue",
+	// MUD v2
+	"Tables",
+	"ResourceIds",
+	"StoreHooks",
+	"SystemHooks",
+	"FunctionSignatures",
+	"UserDelegationControl",
+	"Balances",
 }
 
 func isSystemTable(key string) bool {
@@ -30,18 +38,24 @@ func isSystemTable(key string) bool {
 	return false
 }
 
-func processTable(ret *[]string, vT *Table) {
+func (db *Database) processTable(ret *[]string, vT *Table) {
 	*ret = append(*ret, fmt.Sprintf("\u2727 Table %s", vT.Metadata.TableName))
 	*ret = append(*ret, "  \u274a Rows:")
-	for kR, vR := range *vT.Rows {
+	err := db.rows().IterateRows(storageID(vT), func(kR string, vR Row) bool {
 		// key := hexutil.Encode([]byte(kR))
 		*ret = append(*ret, fmt.Sprintf("    \u2609 ID    : %s", kR))
 		*ret = append(*ret, "      Values:")
-		for _, b := range vR {
+		for _, b := range vR.Fields {
 			*ret = append(*ret, fmt.Sprintf("          \u26ad  %s", b.String()))
 		}
+		if vR.Provenance != nil {
+			*ret = append(*ret, fmt.Sprintf("      Updated: %s", vR.Provenance.String()))
+		}
 		*ret = append(*ret, "")
-
+		return true
+	})
+	if err != nil {
+		*ret = append(*ret, fmt.Sprintf("    Error: %s", err))
 	}
 }
 
@@ -52,11 +66,14 @@ func SeparatorOffset(maxLenght int, wordLength int) int {
 	return (maxLenght - wordLength - 1) / 2
 }
 
-func (db Database) ToStringList(maxLenght int) []string {
+func (db *Database) ToStringList(maxLenght int) []string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
 	// For each world create a new array
 	ret := make([]string, 0)
 	tempSysTables := make([]string, 0)
-	for _, vW := range db.Worlds {
+	for _, vW := range db.worldList() {
 
 		// World title
 
@@ -72,11 +89,11 @@ func (db Database) ToStringList(maxLenght int) []string {
 		gameTablesSeparator := strings.Repeat("\u2632", (SeparatorOffset(maxLenght, len(titleGameTables)) - 1))
 		ret = append(ret, fmt.Sprintf("%s %s %s", gui.ColorCyan(gameTablesSeparator), gui.ColorBlue(titleGameTables), gui.ColorCyan(gameTablesSeparator)))
 		ret = append(ret, "")
-		for _, vT := range vW.Tables {
+		for _, vT := range vW.tableList() {
 			if !isSystemTable(vT.Metadata.TableName) {
-				processTable(&ret, vT)
+				db.processTable(&ret, vT)
 			} else {
-				processTable(&tempSysTables, vT)
+				db.processTable(&tempSysTables, vT)
 			}
 		}
 		ret = append(ret, "")
@@ -0,0 +1,150 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type BackfillProgress struct {
+	From uint64
+	To   uint64
+	// Last block applied to the database
+	Height  uint64
+	Logs    int
+	Elapsed time.Duration
+}
+
+func (p BackfillProgress) Percentage() float64 {
+	if p.To <= p.From {
+		return 100
+	}
+	return float64(p.Height-p.From+1) * 100 / float64(p.To-p.From+1)
+}
+
+func (p BackfillProgress) BlocksPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Height-p.From+1) / p.Elapsed.Seconds()
+}
+
+type backfillRange struct {
+	from uint64
+	to   uint64
+	hash common.Hash
+	logs []DecodedLog
+	err  error
+}
+
+// Backfill fetches and decodes the block ranges concurrently, but they are applied to the database in order.
+// It returns the next height to be processed, that is still valid if the backfill failed in the middle.
+func Backfill(ctx context.Context, source LogSource, db *data.Database, filter mudhandlers.Filter, window *AdaptiveWindow, fetchTimestamps bool, from uint64, to uint64, workers int, progress func(BackfillProgress)) (uint64, error) {
+	if from > to {
+		return from, nil
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	batchSize := window.Size()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ranges := []*backfillRange{}
+	for start := from; start <= to; start += batchSize {
+		end := start + batchSize - 1
+		if end > to {
+			end = to
+		}
+		ranges = append(ranges, &backfillRange{from: start, to: end})
+	}
+
+	results := make([]chan *backfillRange, len(ranges))
+	for i := range results {
+		results[i] = make(chan *backfillRange, 1)
+	}
+
+	// Limit the amount of ranges that are kept in memory waiting to be applied
+	inFlight := make(chan struct{}, workers*2)
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range ranges {
+			select {
+			case inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				results[i] <- fetchRange(ctx, source, filter, window, fetchTimestamps, ranges[i])
+			}
+		}()
+	}
+
+	status := BackfillProgress{From: from, To: to}
+	start := time.Now()
+	for i := range ranges {
+		var r *backfillRange
+		select {
+		case r = <-results[i]:
+		case <-ctx.Done():
+			return ranges[i].from, ctx.Err()
+		}
+		<-inFlight
+
+		if r.err != nil {
+			return r.from, r.err
+		}
+
+		ApplyLogs(db, filter, r.logs)
+		db.StartBlock(r.to, r.hash)
+
+		status.Height = r.to
+		status.Logs += len(r.logs)
+		status.Elapsed = time.Since(start)
+		if progress != nil {
+			progress(status)
+		}
+	}
+
+	return to + 1, nil
+}
+
+func fetchRange(ctx context.Context, source LogSource, filter mudhandlers.Filter, window *AdaptiveWindow, fetchTimestamps bool, r *backfillRange) *backfillRange {
+	header, err := source.HeaderByNumber(ctx, new(big.Int).SetUint64(r.to))
+	if err != nil {
+		r.err = fmt.Errorf("error getting the header for block %d: %w", r.to, err)
+		return r
+	}
+	r.hash = header.Hash()
+
+	logs, err := FilterStoreLogs(ctx, source, filter, window, r.from, r.to)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	logs = OrderLogs(logs)
+	timestamps, err := BlockTimestamps(ctx, source, logs, fetchTimestamps, header)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.logs = setTimestamps(DecodeLogs(logs), timestamps)
+	return r
+}
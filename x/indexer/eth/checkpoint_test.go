@@ -0,0 +1,81 @@
+package eth
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+)
+
+func TestValidateCheckpointRevertsOrphanedBlocks(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemorySource(1)
+	source.AddLogs(
+		registerTableLog(t, 2, 0, "value"),
+		counterLog(t, 2, 1, 1, 10),
+		counterLog(t, 4, 0, 1, 20),
+		counterLog(t, 5, 0, 1, 30),
+	)
+	db := data.NewDatabase()
+	processRange(t, source, db, 0, 5)
+	db.SetChainID("1")
+
+	checkpoint := data.NewCheckpointer(filepath.Join(t.TempDir(), "checkpoint.json"), time.Hour)
+	if err := checkpoint.Save(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last two blocks are replaced while the indexer is stopped
+	source.Reorg(4)
+	source.SetHead(6)
+
+	restarted := data.NewDatabase()
+	if found, err := checkpoint.Load(restarted); err != nil || !found {
+		t.Fatalf("the checkpoint was not loaded: %v", err)
+	}
+	valid, err := ValidateCheckpoint(ctx, source, restarted, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid {
+		t.Fatal("the checkpoint was discarded instead of reverting the orphaned blocks")
+	}
+	if last := restarted.LastProcessedBlock(); last.Height != 2 {
+		t.Fatalf("expected a rollback to block 2, got %d", last.Height)
+	}
+	if value := counterValue(t, restarted, 1); value != `"value":10` {
+		t.Fatalf("unexpected value %q", value)
+	}
+}
+
+func TestValidateCheckpointResets(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemorySource(1)
+	source.AddLogs(registerTableLog(t, 2, 0, "value"), counterLog(t, 2, 1, 1, 10))
+	source.SetHead(3)
+	db := data.NewDatabase()
+	processRange(t, source, db, 2, 3)
+	db.SetChainID("1")
+
+	// Another chain
+	if valid, err := ValidateCheckpoint(ctx, source, db, "2"); err != nil || valid {
+		t.Fatalf("the checkpoint of another chain was accepted: %v", err)
+	}
+	if db.LastProcessedBlock() != nil {
+		t.Fatal("the database was not reset")
+	}
+
+	// Every tracked block was orphaned
+	processRange(t, source, db, 2, 3)
+	db.SetChainID("1")
+	source.Reorg(1)
+	source.SetHead(4)
+	if valid, err := ValidateCheckpoint(ctx, source, db, "1"); err != nil || valid {
+		t.Fatalf("the orphaned checkpoint was accepted: %v", err)
+	}
+	if db.LastProcessedBlock() != nil {
+		t.Fatal("the database was not reset")
+	}
+}
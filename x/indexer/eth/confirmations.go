@@ -0,0 +1,65 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	SafeTag      = "safe"
+	FinalizedTag = "finalized"
+)
+
+type ConfirmationPolicy struct {
+	// Amount of blocks that must be built on top of a block to consider it confirmed
+	Blocks uint64
+	// Use the node finality instead of the amount of blocks, it can be either `safe` or `finalized`
+	Tag string
+	// Do not apply the logs until they are confirmed
+	ConfirmedOnly bool
+}
+
+func (p ConfirmationPolicy) ConfirmedHeight(ctx context.Context, headers HeaderReader, head uint64) (uint64, error) {
+	var number rpc.BlockNumber
+	switch p.Tag {
+	case "":
+		if head < p.Blocks {
+			return 0, nil
+		}
+		return head - p.Blocks, nil
+	case SafeTag:
+		number = rpc.SafeBlockNumber
+	case FinalizedTag:
+		number = rpc.FinalizedBlockNumber
+	default:
+		return 0, fmt.Errorf("invalid confirmation tag %s", p.Tag)
+	}
+
+	header, err := headers.HeaderByNumber(ctx, big.NewInt(number.Int64()))
+	if err != nil {
+		return 0, fmt.Errorf("error getting the %s block: %w", p.Tag, err)
+	}
+	return header.Number.Uint64(), nil
+}
+
+// UpdateConfirmedHeight moves the database confirmed view, it never goes further than the last processed block
+func UpdateConfirmedHeight(ctx context.Context, headers HeaderReader, db *data.Database, policy ConfirmationPolicy, head uint64) error {
+	confirmed, err := policy.ConfirmedHeight(ctx, headers, head)
+	if err != nil {
+		return err
+	}
+
+	last := db.LastProcessedBlock()
+	if last == nil {
+		return nil
+	}
+	if last.Height < confirmed {
+		confirmed = last.Height
+	}
+	db.SetConfirmedHeight(confirmed)
+	return nil
+}
@@ -0,0 +1,118 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// taggedHeaders returns the safe and finalized blocks of the node
+type taggedHeaders struct {
+	safe      uint64
+	finalized uint64
+}
+
+func (h taggedHeaders) HeaderByNumber(_ context.Context, number *big.Int) (*types.Header, error) {
+	switch number.Int64() {
+	case rpc.SafeBlockNumber.Int64():
+		return &types.Header{Number: bigInt(h.safe)}, nil
+	case rpc.FinalizedBlockNumber.Int64():
+		return &types.Header{Number: bigInt(h.finalized)}, nil
+	}
+	return nil, errors.New("unexpected block number")
+}
+
+func TestConfirmationPolicy(t *testing.T) {
+	ctx := context.Background()
+	headers := taggedHeaders{safe: 8, finalized: 6}
+	tests := []struct {
+		policy   ConfirmationPolicy
+		head     uint64
+		expected uint64
+	}{
+		{ConfirmationPolicy{}, 10, 10},
+		{ConfirmationPolicy{Blocks: 3}, 10, 7},
+		{ConfirmationPolicy{Blocks: 3}, 3, 0},
+		{ConfirmationPolicy{Blocks: 3}, 2, 0},
+		{ConfirmationPolicy{Tag: SafeTag}, 10, 8},
+		{ConfirmationPolicy{Tag: FinalizedTag, Blocks: 3}, 10, 6},
+	}
+	for _, test := range tests {
+		confirmed, err := test.policy.ConfirmedHeight(ctx, headers, test.head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if confirmed != test.expected {
+			t.Errorf("%+v confirmed %d at head %d instead of %d", test.policy, confirmed, test.head, test.expected)
+		}
+	}
+	if _, err := (ConfirmationPolicy{Tag: "latest"}).ConfirmedHeight(ctx, headers, 10); err == nil {
+		t.Error("the invalid tag was accepted")
+	}
+}
+
+func TestConfirmedRowsAfterConfirmations(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemorySource(1)
+	source.AddLogs(
+		registerTableLog(t, 2, 0, "value"),
+		counterLog(t, 2, 1, 1, 10),
+		counterLog(t, 5, 0, 1, 20),
+		counterLog(t, 5, 1, 2, 30),
+	)
+	source.SetHead(6)
+	db := data.NewDatabase()
+	processRange(t, source, db, 0, 6)
+	policy := ConfirmationPolicy{Blocks: 3}
+
+	confirmedRows := func() map[string][]data.Field {
+		return db.GetConfirmedRows(counterTable(db))
+	}
+
+	// Block 5 only has 1 confirmation at head 6
+	if err := UpdateConfirmedHeight(ctx, source, db, policy, 6); err != nil {
+		t.Fatal(err)
+	}
+	if height := db.Info().ConfirmedHeight; height != 3 {
+		t.Fatalf("unexpected confirmed height %d", height)
+	}
+	rows := confirmedRows()
+	if len(rows) != 1 || rows[hexKey(1)][0].String() != `"value":10` {
+		t.Errorf("unexpected confirmed rows before the confirmations %v", rows)
+	}
+	if fields, err := db.GetConfirmedRow(counterTable(db), hexKey(2)); err == nil {
+		t.Errorf("the unconfirmed row was returned: %v", fields)
+	}
+	if value := counterValue(t, db, 1); value != `"value":20` {
+		t.Errorf("the latest row was not applied: %q", value)
+	}
+
+	// The confirmed height does not go further than the last processed block
+	if err := UpdateConfirmedHeight(ctx, source, db, policy, 20); err != nil {
+		t.Fatal(err)
+	}
+	if height := db.Info().ConfirmedHeight; height != 6 {
+		t.Fatalf("unexpected confirmed height %d", height)
+	}
+	rows = confirmedRows()
+	if len(rows) != 2 || rows[hexKey(1)][0].String() != `"value":20` || rows[hexKey(2)][0].String() != `"value":30` {
+		t.Errorf("unexpected confirmed rows after the confirmations %v", rows)
+	}
+
+	// Reverting confirmed blocks moves the confirmed view back
+	if err := db.Rollback(2); err != nil {
+		t.Fatal(err)
+	}
+	if height := db.Info().ConfirmedHeight; height != 2 {
+		t.Fatalf("the confirmed height was not moved back: %d", height)
+	}
+	rows = confirmedRows()
+	if len(rows) != 1 || rows[hexKey(1)][0].String() != `"value":10` {
+		t.Errorf("unexpected confirmed rows after the rollback %v", rows)
+	}
+}
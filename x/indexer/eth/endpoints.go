@@ -0,0 +1,367 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	gethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	// Score added for each consecutive error
+	EndpointErrorPenalty = 500 * time.Millisecond
+	// Score added for each block that the endpoint is behind the best known head
+	EndpointLagPenalty = 200 * time.Millisecond
+	// Consecutive errors needed to mark an endpoint as down
+	EndpointMaxErrors = 3
+	// Time that a down endpoint is only used as the last option
+	EndpointCooldown = 30 * time.Second
+)
+
+type Endpoint struct {
+	URL         string
+	client      *gethclient.Client
+	latency     time.Duration
+	errors      int
+	head        uint64
+	lastError   error
+	lastFailure time.Time
+}
+
+type EndpointStatus struct {
+	URL       string
+	Latency   time.Duration
+	Errors    int
+	Head      uint64
+	Lag       uint64
+	Score     time.Duration
+	Down      bool
+	LastError error
+}
+
+// EndpointPool sends each request to the healthiest endpoint and fails over to the next one on errors
+type EndpointPool struct {
+	endpoints []*Endpoint
+	// Endpoint of the last new heads subscription, it is preferred for the requests about the streamed blocks
+	pinned *Endpoint
+	mutex  *sync.Mutex
+}
+
+func NewEndpointPool(urls []string) (*EndpointPool, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("at least one rpc endpoint is required")
+	}
+
+	pool := &EndpointPool{endpoints: []*Endpoint{}, mutex: &sync.Mutex{}}
+	for _, url := range urls {
+		pool.endpoints = append(pool.endpoints, &Endpoint{URL: url})
+	}
+	return pool, nil
+}
+
+func (p *EndpointPool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, e := range p.endpoints {
+		if e.client != nil {
+			e.client.Close()
+			e.client = nil
+		}
+	}
+}
+
+func (p *EndpointPool) bestHead() uint64 {
+	head := uint64(0)
+	for _, e := range p.endpoints {
+		if e.head > head {
+			head = e.head
+		}
+	}
+	return head
+}
+
+func (p *EndpointPool) isDown(e *Endpoint) bool {
+	return e.errors >= EndpointMaxErrors && time.Since(e.lastFailure) < EndpointCooldown
+}
+
+func (p *EndpointPool) score(e *Endpoint, bestHead uint64) time.Duration {
+	return e.latency + time.Duration(e.errors)*EndpointErrorPenalty + time.Duration(bestHead-e.head)*EndpointLagPenalty
+}
+
+func (p *EndpointPool) Status() []EndpointStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	bestHead := p.bestHead()
+	ret := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		ret = append(ret, EndpointStatus{
+			URL:       e.URL,
+			Latency:   e.latency,
+			Errors:    e.errors,
+			Head:      e.head,
+			Lag:       bestHead - e.head,
+			Score:     p.score(e, bestHead),
+			Down:      p.isDown(e),
+			LastError: e.lastError,
+		})
+	}
+	return ret
+}
+
+// ranked returns the endpoints from the healthiest to the worst one, the down endpoints are always last.
+// The preferred endpoint is always first unless it is down.
+func (p *EndpointPool) ranked(preferred *Endpoint) []*Endpoint {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	bestHead := p.bestHead()
+	ret := make([]*Endpoint, len(p.endpoints))
+	copy(ret, p.endpoints)
+	sort.SliceStable(ret, func(i, j int) bool {
+		if p.isDown(ret[i]) != p.isDown(ret[j]) {
+			return !p.isDown(ret[i])
+		}
+		if (ret[i] == preferred) != (ret[j] == preferred) {
+			return ret[i] == preferred
+		}
+		return p.score(ret[i], bestHead) < p.score(ret[j], bestHead)
+	})
+	return ret
+}
+
+func (p *EndpointPool) connect(ctx context.Context, e *Endpoint) (*gethclient.Client, error) {
+	p.mutex.Lock()
+	client := e.client
+	p.mutex.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	// Do not hold the lock while dialing, websocket connections may take a while
+	client, err := gethclient.DialContext(ctx, e.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if e.client != nil {
+		client.Close()
+		return e.client, nil
+	}
+	e.client = client
+	return client, nil
+}
+
+func (p *EndpointPool) record(e *Endpoint, latency time.Duration, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err == nil {
+		if e.latency == 0 {
+			e.latency = latency
+		} else {
+			e.latency = (e.latency*4 + latency) / 5
+		}
+		e.errors = 0
+		return
+	}
+
+	e.errors++
+	e.lastError = err
+	e.lastFailure = time.Now()
+}
+
+func (p *EndpointPool) setHead(e *Endpoint, head uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	e.head = head
+}
+
+func (p *EndpointPool) pinnedEndpoint() *Endpoint {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.pinned
+}
+
+func (p *EndpointPool) call(ctx context.Context, method string, fn func(e *Endpoint, client *gethclient.Client) error) error {
+	return p.callPreferring(ctx, method, nil, fn)
+}
+
+// callPreferring tries the preferred endpoint first, it is used for the requests that must be answered by the subscribed endpoint
+func (p *EndpointPool) callPreferring(ctx context.Context, method string, preferred *Endpoint, fn func(e *Endpoint, client *gethclient.Client) error) error {
+	var lastErr error
+	notFound := false
+	for _, e := range p.ranked(preferred) {
+		client, err := p.connect(ctx, e)
+		if err != nil {
+			p.record(e, 0, err)
+			lastErr = err
+			continue
+		}
+
+		start := time.Now()
+		err = fn(e, client)
+		if errors.Is(err, rpc.ErrNotificationsUnsupported) {
+			// It is not an endpoint failure, it is just an http endpoint
+			lastErr = err
+			continue
+		}
+		if errors.Is(err, ethereum.NotFound) {
+			// The endpoint answered, but it may be behind the others so they are asked too
+			p.record(e, time.Since(start), nil)
+			notFound = true
+			continue
+		}
+		if method == "filterLogs" && IsRangeError(err) {
+			// The endpoint is healthy, the caller must request a smaller range
+			p.record(e, time.Since(start), nil)
+			return err
+		}
+		p.record(e, time.Since(start), err)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lastErr = err
+		logger.LogError(fmt.Sprintf("[indexer] %s failed using %s, trying the next endpoint: %s", method, e.URL, err))
+	}
+	if notFound {
+		// The block or transaction does not exist yet
+		return ethereum.NotFound
+	}
+	return fmt.Errorf("%s failed using every endpoint: %w", method, lastErr)
+}
+
+func (p *EndpointPool) BlockNumber(ctx context.Context) (uint64, error) {
+	var height uint64
+	err := p.call(ctx, "blockNumber", func(e *Endpoint, client *gethclient.Client) error {
+		var err error
+		height, err = client.BlockNumber(ctx)
+		if err == nil {
+			p.setHead(e, height)
+		}
+		return err
+	})
+	return height, err
+}
+
+func (p *EndpointPool) ChainID(ctx context.Context) (*big.Int, error) {
+	var chainID *big.Int
+	err := p.call(ctx, "chainID", func(_ *Endpoint, client *gethclient.Client) error {
+		var err error
+		chainID, err = client.ChainID(ctx)
+		return err
+	})
+	return chainID, err
+}
+
+func (p *EndpointPool) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var header *types.Header
+	err := p.call(ctx, "headerByNumber", func(_ *Endpoint, client *gethclient.Client) error {
+		var err error
+		header, err = client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+// FilterLogs sends the requests for a single block to the subscribed endpoint first, the other endpoints may not know the streamed block yet
+func (p *EndpointPool) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	var preferred *Endpoint
+	if q.BlockHash != nil {
+		preferred = p.pinnedEndpoint()
+	}
+	var logs []types.Log
+	err := p.callPreferring(ctx, "filterLogs", preferred, func(_ *Endpoint, client *gethclient.Client) error {
+		var err error
+		logs, err = client.FilterLogs(ctx, q)
+		return err
+	})
+	return logs, err
+}
+
+// SubscribeNewHead pins the endpoint that serves the subscription, so the logs of the streamed blocks are requested from it
+func (p *EndpointPool) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	var sub ethereum.Subscription
+	err := p.call(ctx, "subscribeNewHead", func(e *Endpoint, client *gethclient.Client) error {
+		var err error
+		sub, err = client.SubscribeNewHead(ctx, ch)
+		if err == nil {
+			p.mutex.Lock()
+			p.pinned = e
+			p.mutex.Unlock()
+		}
+		return err
+	})
+	return sub, err
+}
+
+// SubscribeFilterLogs uses the endpoint of the new heads subscription if there is one, so both streams come from the same node
+func (p *EndpointPool) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	var sub ethereum.Subscription
+	err := p.callPreferring(ctx, "subscribeFilterLogs", p.pinnedEndpoint(), func(_ *Endpoint, client *gethclient.Client) error {
+		var err error
+		sub, err = client.SubscribeFilterLogs(ctx, q, ch)
+		return err
+	})
+	return sub, err
+}
+
+// Refresh requests the head of every endpoint to update their latency and lag
+func (p *EndpointPool) Refresh(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func(e *Endpoint) {
+			defer wg.Done()
+			client, err := p.connect(ctx, e)
+			if err != nil {
+				p.record(e, 0, err)
+				return
+			}
+			start := time.Now()
+			height, err := client.BlockNumber(ctx)
+			p.record(e, time.Since(start), err)
+			if err == nil {
+				p.setHead(e, height)
+			}
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (p *EndpointPool) Monitor(ctx context.Context, interval time.Duration) {
+	for {
+		p.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (p *EndpointPool) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	var receipt *types.Receipt
+	err := p.call(ctx, "transactionReceipt", func(_ *Endpoint, client *gethclient.Client) error {
+		var err error
+		receipt, err = client.TransactionReceipt(ctx, txHash)
+		return err
+	})
+	return receipt, err
+}
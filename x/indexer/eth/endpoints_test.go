@@ -0,0 +1,196 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// unreachableEndpoint refuses the connections
+const unreachableEndpoint = "ws://127.0.0.1:1"
+
+func newTestPool(t *testing.T, urls ...string) *EndpointPool {
+	t.Helper()
+	pool, err := NewEndpointPool(urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestEndpointPoolFailover(t *testing.T) {
+	source := NewMemorySource(1)
+	source.SetHead(10)
+	_, url := startTestNode(t, source)
+	pool := newTestPool(t, unreachableEndpoint, url)
+
+	for i := 0; i < 3; i++ {
+		height, err := pool.BlockNumber(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if height != 10 {
+			t.Fatalf("unexpected height %d", height)
+		}
+	}
+
+	// The failed endpoint is ranked last after the first error, so it is only tried once
+	status := pool.Status()
+	if status[0].Errors != 1 || status[0].LastError == nil {
+		t.Errorf("unexpected status of the unreachable endpoint: %+v", status[0])
+	}
+	if status[1].Down || status[1].Errors != 0 || status[1].Head != 10 {
+		t.Errorf("unexpected status of the healthy endpoint: %+v", status[1])
+	}
+	if ranked := pool.ranked(nil); ranked[0].URL != url {
+		t.Errorf("the failed endpoint is still ranked first")
+	}
+}
+
+func TestEndpointPoolRangeErrors(t *testing.T) {
+	source := NewMemorySource(1)
+	for height := uint64(1); height <= 40; height++ {
+		source.AddLogs(counterLog(t, height, 0, byte(height), uint32(height)))
+	}
+	node, url := startTestNode(t, source)
+	node.SetLogsLimit(10)
+	pool := newTestPool(t, url)
+
+	for i := 0; i < EndpointMaxErrors+1; i++ {
+		_, err := pool.FilterLogs(context.Background(), QueryForStoreLogs(bigInt(1), bigInt(40), nil))
+		if !IsRangeError(err) {
+			t.Fatalf("expected a range error, got %v", err)
+		}
+	}
+	if status := pool.Status()[0]; status.Down || status.Errors != 0 {
+		t.Fatalf("the range errors marked the endpoint as failed: %+v", status)
+	}
+
+	// The window is shrunk without backoff until the requests are accepted
+	window := NewAdaptiveWindow(40, 1, 40)
+	start := time.Now()
+	logs, err := FilterStoreLogs(context.Background(), pool, mudhandlers.Filter{}, window, 1, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 40 {
+		t.Fatalf("expected 40 logs, got %d", len(logs))
+	}
+	if window.Size() > 10 {
+		t.Errorf("the window was not shrunk: %d", window.Size())
+	}
+	if elapsed := time.Since(start); elapsed >= MinRetryBackoff {
+		t.Errorf("the range errors were retried with backoff: %s", elapsed)
+	}
+}
+
+func TestIsRangeError(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{errors.New("connection refused"), false},
+		{testRangeError{limit: 10}, true},
+		{errors.New("query returned more than 10000 results"), true},
+		{fmt.Errorf("filterLogs failed: %w", errors.New("Log response size exceeded")), true},
+		{errors.New("eth_getLogs is limited to a 10,000 block range"), true},
+	}
+	for _, c := range cases {
+		if IsRangeError(c.err) != c.expected {
+			t.Errorf("IsRangeError(%v) != %v", c.err, c.expected)
+		}
+	}
+}
+
+func TestEndpointPoolPinsSubscriptions(t *testing.T) {
+	first := NewMemorySource(1)
+	first.SetHead(10)
+	second := NewMemorySource(1)
+	second.SetHead(10)
+	firstNode, firstURL := startTestNode(t, first)
+	secondNode, secondURL := startTestNode(t, second)
+	pool := newTestPool(t, firstURL, secondURL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	heads := make(chan *types.Header, 1)
+	sub, err := pool.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	if firstNode.Calls("eth_subscribe_newHeads") != 1 {
+		t.Fatal("the new heads subscription did not use the first endpoint")
+	}
+
+	// The second endpoint becomes the healthiest one
+	pool.mutex.Lock()
+	pool.endpoints[0].latency = time.Second
+	pool.mutex.Unlock()
+
+	logs := make(chan types.Log, 1)
+	logsSub, err := pool.SubscribeFilterLogs(ctx, QueryForStoreLogsSubscription(nil), logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logsSub.Unsubscribe()
+	if firstNode.Calls("eth_subscribe_logs") != 1 || secondNode.Calls("eth_subscribe_logs") != 0 {
+		t.Error("the logs subscription did not use the endpoint of the new heads subscription")
+	}
+
+	header, err := first.HeaderByNumber(ctx, bigInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.FilterLogs(ctx, QueryForBlockStoreLogs(header.Hash(), nil)); err != nil {
+		t.Fatal(err)
+	}
+	if firstNode.Calls("eth_getLogs") != 1 {
+		t.Error("the logs of the streamed block were not requested from the subscribed endpoint")
+	}
+
+	// The range requests use the healthiest endpoint
+	if _, err := pool.FilterLogs(ctx, QueryForStoreLogs(bigInt(1), bigInt(10), nil)); err != nil {
+		t.Fatal(err)
+	}
+	if secondNode.Calls("eth_getLogs") != 1 {
+		t.Error("the range request did not use the healthiest endpoint")
+	}
+}
+
+func TestEndpointPoolNotFoundAsksTheOtherEndpoints(t *testing.T) {
+	lagging := NewMemorySource(1)
+	lagging.SetHead(5)
+	synced := NewMemorySource(1)
+	synced.SetHead(10)
+	laggingNode, laggingURL := startTestNode(t, lagging)
+	syncedNode, syncedURL := startTestNode(t, synced)
+	pool := newTestPool(t, laggingURL, syncedURL)
+
+	header, err := pool.HeaderByNumber(context.Background(), bigInt(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Number.Uint64() != 8 {
+		t.Fatalf("unexpected header %d", header.Number)
+	}
+	if laggingNode.Calls("eth_getBlockByNumber") != 1 || syncedNode.Calls("eth_getBlockByNumber") != 1 {
+		t.Error("the block was not requested from both endpoints")
+	}
+	if status := pool.Status()[0]; status.Errors != 0 {
+		t.Errorf("the lagging endpoint was marked as failed: %+v", status)
+	}
+
+	// Only missing in every endpoint is reported as not found
+	if _, err := pool.HeaderByNumber(context.Background(), bigInt(11)); err != ethereum.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package eth
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/umbracle/ethgo/abi"
+)
+
+var (
+	testWorld   = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	testTableID = mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, "game", "Counter")
+)
+
+// setRecordLog builds a Store_SetRecord log of the test world
+func setRecordLog(t *testing.T, height uint64, index uint, tableID [32]byte, key [][32]byte, staticData []byte, lengths [32]byte, dynamicData []byte) types.Log {
+	t.Helper()
+	event := mudhelpers.StoreEventsAbi.Events["Store_SetRecord"]
+	encoded, err := event.Inputs.NonIndexed().Pack(key, staticData, lengths, dynamicData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash := common.Hash{}
+	binary.BigEndian.PutUint64(txHash[24:], height*1000+uint64(index))
+	return types.Log{
+		Address:     testWorld,
+		Topics:      []common.Hash{event.ID, tableID},
+		Data:        encoded,
+		BlockNumber: height,
+		TxHash:      txHash,
+		Index:       index,
+	}
+}
+
+func encodeNames(t *testing.T, names []string) []byte {
+	t.Helper()
+	encoded, err := abi.MustNewType("tuple(string[] cols)").Encode(map[string]interface{}{"cols": names})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+// registerTableLog registers game:Counter, a table with a bytes32 key and a uint32 field
+func registerTableLog(t *testing.T, height uint64, index uint, fieldName string) types.Log {
+	t.Helper()
+	return registerResourceLog(t, height, index, testTableID, fieldName)
+}
+
+// registerResourceLog registers a table with a bytes32 key and a uint32 field
+func registerResourceLog(t *testing.T, height uint64, index uint, resourceID [32]byte, fieldName string) types.Log {
+	t.Helper()
+	fieldLayout := make([]byte, 32)
+	fieldLayout[1], fieldLayout[2], fieldLayout[4] = 4, 1, 4
+	keySchema := make([]byte, 32)
+	keySchema[1], keySchema[2], keySchema[4] = 32, 1, byte(mudhelpers.BYTES32)
+	valueSchema := make([]byte, 32)
+	valueSchema[1], valueSchema[2], valueSchema[4] = 4, 1, byte(mudhelpers.UINT32)
+	staticData := append(append(fieldLayout, keySchema...), valueSchema...)
+
+	keyNames := encodeNames(t, []string{"id"})
+	fieldNames := encodeNames(t, []string{fieldName})
+	lengths := mudhelpers.EncodeLengths([]uint64{uint64(len(keyNames)), uint64(len(fieldNames))})
+	tablesID := mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, "store", "Tables")
+	return setRecordLog(t, height, index, tablesID, [][32]byte{resourceID}, staticData, lengths, append(keyNames, fieldNames...))
+}
+
+// counterLog sets the value of a game:Counter row
+func counterLog(t *testing.T, height uint64, index uint, key byte, value uint32) types.Log {
+	t.Helper()
+	return resourceLog(t, height, index, testTableID, key, value)
+}
+
+// resourceLog sets the value of a row of a table registered by registerResourceLog
+func resourceLog(t *testing.T, height uint64, index uint, resourceID [32]byte, key byte, value uint32) types.Log {
+	t.Helper()
+	staticData := make([]byte, 4)
+	binary.BigEndian.PutUint32(staticData, value)
+	return setRecordLog(t, height, index, resourceID, [][32]byte{{31: key}}, staticData, [32]byte{}, []byte{})
+}
+
+func counterTable(db *data.Database) *data.Table {
+	return db.GetTable(testWorld.Hex(), mudhelpers.PaddedTableId(testTableID))
+}
+
+// counterValue returns the field of the row as a string, empty if the row does not exist
+func counterValue(t *testing.T, db *data.Database, key byte) string {
+	t.Helper()
+	fields, err := db.GetRowNoMempool(counterTable(db), hexKey(key))
+	if err != nil {
+		return ""
+	}
+	if len(fields) != 1 {
+		t.Fatalf("expected one field, got %v", fields)
+	}
+	return fields[0].String()
+}
+
+func hexKey(key byte) string {
+	return common.Hash{31: key}.Hex()
+}
+
+func bigInt(value uint64) *big.Int {
+	return new(big.Int).SetUint64(value)
+}
@@ -0,0 +1,139 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Upper bounds, in seconds, of the rpc latency histogram
+var RPCLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type RPCStats struct {
+	Requests uint64
+	Errors   uint64
+	// Cumulative count of requests for each RPCLatencyBuckets entry
+	Buckets []uint64
+	// Sum of the latencies in seconds
+	LatencySum float64
+}
+
+// InstrumentedSource measures the latency and the errors of each request sent to the wrapped source
+type InstrumentedSource struct {
+	LogSource
+	stats map[string]*RPCStats
+	mutex *sync.Mutex
+}
+
+func NewInstrumentedSource(source LogSource) *InstrumentedSource {
+	return &InstrumentedSource{LogSource: source, stats: map[string]*RPCStats{}, mutex: &sync.Mutex{}}
+}
+
+func (s *InstrumentedSource) observe(method string, start time.Time, err error) {
+	latency := time.Since(start).Seconds()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats, ok := s.stats[method]
+	if !ok {
+		stats = &RPCStats{Buckets: make([]uint64, len(RPCLatencyBuckets))}
+		s.stats[method] = stats
+	}
+	stats.Requests++
+	// Missing blocks or receipts are valid answers
+	if err != nil && !errors.Is(err, ethereum.NotFound) {
+		stats.Errors++
+	}
+	stats.LatencySum += latency
+	for i, bound := range RPCLatencyBuckets {
+		if latency <= bound {
+			stats.Buckets[i]++
+		}
+	}
+}
+
+// Stats returns a copy of the stats of each rpc method
+func (s *InstrumentedSource) Stats() map[string]RPCStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := map[string]RPCStats{}
+	for method, stats := range s.stats {
+		v := *stats
+		v.Buckets = make([]uint64, len(stats.Buckets))
+		copy(v.Buckets, stats.Buckets)
+		ret[method] = v
+	}
+	return ret
+}
+
+func (s *InstrumentedSource) BlockNumber(ctx context.Context) (uint64, error) {
+	start := time.Now()
+	height, err := s.LogSource.BlockNumber(ctx)
+	s.observe("eth_blockNumber", start, err)
+	return height, err
+}
+
+func (s *InstrumentedSource) ChainID(ctx context.Context) (*big.Int, error) {
+	start := time.Now()
+	chainID, err := s.LogSource.ChainID(ctx)
+	s.observe("eth_chainId", start, err)
+	return chainID, err
+}
+
+func (s *InstrumentedSource) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	start := time.Now()
+	header, err := s.LogSource.HeaderByNumber(ctx, number)
+	s.observe("eth_getBlockByNumber", start, err)
+	return header, err
+}
+
+func (s *InstrumentedSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	start := time.Now()
+	logs, err := s.LogSource.FilterLogs(ctx, q)
+	s.observe("eth_getLogs", start, err)
+	return logs, err
+}
+
+func (s *InstrumentedSource) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	receipts, ok := s.LogSource.(ReceiptReader)
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	start := time.Now()
+	receipt, err := receipts.TransactionReceipt(ctx, txHash)
+	s.observe("eth_getTransactionReceipt", start, err)
+	return receipt, err
+}
+
+func (s *InstrumentedSource) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	sub, ok := s.LogSource.(Subscriber)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	start := time.Now()
+	subscription, err := sub.SubscribeNewHead(ctx, ch)
+	if !errors.Is(err, rpc.ErrNotificationsUnsupported) {
+		s.observe("eth_subscribe", start, err)
+	}
+	return subscription, err
+}
+
+func (s *InstrumentedSource) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	sub, ok := s.LogSource.(Subscriber)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	start := time.Now()
+	subscription, err := sub.SubscribeFilterLogs(ctx, q, ch)
+	if !errors.Is(err, rpc.ErrNotificationsUnsupported) {
+		s.observe("eth_subscribe", start, err)
+	}
+	return subscription, err
+}
@@ -35,7 +35,7 @@ func OrderLogs(logs []types.Log) []types.Log {
 	return filteredLogs
 }
 
-func QueryForStoreLogs(initBlockHeight *big.Int, endBlockHeight *big.Int) ethereum.FilterQuery {
+func QueryForStoreLogs(initBlockHeight *big.Int, endBlockHeight *big.Int, worlds []common.Address) ethereum.FilterQuery {
 	if initBlockHeight == nil {
 		initBlockHeight = big.NewInt(1)
 	}
@@ -48,10 +48,37 @@ func QueryForStoreLogs(initBlockHeight *big.Int, endBlockHeight *big.Int) ethere
 	return ethereum.FilterQuery{
 		FromBlock: initBlockHeight,
 		ToBlock:   endBlockHeight,
-		Topics: [][]common.Hash{{
-			mudhelpers.GetStoreAbiEventID("StoreSetRecord"),
-			mudhelpers.GetStoreAbiEventID("StoreSetField"),
-			mudhelpers.GetStoreAbiEventID("StoreDeleteRecord"),
-		}},
+		Addresses: worlds,
+		Topics:    [][]common.Hash{StoreLogsTopics()},
+	}
+}
+
+// QueryForBlockStoreLogs returns the store logs of a single block, it fails if the node does not know the block
+func QueryForBlockStoreLogs(blockHash common.Hash, worlds []common.Address) ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		BlockHash: &blockHash,
+		Addresses: worlds,
+		Topics:    [][]common.Hash{StoreLogsTopics()},
+	}
+}
+
+// QueryForStoreLogsSubscription is used with eth_subscribe, that does not support block ranges
+func QueryForStoreLogsSubscription(worlds []common.Address) ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		Addresses: worlds,
+		Topics:    [][]common.Hash{StoreLogsTopics()},
+	}
+}
+
+func StoreLogsTopics() []common.Hash {
+	return []common.Hash{
+		mudhelpers.GetStoreAbiEventID("StoreSetRecord"),
+		mudhelpers.GetStoreAbiEventID("StoreSetField"),
+		mudhelpers.GetStoreAbiEventID("StoreDeleteRecord"),
+		mudhelpers.GetStoreEphemeralRecordEventID(),
+		mudhelpers.GetStoreEventsAbiEventID("Store_SetRecord"),
+		mudhelpers.GetStoreEventsAbiEventID("Store_SpliceStaticData"),
+		mudhelpers.GetStoreEventsAbiEventID("Store_SpliceDynamicData"),
+		mudhelpers.GetStoreEventsAbiEventID("Store_DeleteRecord"),
 	}
 }
@@ -0,0 +1,293 @@
+package eth
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Every header hash that is a multiple of this value is cached, so the chain is not hashed again from the base
+const memorySourceHashInterval = 256
+
+// MemorySource is an in memory chain used for tests and offline replays.
+// The headers are generated from the block heights and the logs use their hashes, so the chain is always consistent.
+type MemorySource struct {
+	// Blocks before Base are not linked to their parents
+	Base uint64
+
+	chainID *big.Int
+	head    uint64
+	logs    map[uint64][]types.Log
+	// Replaced blocks get a new version so their hashes change
+	versions map[uint64]uint64
+	hashes   map[uint64]common.Hash
+	mutex    *sync.Mutex
+}
+
+func NewMemorySource(chainID int64) *MemorySource {
+	return &MemorySource{
+		Base:     0,
+		chainID:  big.NewInt(chainID),
+		head:     0,
+		logs:     map[uint64][]types.Log{},
+		versions: map[uint64]uint64{},
+		hashes:   map[uint64]common.Hash{},
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// NewFileSource serves the logs recorded by a LogRecorder, the chain starts at the first recorded block
+func NewFileSource(path string, chainID int64) (*MemorySource, error) {
+	logs, err := ReadLogs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	source := NewMemorySource(chainID)
+	if len(logs) > 0 {
+		source.Base = logs[0].BlockNumber
+	}
+	source.AddLogs(logs...)
+	return source, nil
+}
+
+// AddLogs appends the logs to their blocks, the head is moved to the highest block
+func (s *MemorySource) AddLogs(logs ...types.Log) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(logs) == 0 {
+		return
+	}
+
+	lowest := logs[0].BlockNumber
+	for _, v := range logs {
+		s.logs[v.BlockNumber] = append(s.logs[v.BlockNumber], v)
+		if v.BlockNumber > s.head {
+			s.head = v.BlockNumber
+		}
+		if v.BlockNumber < lowest {
+			lowest = v.BlockNumber
+		}
+	}
+	// The header blooms changed
+	s.forgetHashes(lowest)
+}
+
+func (s *MemorySource) forgetHashes(height uint64) {
+	for k := range s.hashes {
+		if k >= height {
+			delete(s.hashes, k)
+		}
+	}
+}
+
+// SetHead moves the chain head, the blocks without logs are empty
+func (s *MemorySource) SetHead(height uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.head = height
+}
+
+// Reorg removes the blocks starting at height, the new blocks at those heights will have different hashes
+func (s *MemorySource) Reorg(height uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for k := range s.logs {
+		if k >= height {
+			delete(s.logs, k)
+		}
+	}
+	s.forgetHashes(height)
+	for k := height; k <= s.head; k++ {
+		s.versions[k]++
+	}
+	if height > 0 {
+		s.head = height - 1
+	}
+}
+
+func (s *MemorySource) header(height uint64, parent common.Hash) *types.Header {
+	bloom := types.Bloom{}
+	for _, v := range s.logs[height] {
+		bloom.Add(v.Address.Bytes())
+		for _, topic := range v.Topics {
+			bloom.Add(topic.Bytes())
+		}
+	}
+
+	extra := make([]byte, 8)
+	binary.BigEndian.PutUint64(extra, s.versions[height])
+	return &types.Header{
+		ParentHash: parent,
+		Number:     new(big.Int).SetUint64(height),
+		Time:       height,
+		Difficulty: big.NewInt(0),
+		Bloom:      bloom,
+		Extra:      extra,
+	}
+}
+
+func (s *MemorySource) hash(height uint64) common.Hash {
+	if height < s.Base {
+		return s.header(height, common.Hash{}).Hash()
+	}
+	if hash, ok := s.hashes[height]; ok {
+		return hash
+	}
+
+	// Hash the chain from the closest cached block
+	from := height
+	for from > s.Base {
+		if _, ok := s.hashes[from-1]; ok {
+			break
+		}
+		from--
+	}
+
+	parent := common.Hash{}
+	if from > s.Base {
+		parent = s.hashes[from-1]
+	}
+	for h := from; h <= height; h++ {
+		parent = s.header(h, parent).Hash()
+		if h%memorySourceHashInterval == 0 || h == height {
+			s.hashes[h] = parent
+		}
+	}
+	return parent
+}
+
+func (s *MemorySource) headerByHeight(height uint64) *types.Header {
+	parent := common.Hash{}
+	if height > s.Base {
+		parent = s.hash(height - 1)
+	}
+	return s.header(height, parent)
+}
+
+func (s *MemorySource) BlockNumber(_ context.Context) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.head, nil
+}
+
+func (s *MemorySource) ChainID(_ context.Context) (*big.Int, error) {
+	return new(big.Int).Set(s.chainID), nil
+}
+
+func (s *MemorySource) HeaderByNumber(_ context.Context, number *big.Int) (*types.Header, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	height := s.head
+	if number != nil {
+		if number.Sign() < 0 {
+			// Safe and finalized tags are not supported
+			return nil, fmt.Errorf("unsupported block tag %s", number)
+		}
+		height = number.Uint64()
+	}
+	if height > s.head {
+		return nil, ethereum.NotFound
+	}
+	return s.headerByHeight(height), nil
+}
+
+func (s *MemorySource) FilterLogs(_ context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if q.BlockHash != nil {
+		return s.blockLogs(*q.BlockHash, q), nil
+	}
+
+	from := uint64(0)
+	if q.FromBlock != nil {
+		from = q.FromBlock.Uint64()
+	}
+	to := s.head
+	if q.ToBlock != nil && q.ToBlock.Uint64() < to {
+		to = q.ToBlock.Uint64()
+	}
+
+	heights := []uint64{}
+	for height := range s.logs {
+		if height >= from && height <= to {
+			heights = append(heights, height)
+		}
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	ret := []types.Log{}
+	for _, height := range heights {
+		blockHash := s.hash(height)
+		for _, v := range s.logs[height] {
+			if !matchesQuery(v, q) {
+				continue
+			}
+			v.BlockHash = blockHash
+			ret = append(ret, v)
+		}
+	}
+	return ret, nil
+}
+
+// blockLogs must be called with the lock, only the blocks with logs are compared so an unknown hash returns no logs
+func (s *MemorySource) blockLogs(blockHash common.Hash, q ethereum.FilterQuery) []types.Log {
+	ret := []types.Log{}
+	for height, logs := range s.logs {
+		if height > s.head || s.hash(height) != blockHash {
+			continue
+		}
+		for _, v := range logs {
+			if matchesQuery(v, q) {
+				v.BlockHash = blockHash
+				ret = append(ret, v)
+			}
+		}
+		break
+	}
+	return ret
+}
+
+func matchesQuery(log types.Log, q ethereum.FilterQuery) bool {
+	if len(q.Addresses) > 0 {
+		found := false
+		for _, address := range q.Addresses {
+			if address == log.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for i, options := range q.Topics {
+		if len(options) == 0 {
+			continue
+		}
+		if i >= len(log.Topics) {
+			return false
+		}
+		found := false
+		for _, topic := range options {
+			if topic == log.Topics[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package eth
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+	"github.com/ethereum/go-ethereum"
+)
+
+func TestMemorySourceChain(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemorySource(1)
+	source.AddLogs(counterLog(t, 3, 0, 1, 10), counterLog(t, 5, 0, 1, 20))
+	source.SetHead(8)
+
+	// The headers are linked to their parents
+	for height := uint64(1); height <= 8; height++ {
+		header, err := source.HeaderByNumber(ctx, bigInt(height))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent, err := source.HeaderByNumber(ctx, bigInt(height-1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.ParentHash != parent.Hash() {
+			t.Fatalf("the block %d is not linked to its parent", height)
+		}
+	}
+	if _, err := source.HeaderByNumber(ctx, bigInt(9)); err != ethereum.NotFound {
+		t.Fatalf("expected NotFound for a block after the head, got %v", err)
+	}
+
+	logs, err := source.FilterLogs(ctx, QueryForStoreLogs(bigInt(4), bigInt(8), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].BlockNumber != 5 {
+		t.Fatalf("unexpected logs %v", logs)
+	}
+	header, _ := source.HeaderByNumber(ctx, bigInt(5))
+	if logs[0].BlockHash != header.Hash() {
+		t.Fatal("the log does not use the hash of its block")
+	}
+
+	// The replaced blocks get new hashes, the previous ones are kept
+	old3, _ := source.HeaderByNumber(ctx, bigInt(3))
+	old5, _ := source.HeaderByNumber(ctx, bigInt(5))
+	source.Reorg(4)
+	source.SetHead(8)
+	new3, _ := source.HeaderByNumber(ctx, bigInt(3))
+	new5, _ := source.HeaderByNumber(ctx, bigInt(5))
+	if new3.Hash() != old3.Hash() {
+		t.Error("a block before the reorg changed its hash")
+	}
+	if new5.Hash() == old5.Hash() {
+		t.Error("a replaced block kept its hash")
+	}
+
+	// The logs of the orphaned block are not served by its hash
+	logs, err = source.FilterLogs(ctx, QueryForBlockStoreLogs(old5.Hash(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 0 {
+		t.Fatalf("the orphaned block still has logs: %v", logs)
+	}
+}
+
+func TestBackfillMatchesProcessBlocks(t *testing.T) {
+	source := NewMemorySource(1)
+	source.AddLogs(registerTableLog(t, 2, 0, "value"))
+	for height := uint64(3); height <= 60; height++ {
+		source.AddLogs(counterLog(t, height, 0, byte(height%7), uint32(height)))
+	}
+	source.SetHead(64)
+
+	sequential := data.NewDatabase()
+	processRange(t, source, sequential, 0, 64)
+
+	concurrent := data.NewDatabase()
+	window := NewAdaptiveWindow(5, 1, 5)
+	progress := []uint64{}
+	next, err := Backfill(context.Background(), source, concurrent, mudhandlers.Filter{}, window, true, 0, 64, 4, func(p BackfillProgress) {
+		progress = append(progress, p.Height)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 65 {
+		t.Fatalf("unexpected next height %d", next)
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] <= progress[i-1] {
+			t.Fatalf("the ranges were not applied in order: %v", progress)
+		}
+	}
+
+	for key := byte(0); key < 7; key++ {
+		if expected, value := counterValue(t, sequential, key), counterValue(t, concurrent, key); value != expected {
+			t.Errorf("the row %d is %q instead of %q", key, value, expected)
+		}
+	}
+	head, _ := source.HeaderByNumber(context.Background(), bigInt(64))
+	if last := concurrent.LastProcessedBlock(); last == nil || last.Height != 64 || last.Hash != head.Hash() {
+		t.Errorf("unexpected last processed block %v", last)
+	}
+}
+
+func TestFileSourceReplaysRecordedLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.jsonl")
+	recorder, err := NewLogRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := NewMemorySource(1)
+	live.AddLogs(registerTableLog(t, 10, 0, "value"), counterLog(t, 11, 0, 1, 10), counterLog(t, 12, 0, 2, 20))
+	recording := NewRecordingSource(live, recorder)
+	db := data.NewDatabase()
+	processRange(t, recording, db, 10, 12)
+
+	// The block 12 is replaced and fetched again, only its last version must be replayed
+	live.Reorg(12)
+	live.AddLogs(counterLog(t, 12, 0, 3, 30))
+	processRange(t, recording, db, 12, 12)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewFileSource(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Base != 10 {
+		t.Fatalf("the chain does not start at the first recorded block: %d", source.Base)
+	}
+	head, _ := source.BlockNumber(context.Background())
+	if head != 12 {
+		t.Fatalf("unexpected head %d", head)
+	}
+
+	replayed := data.NewDatabase()
+	processRange(t, source, replayed, 10, 12)
+	expected := map[byte]string{1: `"value":10`, 2: "", 3: `"value":30`}
+	for key, value := range expected {
+		if replayedValue := counterValue(t, replayed, key); replayedValue != value {
+			t.Errorf("the row %d is %q instead of %q", key, replayedValue, value)
+		}
+	}
+}
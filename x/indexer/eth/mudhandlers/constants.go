@@ -1,3 +1,6 @@
 package mudhandlers
 
+// ChainID is not read by the indexer, the chain id of each chain is kept in its database.
+//
+// Deprecated: Use data.Database.Info().ChainID instead.
 var ChainID = "0000"
@@ -27,3 +27,21 @@ func HandleDeleteRecordEvent(event *mudhelpers.StorecoreStoreDeleteRecord, db *d
 
 	return db.DeleteRow(table, aggregateKey)
 }
+
+func HandleStoreEventsDeleteRecord(event *mudhelpers.StoreEventsDeleteRecord, db *data.Database) data.MudEvent {
+	tableID := mudhelpers.PaddedTableId(event.TableId)
+	logger.LogDebug(
+		fmt.Sprintln(
+			"handling delete record (Store_DeleteRecord) event",
+			zap.String("table_id", tableID),
+		),
+	)
+
+	table := db.GetTable(event.WorldAddress(), tableID)
+
+	aggregateKey := data.AggregateKey(event.KeyTuple)
+
+	logger.LogDebug(fmt.Sprintf("[indexer] deleting element from table (%s) %s, key = %s", table.Metadata.TableID, table.Metadata.TableName, hexutil.Encode(aggregateKey)))
+
+	return db.DeleteRow(table, aggregateKey)
+}
@@ -0,0 +1,51 @@
+package mudhandlers
+
+import (
+	"fmt"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// IsOffchainTable is true for the MUD v2 tables whose records are only emitted as events
+func IsOffchainTable(tableID [32]byte) bool {
+	return mudhelpers.DecodeResourceID(tableID).Type == mudhelpers.ResourceOffchainTable
+}
+
+func HandleStoreEphemeralRecord(event *mudhelpers.StorecoreStoreEphemeralRecord, db *data.Database) {
+	tableID := mudhelpers.PaddedTableId(event.TableId)
+	table := db.GetTable(event.WorldAddress(), tableID)
+	if !hasSchema(table) {
+		logger.LogError(fmt.Sprintf("[indexer] ignoring StoreEphemeralRecord for the unregistered table %s", tableID))
+		return
+	}
+
+	fields := data.BytesToFields(event.Data, *table.Schema.Schema.Value, table.Schema.FieldNames)
+	key := hexutil.Encode(data.AggregateKey(event.Key))
+	logger.LogDebug(fmt.Sprintf("[indexer] ephemeral record (%s) %s, key = %s", tableID, table.Metadata.TableName, key))
+	db.PublishEphemeral(table, key, *fields, false)
+}
+
+func HandleOffchainSetRecord(event *mudhelpers.StoreEventsSetRecord, db *data.Database) {
+	tableID := mudhelpers.PaddedTableId(event.TableId)
+	table := db.GetTable(event.WorldAddress(), tableID)
+	if !hasSchema(table) {
+		logger.LogError(fmt.Sprintf("[indexer] ignoring Store_SetRecord for the unregistered offchain table %s", tableID))
+		return
+	}
+
+	fields := data.RecordToFields(event.StaticData, event.EncodedLengths, event.DynamicData, *table.Schema.Schema.Value, table.Schema.FieldNames)
+	key := hexutil.Encode(data.AggregateKey(event.KeyTuple))
+	logger.LogDebug(fmt.Sprintf("[indexer] offchain record (%s) %s, key = %s", tableID, table.Metadata.TableName, key))
+	db.PublishEphemeral(table, key, *fields, false)
+}
+
+func HandleOffchainDeleteRecord(event *mudhelpers.StoreEventsDeleteRecord, db *data.Database) {
+	tableID := mudhelpers.PaddedTableId(event.TableId)
+	table := db.GetTable(event.WorldAddress(), tableID)
+	key := hexutil.Encode(data.AggregateKey(event.KeyTuple))
+	logger.LogDebug(fmt.Sprintf("[indexer] offchain record deleted (%s) %s, key = %s", tableID, table.Metadata.TableName, key))
+	db.PublishEphemeral(table, key, nil, true)
+}
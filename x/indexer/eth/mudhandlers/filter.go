@@ -0,0 +1,89 @@
+package mudhandlers
+
+import (
+	"strings"
+
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Filter limits the worlds and tables that are indexed, empty lists allow everything
+type Filter struct {
+	Worlds []string
+	// Tables can be either the table name or the namespace and name joined with `__`
+	Tables []string
+}
+
+func (f Filter) AllowsWorld(address string) bool {
+	if len(f.Worlds) == 0 {
+		return true
+	}
+	for _, v := range f.Worlds {
+		if strings.EqualFold(v, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) AllowsTable(tableID [32]byte) bool {
+	if len(f.Tables) == 0 {
+		return true
+	}
+
+	fullName := mudhelpers.TableIdToTableName(mudhelpers.PaddedTableId(tableID))
+	name := fullName[strings.Index(fullName, mudhelpers.CONNECTOR)+len(mudhelpers.CONNECTOR):]
+	for _, v := range f.Tables {
+		if v == fullName || v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsResource is AllowsTable for the MUD v2 resource ids
+func (f Filter) AllowsResource(tableID [32]byte) bool {
+	if len(f.Tables) == 0 {
+		return true
+	}
+
+	resource := mudhelpers.DecodeResourceID(tableID)
+	for _, v := range f.Tables {
+		if v == resource.TableName() || v == resource.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) WorldAddresses() []common.Address {
+	ret := make([]common.Address, 0, len(f.Worlds))
+	for _, v := range f.Worlds {
+		ret = append(ret, common.HexToAddress(v))
+	}
+	return ret
+}
+
+// AllowsRecord validates the modified table, for the schema and metadata tables it is the table being registered
+func (f Filter) AllowsRecord(tableID [32]byte, key [][32]byte) bool {
+	switch mudhelpers.PaddedTableId(tableID) {
+	case mudhelpers.SchemaTableId(), mudhelpers.MetadataTableId():
+		if len(key) == 0 {
+			return true
+		}
+		return f.AllowsTable(key[0])
+	}
+	return f.AllowsTable(tableID)
+}
+
+// AllowsStoreRecord is AllowsRecord for the MUD v2 events, for the store:Tables and store:ResourceIds tables it is the registered resource
+func (f Filter) AllowsStoreRecord(tableID [32]byte, key [][32]byte) bool {
+	switch mudhelpers.PaddedTableId(tableID) {
+	case mudhelpers.StoreTablesId(), mudhelpers.StoreResourceIdsId():
+		if len(key) == 0 {
+			return true
+		}
+		return f.AllowsResource(key[0])
+	}
+	return f.AllowsResource(tableID)
+}
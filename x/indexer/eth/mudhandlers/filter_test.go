@@ -0,0 +1,117 @@
+package mudhandlers
+
+import (
+	"testing"
+
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	testWorld  = "0x00000000000000000000000000000000000000aa"
+	otherWorld = "0x00000000000000000000000000000000000000bb"
+)
+
+// tableID encodes a MUD v1 table id, 16 bytes with the namespace and 16 bytes with the name
+func tableID(namespace string, name string) [32]byte {
+	var ret [32]byte
+	copy(ret[:16], namespace)
+	copy(ret[16:], name)
+	return ret
+}
+
+func TestEmptyFilterAllowsEverything(t *testing.T) {
+	filter := Filter{}
+	if !filter.AllowsWorld(testWorld) || !filter.AllowsTable(tableID("game", "Counter")) {
+		t.Error("the empty filter rejected a v1 table")
+	}
+	if !filter.AllowsResource(mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, "game", "Counter")) {
+		t.Error("the empty filter rejected a v2 table")
+	}
+	if len(filter.WorldAddresses()) != 0 {
+		t.Error("the empty filter limits the logs to some worlds")
+	}
+}
+
+func TestFilterWorlds(t *testing.T) {
+	filter := Filter{Worlds: []string{"0x00000000000000000000000000000000000000AA"}}
+	if !filter.AllowsWorld(testWorld) {
+		t.Error("the world is not case sensitive")
+	}
+	if filter.AllowsWorld(otherWorld) {
+		t.Error("the other world was allowed")
+	}
+	// Only the worlds are filtered
+	if !filter.AllowsTable(tableID("game", "Counter")) || !filter.AllowsResource(mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, "game", "Counter")) {
+		t.Error("the world filter rejected a table")
+	}
+	if addresses := filter.WorldAddresses(); len(addresses) != 1 || addresses[0] != common.HexToAddress(testWorld) {
+		t.Errorf("unexpected addresses %v", addresses)
+	}
+}
+
+func TestFilterTables(t *testing.T) {
+	filter := Filter{Tables: []string{"Counter", "other__Position"}}
+	if !filter.AllowsWorld(otherWorld) {
+		t.Error("the table filter rejected a world")
+	}
+
+	tests := []struct {
+		namespace string
+		name      string
+		allowed   bool
+	}{
+		{"game", "Counter", true},
+		{"other", "Counter", true},
+		{"other", "Position", true},
+		{"game", "Position", false},
+		{"game", "Health", false},
+	}
+	for _, test := range tests {
+		if allowed := filter.AllowsTable(tableID(test.namespace, test.name)); allowed != test.allowed {
+			t.Errorf("the v1 table %s:%s allowed is %t", test.namespace, test.name, allowed)
+		}
+		resource := mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, test.namespace, test.name)
+		if allowed := filter.AllowsResource(resource); allowed != test.allowed {
+			t.Errorf("the v2 table %s:%s allowed is %t", test.namespace, test.name, allowed)
+		}
+	}
+}
+
+func TestFilterSchemaRecords(t *testing.T) {
+	filter := Filter{Tables: []string{"Counter"}}
+	counter := tableID("game", "Counter")
+	health := tableID("game", "Health")
+
+	// The schema and metadata records are filtered by the registered table
+	for _, registry := range []string{mudhelpers.SchemaTableId(), mudhelpers.MetadataTableId()} {
+		id := [32]byte(common.HexToHash(registry))
+		if !filter.AllowsRecord(id, [][32]byte{counter}) {
+			t.Errorf("the registration of the allowed table in %s was rejected", registry)
+		}
+		if filter.AllowsRecord(id, [][32]byte{health}) {
+			t.Errorf("the registration of the filtered table in %s was allowed", registry)
+		}
+		if !filter.AllowsRecord(id, [][32]byte{}) {
+			t.Errorf("the record of %s without key was rejected", registry)
+		}
+	}
+	if filter.AllowsRecord(health, [][32]byte{counter}) {
+		t.Error("the filtered table was allowed by its key")
+	}
+
+	counterResource := mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, "game", "Counter")
+	healthResource := mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, "game", "Health")
+	for _, registry := range []string{mudhelpers.StoreTablesId(), mudhelpers.StoreResourceIdsId()} {
+		id := [32]byte(common.HexToHash(registry))
+		if !filter.AllowsStoreRecord(id, [][32]byte{counterResource}) {
+			t.Errorf("the registration of the allowed table in %s was rejected", registry)
+		}
+		if filter.AllowsStoreRecord(id, [][32]byte{healthResource}) {
+			t.Errorf("the registration of the filtered table in %s was allowed", registry)
+		}
+	}
+	if !filter.AllowsStoreRecord(counterResource, [][32]byte{healthResource}) || filter.AllowsStoreRecord(healthResource, [][32]byte{counterResource}) {
+		t.Error("the records were filtered by their key")
+	}
+}
@@ -29,7 +29,10 @@ func HandleMetadataTableEvent(event *mudhelpers.StorecoreStoreSetRecord, db *dat
 
 	// Since we know the structure of the metadata, we decode it directly into types and handle.
 	tableReadableName := decodedMetadata.DataAt(0).(string)
-	table.Metadata.TableName = tableReadableName
+	// The names are reverted with their block
+	db.UpdateTable(table, func(metadata *data.TableMetadata, _ *data.TableSchema) {
+		metadata.TableName = tableReadableName
+	})
 
 	tableColumnNamesHexString := decodedMetadata.DataAt(1).(string)
 	tableColumnNamesBytes, err := hexutil.Decode(tableColumnNamesHexString)
@@ -52,22 +55,28 @@ func HandleMetadataTableEvent(event *mudhelpers.StorecoreStoreSetRecord, db *dat
 	}
 
 	newTableFieldNames := []string{}
-	for idx, schemaType := range table.Schema.Schema.Value.Flatten() {
-		columnName := strings.ToLower(outStruct.Cols[idx])
-		newTableFieldNames = append(newTableFieldNames, columnName)
-		(*table.Schema.NamedFields)[columnName] = schemaType
+	for idx := range table.Schema.Schema.Value.Flatten() {
+		newTableFieldNames = append(newTableFieldNames, strings.ToLower(outStruct.Cols[idx]))
+	}
 
-		// TODO: just itereate once after getting all the new names
-		for i := range *table.Rows {
-			for j := range (*table.Rows)[i] {
-				if (*table.Rows)[i][j].Key == (*table.Schema.FieldNames)[idx] {
-					(*table.Rows)[i][j].Key = columnName
+	oldFieldNames := *table.Schema.FieldNames
+	db.RewriteRows(table, func(fields []data.Field) []data.Field {
+		for idx, columnName := range newTableFieldNames {
+			for j := range fields {
+				if idx < len(oldFieldNames) && fields[j].Key == oldFieldNames[idx] {
+					fields[j].Key = columnName
 					break
 				}
 			}
 		}
-	}
-	table.Schema.FieldNames = &newTableFieldNames
+		return fields
+	})
+	db.UpdateTable(table, func(_ *data.TableMetadata, schema *data.TableSchema) {
+		for idx, schemaType := range schema.Schema.Value.Flatten() {
+			(*schema.NamedFields)[newTableFieldNames[idx]] = schemaType
+		}
+		schema.FieldNames = &newTableFieldNames
+	})
 
 	// Save it as a row in the metadata table
 	fields := data.BytesToFields(event.Data, *metadata.Schema.Schema.Value, metadata.Schema.FieldNames)
@@ -36,18 +36,67 @@ func ParseStoreDeleteRecord(log types.Log) (*mudhelpers.StorecoreStoreDeleteReco
 	return event, nil
 }
 
+func ParseStoreEphemeralRecord(log types.Log) (*mudhelpers.StorecoreStoreEphemeralRecord, error) {
+	event := new(mudhelpers.StorecoreStoreEphemeralRecord)
+	if err := UnpackLogWithAbi(mudhelpers.StoreEphemeralAbi, event, "StoreEphemeralRecord", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
+
+func ParseStoreEventsSetRecord(log types.Log) (*mudhelpers.StoreEventsSetRecord, error) {
+	event := new(mudhelpers.StoreEventsSetRecord)
+	if err := UnpackLogWithAbi(mudhelpers.StoreEventsAbi, event, "Store_SetRecord", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
+
+func ParseStoreEventsSpliceStaticData(log types.Log) (*mudhelpers.StoreEventsSpliceStaticData, error) {
+	event := new(mudhelpers.StoreEventsSpliceStaticData)
+	if err := UnpackLogWithAbi(mudhelpers.StoreEventsAbi, event, "Store_SpliceStaticData", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
+
+func ParseStoreEventsSpliceDynamicData(log types.Log) (*mudhelpers.StoreEventsSpliceDynamicData, error) {
+	event := new(mudhelpers.StoreEventsSpliceDynamicData)
+	if err := UnpackLogWithAbi(mudhelpers.StoreEventsAbi, event, "Store_SpliceDynamicData", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
+
+func ParseStoreEventsDeleteRecord(log types.Log) (*mudhelpers.StoreEventsDeleteRecord, error) {
+	event := new(mudhelpers.StoreEventsDeleteRecord)
+	if err := UnpackLogWithAbi(mudhelpers.StoreEventsAbi, event, "Store_DeleteRecord", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
+
 func UnpackLog(out interface{}, eventName string, log types.Log) error {
-	if log.Topics[0] != mudhelpers.StorecoreAbi.Events[eventName].ID {
+	return UnpackLogWithAbi(mudhelpers.StorecoreAbi, out, eventName, log)
+}
+
+func UnpackLogWithAbi(contractAbi abi.ABI, out interface{}, eventName string, log types.Log) error {
+	if log.Topics[0] != contractAbi.Events[eventName].ID {
 		return fmt.Errorf("event signature mismatch")
 	}
 	if len(log.Data) > 0 {
-		if err := mudhelpers.StorecoreAbi.UnpackIntoInterface(out, eventName, log.Data); err != nil {
+		if err := contractAbi.UnpackIntoInterface(out, eventName, log.Data); err != nil {
 			logger.LogError(fmt.Sprintf("failed to unpack into interface %s", err))
 			return err
 		}
 	}
 	var indexed abi.Arguments
-	for _, arg := range mudhelpers.StorecoreAbi.Events[eventName].Inputs {
+	for _, arg := range contractAbi.Events[eventName].Inputs {
 		if arg.Indexed {
 			indexed = append(indexed, arg)
 		}
@@ -30,23 +30,27 @@ func HandleSchemaTableEvent(event *mudhelpers.StorecoreStoreSetRecord, database
 
 	// Merge the two schemas into one, since the table schema is a combination of the key schema and the value schema.
 	storeCoreSchemaTypeKV := mudhelpers.SchemaTypeKVFromPairs(keyStoreCoreSchemaTypePair, valueStoreCoreSchemaTypePair)
-	table.Schema.Schema = mudhelpers.SchemaTypeKVFromPairs(keyStoreCoreSchemaTypePair, valueStoreCoreSchemaTypePair)
 
-	fieldNames := *table.Schema.FieldNames
-	keyNames := *table.Schema.KeyNames
-	for idx := range storeCoreSchemaTypeKV.Value.Flatten() {
-		columnName := mudhelpers.DefaultFieldName(idx)
-		fieldNames = append(fieldNames, columnName)
-	}
+	// The registration is reverted with its block
+	database.UpdateTable(table, func(metadata *data.TableMetadata, schema *data.TableSchema) {
+		schema.Schema = mudhelpers.SchemaTypeKVFromPairs(keyStoreCoreSchemaTypePair, valueStoreCoreSchemaTypePair)
 
-	for idx := range storeCoreSchemaTypeKV.Key.Flatten() {
-		columnName := mudhelpers.DefaultKeyName(idx)
-		keyNames = append(keyNames, columnName)
-	}
+		fieldNames := *schema.FieldNames
+		keyNames := *schema.KeyNames
+		for idx := range storeCoreSchemaTypeKV.Value.Flatten() {
+			columnName := mudhelpers.DefaultFieldName(idx)
+			fieldNames = append(fieldNames, columnName)
+		}
 
-	table.Schema.FieldNames = &fieldNames
-	table.Schema.KeyNames = &keyNames
+		for idx := range storeCoreSchemaTypeKV.Key.Flatten() {
+			columnName := mudhelpers.DefaultKeyName(idx)
+			keyNames = append(keyNames, columnName)
+		}
 
-	// NOTE: we are overwritting every time this is called, but it is only called once so it is not a problem
-	table.Metadata.TableName = "schema"
+		schema.FieldNames = &fieldNames
+		schema.KeyNames = &keyNames
+
+		// NOTE: we are overwritting every time this is called, but it is only called once so it is not a problem
+		metadata.TableName = "schema"
+	})
 }
@@ -42,3 +42,36 @@ func HandleGenericTableEvent(event *mudhelpers.StorecoreStoreSetRecord, db *data
 	// decodedKeyData := mudhelpers.DecodeData(aggregateKey, *table.Schema.Schema.Key)
 	// decodedKeyDataNew := data.BytesToFields(aggregateKey, *table.Schema.Schema.Key, table.Schema.KeyNames)
 }
+
+// hasSchema is false for the tables that were not registered yet
+func hasSchema(table *data.Table) bool {
+	return table.Schema.Schema != nil && table.Schema.Schema.Value != nil
+}
+
+func HandleStoreEventsSetRecord(event *mudhelpers.StoreEventsSetRecord, db *data.Database) data.MudEvent {
+	tableID := mudhelpers.PaddedTableId(event.TableId)
+	logger.LogDebug(
+		fmt.Sprintln(
+			"handling set record (Store_SetRecord) event",
+			zap.String("world_address", event.WorldAddress()),
+			zap.String("table_id", tableID),
+		),
+	)
+
+	table := db.GetTable(event.WorldAddress(), tableID)
+	if !hasSchema(table) {
+		logger.LogError(fmt.Sprintf("[indexer] ignoring Store_SetRecord for the unregistered table %s", tableID))
+		return data.MudEvent{}
+	}
+
+	fields := data.RecordToFields(event.StaticData, event.EncodedLengths, event.DynamicData, *table.Schema.Schema.Value, table.Schema.FieldNames)
+	aggregateKey := data.AggregateKey(event.KeyTuple)
+
+	a := ""
+	for _, v := range *fields {
+		a = fmt.Sprintf("%s. %s (%s)", a, v.String(), v.Type())
+	}
+	logger.LogDebug(fmt.Sprintf("[indexer] set record event (%s) %s, key = %s, fields = %s", table.Metadata.TableID, table.Metadata.TableName, hexutil.Encode(aggregateKey), a))
+
+	return db.AddRow(table, aggregateKey, fields)
+}
@@ -0,0 +1,59 @@
+package mudhandlers
+
+import (
+	"fmt"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"go.uber.org/zap"
+)
+
+func HandleSpliceStaticDataEvent(event *mudhelpers.StoreEventsSpliceStaticData, db *data.Database) data.MudEvent {
+	tableID := mudhelpers.PaddedTableId(event.TableId)
+	logger.LogDebug(
+		fmt.Sprintln(
+			"handling splice static data (Store_SpliceStaticData) event",
+			zap.String("table_id", tableID),
+		),
+	)
+
+	table := db.GetTable(event.WorldAddress(), tableID)
+	if !hasSchema(table) {
+		logger.LogError(fmt.Sprintf("[indexer] ignoring Store_SpliceStaticData for the unregistered table %s", tableID))
+		return data.MudEvent{}
+	}
+
+	key := data.AggregateKey(event.KeyTuple)
+	mudevent := db.SpliceStaticData(table, key, event)
+
+	logger.LogDebug(fmt.Sprintf("[indexer] splice static data event (%s) %s, key = %s, start = %d, data = %s", table.Metadata.TableID, table.Metadata.TableName, hexutil.Encode(key), event.Start, hexutil.Encode(event.Data)))
+	return mudevent
+}
+
+func HandleSpliceDynamicDataEvent(event *mudhelpers.StoreEventsSpliceDynamicData, db *data.Database) data.MudEvent {
+	tableID := mudhelpers.PaddedTableId(event.TableId)
+	logger.LogDebug(
+		fmt.Sprintln(
+			"handling splice dynamic data (Store_SpliceDynamicData) event",
+			zap.String("table_id", tableID),
+		),
+	)
+
+	table := db.GetTable(event.WorldAddress(), tableID)
+	if !hasSchema(table) {
+		logger.LogError(fmt.Sprintf("[indexer] ignoring Store_SpliceDynamicData for the unregistered table %s", tableID))
+		return data.MudEvent{}
+	}
+	if int(event.DynamicFieldIndex) >= len(table.Schema.Schema.Value.Dynamic) {
+		logger.LogError(fmt.Sprintf("[indexer] invalid dynamic field index %d for table %s", event.DynamicFieldIndex, tableID))
+		return data.MudEvent{}
+	}
+
+	key := data.AggregateKey(event.KeyTuple)
+	mudevent := db.SpliceDynamicData(table, key, event)
+
+	logger.LogDebug(fmt.Sprintf("[indexer] splice dynamic data event (%s) %s, key = %s, field = %d, start = %d, delete = %d, data = %s", table.Metadata.TableID, table.Metadata.TableName, hexutil.Encode(key), event.DynamicFieldIndex, event.Start, event.DeleteCount, hexutil.Encode(event.Data)))
+	return mudevent
+}
@@ -0,0 +1,152 @@
+package mudhandlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/umbracle/ethgo/abi"
+	"go.uber.org/zap"
+)
+
+// storeTables returns the store:Tables table, its schema is set before it registers itself
+func storeTables(db *data.Database, world *data.World) *data.Table {
+	table := world.GetTable(mudhelpers.StoreTablesId())
+	if !hasSchema(table) {
+		db.UpdateTable(table, func(metadata *data.TableMetadata, schema *data.TableSchema) {
+			fieldNames := mudhelpers.StoreTablesFieldNames()
+			keyNames := []string{"tableid"}
+			schema.Schema = mudhelpers.StoreTablesSchema()
+			schema.FieldNames = &fieldNames
+			schema.KeyNames = &keyNames
+			for idx, schemaType := range schema.Schema.Value.Flatten() {
+				(*schema.NamedFields)[fieldNames[idx]] = schemaType
+			}
+			metadata.TableName = "Tables"
+			metadata.OnChainTableName = mudhelpers.ResourceIdToTableName(mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, "store", "Tables"))
+			metadata.ResourceType = mudhelpers.ResourceTable
+		})
+	}
+	return table
+}
+
+func decodeNames(encoded []byte) ([]string, error) {
+	if len(encoded) == 0 {
+		return []string{}, nil
+	}
+	// Same as the metadata column names, string[] must be decoded as a tuple
+	_type := abi.MustNewType("tuple(string[] cols)")
+	outStruct := struct {
+		Cols []string
+	}{
+		Cols: []string{},
+	}
+	if err := _type.DecodeStruct(encoded, &outStruct); err != nil {
+		return nil, err
+	}
+	return outStruct.Cols, nil
+}
+
+// HandleStoreTablesEvent registers the schema, the name and the column names of a MUD v2 table
+func HandleStoreTablesEvent(event *mudhelpers.StoreEventsSetRecord, db *data.Database) data.MudEvent {
+	world := db.GetWorld(event.WorldAddress())
+	tables := storeTables(db, world)
+	if len(event.KeyTuple) == 0 {
+		logger.LogError("[indexer] ignoring store:Tables record without key")
+		return data.MudEvent{}
+	}
+
+	resourceID := event.KeyTuple[0]
+	tableID := mudhelpers.PaddedTableId(resourceID)
+	resource := mudhelpers.DecodeResourceID(resourceID)
+	if !resource.IsTable() {
+		logger.LogError(fmt.Sprintf("[indexer] ignoring store:Tables record of resource %s, it is not a table", resource.String()))
+		return data.MudEvent{}
+	}
+	logger.LogDebug(
+		fmt.Sprintln(
+			"handling store tables event",
+			zap.String("world_address", event.WorldAddress()),
+			zap.String("table_id", tableID),
+			zap.String("resource", resource.String()),
+		),
+	)
+
+	// Keep the registry as a regular table
+	fields := data.RecordToFields(event.StaticData, event.EncodedLengths, event.DynamicData, *tables.Schema.Schema.Value, tables.Schema.FieldNames)
+	mudEvent := db.AddRow(tables, resourceID[:], fields)
+
+	staticData := make([]byte, 96)
+	copy(staticData, event.StaticData)
+	fieldLayout := mudhelpers.DecodeFieldLayout(staticData[0:32])
+	keySchema := mudhelpers.DecodeSchemaTypePair(staticData[32:64])
+	valueSchema := mudhelpers.DecodeSchemaTypePair(staticData[64:96])
+	if !fieldLayout.Matches(valueSchema) {
+		logger.LogError(fmt.Sprintf("[indexer] the field layout of table %s does not match its value schema", resource.String()))
+	}
+
+	_, lengths := mudhelpers.DecodeEncodedLengths(event.EncodedLengths, 2)
+	if lengths[0]+lengths[1] > uint64(len(event.DynamicData)) {
+		logger.LogError(fmt.Sprintf("[indexer] the names of table %s are missing, using the default ones", resource.String()))
+		lengths = []uint64{0, 0}
+	}
+	keyNames, err := decodeNames(event.DynamicData[:lengths[0]])
+	if err != nil {
+		logger.LogError(fmt.Sprintf("[indexer] error decoding the key names of table %s: %s", resource.String(), err))
+		keyNames = []string{}
+	}
+	fieldNames, err := decodeNames(event.DynamicData[lengths[0] : lengths[0]+lengths[1]])
+	if err != nil {
+		logger.LogError(fmt.Sprintf("[indexer] error decoding the field names of table %s: %s", resource.String(), err))
+		fieldNames = []string{}
+	}
+
+	table := world.GetTable(tableID)
+
+	newKeyNames := []string{}
+	for idx := range keySchema.Flatten() {
+		name := mudhelpers.DefaultKeyName(idx)
+		if idx < len(keyNames) {
+			name = strings.ToLower(keyNames[idx])
+		}
+		newKeyNames = append(newKeyNames, name)
+	}
+
+	newFieldNames := []string{}
+	namedFields := map[string]mudhelpers.SchemaType{}
+	for idx, schemaType := range valueSchema.Flatten() {
+		name := mudhelpers.DefaultFieldName(idx)
+		if idx < len(fieldNames) {
+			name = strings.ToLower(fieldNames[idx])
+		}
+		newFieldNames = append(newFieldNames, name)
+		namedFields[name] = schemaType
+	}
+
+	// Rows written before the registration use the default names
+	db.RewriteRows(table, func(fields []data.Field) []data.Field {
+		for j := range fields {
+			if j < len(newFieldNames) {
+				fields[j].Key = newFieldNames[j]
+			}
+		}
+		return fields
+	})
+
+	// The registration is reverted with its block
+	db.UpdateTable(table, func(metadata *data.TableMetadata, schema *data.TableSchema) {
+		schema.Schema = mudhelpers.SchemaTypeKVFromPairs(keySchema, valueSchema)
+		schema.KeyNames = &newKeyNames
+		schema.FieldNames = &newFieldNames
+		schema.NamedFields = &namedFields
+		metadata.TableName = resource.Name
+		metadata.OnChainTableName = resource.TableName()
+		metadata.ResourceType = resource.Type
+	})
+
+	logger.LogInfo(fmt.Sprintf("[indexer] registered table %s (%s) with fields %v", resource.String(), hexutil.Encode(resourceID[:]), newFieldNames))
+	return mudEvent
+}
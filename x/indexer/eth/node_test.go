@@ -0,0 +1,172 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testNode serves a MemorySource with the eth json rpc methods used by the indexer
+type testNode struct {
+	source *MemorySource
+	// Maximum amount of blocks of an eth_getLogs request, zero allows any range
+	logsLimit  uint64
+	calls      map[string]int
+	heads      []*rpc.Notifier
+	headsIDs   []rpc.ID
+	subscribed chan struct{}
+	mutex      *sync.Mutex
+}
+
+type testFilter struct {
+	BlockHash *common.Hash     `json:"blockHash"`
+	FromBlock *hexutil.Big     `json:"fromBlock"`
+	ToBlock   *hexutil.Big     `json:"toBlock"`
+	Addresses []common.Address `json:"address"`
+	Topics    [][]common.Hash  `json:"topics"`
+}
+
+// startTestNode serves the source over http and websockets, the server is closed with the test
+func startTestNode(t *testing.T, source *MemorySource) (*testNode, string) {
+	t.Helper()
+	node := &testNode{source: source, calls: map[string]int{}, subscribed: make(chan struct{}, 16), mutex: &sync.Mutex{}}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &testNodeService{node: node}); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server.WebsocketHandler([]string{"*"}))
+	t.Cleanup(func() {
+		httpServer.Close()
+		server.Stop()
+	})
+	return node, "ws://" + strings.TrimPrefix(httpServer.URL, "http://")
+}
+
+func (n *testNode) call(method string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.calls[method]++
+}
+
+func (n *testNode) Calls(method string) int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.calls[method]
+}
+
+func (n *testNode) SetLogsLimit(limit uint64) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.logsLimit = limit
+}
+
+// testRangeError is the error sent by the providers when the eth_getLogs range is too large
+type testRangeError struct {
+	limit uint64
+}
+
+func (e testRangeError) Error() string {
+	return fmt.Sprintf("query exceeds the max block range of %d", e.limit)
+}
+
+func (e testRangeError) ErrorCode() int {
+	return -32005
+}
+
+// PublishHead sends the header of the block to the new heads subscribers
+func (n *testNode) PublishHead(t *testing.T, height uint64) *types.Header {
+	t.Helper()
+	header, err := n.source.HeaderByNumber(context.Background(), new(big.Int).SetUint64(height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for i, notifier := range n.heads {
+		if err := notifier.Notify(n.headsIDs[i], header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return header
+}
+
+type testNodeService struct {
+	node *testNode
+}
+
+func (s *testNodeService) ChainId(ctx context.Context) (*hexutil.Big, error) {
+	s.node.call("eth_chainId")
+	id, err := s.node.source.ChainID(ctx)
+	return (*hexutil.Big)(id), err
+}
+
+func (s *testNodeService) BlockNumber(ctx context.Context) (hexutil.Uint64, error) {
+	s.node.call("eth_blockNumber")
+	height, err := s.node.source.BlockNumber(ctx)
+	return hexutil.Uint64(height), err
+}
+
+func (s *testNodeService) GetBlockByNumber(ctx context.Context, number rpc.BlockNumber, _ bool) (*types.Header, error) {
+	s.node.call("eth_getBlockByNumber")
+	var height *big.Int
+	if number >= 0 {
+		height = big.NewInt(number.Int64())
+	}
+	header, err := s.node.source.HeaderByNumber(ctx, height)
+	if err == ethereum.NotFound {
+		return nil, nil
+	}
+	return header, err
+}
+
+func (s *testNodeService) GetLogs(ctx context.Context, filter testFilter) ([]types.Log, error) {
+	s.node.call("eth_getLogs")
+	s.node.mutex.Lock()
+	limit := s.node.logsLimit
+	s.node.mutex.Unlock()
+
+	q := ethereum.FilterQuery{BlockHash: filter.BlockHash, Addresses: filter.Addresses, Topics: filter.Topics}
+	if filter.FromBlock != nil && filter.ToBlock != nil {
+		q.FromBlock = filter.FromBlock.ToInt()
+		q.ToBlock = filter.ToBlock.ToInt()
+		if limit > 0 && q.ToBlock.Uint64()-q.FromBlock.Uint64()+1 > limit {
+			return nil, testRangeError{limit: limit}
+		}
+	}
+	return s.node.source.FilterLogs(ctx, q)
+}
+
+// Logs is accepted but no log is sent, the streamed logs are requested by block hash
+func (s *testNodeService) Logs(ctx context.Context, _ map[string]interface{}) (*rpc.Subscription, error) {
+	s.node.call("eth_subscribe_logs")
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	return notifier.CreateSubscription(), nil
+}
+
+func (s *testNodeService) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	s.node.call("eth_subscribe_newHeads")
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	subscription := notifier.CreateSubscription()
+	s.node.mutex.Lock()
+	s.node.heads = append(s.node.heads, notifier)
+	s.node.headsIDs = append(s.node.headsIDs, subscription.ID)
+	s.node.mutex.Unlock()
+	s.node.subscribed <- struct{}{}
+	return subscription, nil
+}
@@ -0,0 +1,66 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type ReceiptReader interface {
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// PendingPolicy limits how long the predicted events of the sent transactions are used, zero values disable each check
+type PendingPolicy struct {
+	MaxBlocks uint64
+	MaxAge    time.Duration
+	// Discard the reverted transactions without waiting for them to expire
+	CheckReceipts bool
+	// Minimum time between the receipt requests of the same transaction
+	ReceiptInterval time.Duration
+}
+
+// CheckPendingTransactions expires the old transactions and discards the ones that reverted or were mined without store events.
+// The processed height is the last block applied to the database.
+func CheckPendingTransactions(ctx context.Context, receipts ReceiptReader, db *data.Database, policy PendingPolicy, head uint64, processedHeight uint64) error {
+	db.ExpireUnconfirmedTransactions(head, policy.MaxBlocks, policy.MaxAge)
+
+	if !policy.CheckReceipts || receipts == nil {
+		return nil
+	}
+
+	now := time.Now()
+	for _, tx := range db.PendingTransactions() {
+		if policy.ReceiptInterval > 0 && now.Sub(tx.ReceiptCheckedAt) < policy.ReceiptInterval {
+			continue
+		}
+		db.SetReceiptChecked(tx.Txhash, now)
+
+		receipt, err := receipts.TransactionReceipt(ctx, common.HexToHash(tx.Txhash))
+		if errors.Is(err, ethereum.NotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error getting the receipt of tx %s: %w", tx.Txhash, err)
+		}
+
+		if receipt.Status == types.ReceiptStatusFailed {
+			db.DiscardUnconfirmedTransaction(tx.Txhash, data.TxReverted)
+			continue
+		}
+
+		// The logs of the block were already applied, so the transaction did not modify the indexed tables
+		if receipt.BlockNumber != nil && receipt.BlockNumber.Uint64() <= processedHeight {
+			logger.LogInfo(fmt.Sprintf("[indexer] tx %s was mined without store events at block %d", tx.Txhash, receipt.BlockNumber))
+			db.TakeUnconfirmedTransaction(tx.Txhash)
+		}
+	}
+	return nil
+}
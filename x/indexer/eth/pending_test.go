@@ -0,0 +1,101 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// testReceipts returns the stored receipts and NotFound for the rest
+type testReceipts struct {
+	receipts map[common.Hash]*types.Receipt
+	calls    map[common.Hash]int
+	mutex    *sync.Mutex
+}
+
+func newTestReceipts() *testReceipts {
+	return &testReceipts{receipts: map[common.Hash]*types.Receipt{}, calls: map[common.Hash]int{}, mutex: &sync.Mutex{}}
+}
+
+func (r *testReceipts) TransactionReceipt(_ context.Context, txHash common.Hash) (*types.Receipt, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls[txHash]++
+	receipt, ok := r.receipts[txHash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+func (r *testReceipts) Calls(txHash common.Hash) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.calls[txHash]
+}
+
+func TestCheckPendingTransactionsReceiptInterval(t *testing.T) {
+	db := data.NewDatabase()
+	receipts := newTestReceipts()
+	pending := common.HexToHash("0x01")
+	reverted := common.HexToHash("0x02")
+	receipts.receipts[reverted] = &types.Receipt{Status: types.ReceiptStatusFailed, BlockNumber: big.NewInt(3)}
+	db.AddTxSent(data.UnconfirmedTransaction{Txhash: pending.Hex(), SentHeight: 1})
+	db.AddTxSent(data.UnconfirmedTransaction{Txhash: reverted.Hex(), SentHeight: 1})
+
+	policy := PendingPolicy{CheckReceipts: true, ReceiptInterval: time.Hour}
+	for head := uint64(2); head < 10; head++ {
+		if err := CheckPendingTransactions(context.Background(), receipts, db, policy, head, head); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := receipts.Calls(pending); calls != 1 {
+		t.Errorf("the receipt of the pending tx was requested %d times", calls)
+	}
+	if calls := receipts.Calls(reverted); calls != 1 {
+		t.Errorf("the receipt of the reverted tx was requested %d times", calls)
+	}
+	if txs := db.PendingTransactions(); len(txs) != 1 || txs[0].Txhash != pending.Hex() {
+		t.Fatalf("unexpected pending transactions %v", txs)
+	}
+
+	// Without an interval the receipt is requested every time
+	policy.ReceiptInterval = 0
+	for i := 0; i < 3; i++ {
+		if err := CheckPendingTransactions(context.Background(), receipts, db, policy, 10, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := receipts.Calls(pending); calls != 4 {
+		t.Errorf("the receipt of the pending tx was requested %d times", calls)
+	}
+}
+
+func TestCheckPendingTransactionsExpiresUnknownSentHeight(t *testing.T) {
+	db := data.NewDatabase()
+	// Sent before the first block was synced
+	db.AddTxSent(data.UnconfirmedTransaction{Txhash: common.HexToHash("0x01").Hex()})
+	policy := PendingPolicy{MaxBlocks: 5}
+
+	for head := uint64(100); head <= 105; head++ {
+		if err := CheckPendingTransactions(context.Background(), nil, db, policy, head, head); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.PendingTransactions()) != 1 {
+			t.Fatalf("the tx expired at block %d", head)
+		}
+	}
+	if err := CheckPendingTransactions(context.Background(), nil, db, policy, 106, 106); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.PendingTransactions()) != 0 {
+		t.Fatal("the tx did not expire")
+	}
+}
@@ -1,54 +1,224 @@
 package eth
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 
-	"github.com/bocha-io/ethclient/x/ethclient"
 	"github.com/bocha-io/garnet/x/indexer/data"
 	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
 	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
 	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/core/types"
 )
 
-type UnconfirmedTransaction struct {
-	Txhash string
-	Events *[]data.MudEvent
-}
+// ProcessBlocks applies the logs of the range, fetchTimestamps requests the header of every block with logs to set the rows provenance time
+func ProcessBlocks(ctx context.Context, source LogSource, db *data.Database, filter mudhandlers.Filter, window *AdaptiveWindow, fetchTimestamps bool, initBlockHeight *big.Int, endBlockHeight *big.Int) error {
+	// Get the end block before the logs so the logs are never newer than the tracked hash
+	endHeader, err := source.HeaderByNumber(ctx, endBlockHeight)
+	if err != nil {
+		return fmt.Errorf("error getting the header for block %d: %w", endBlockHeight, err)
+	}
 
-func ProcessBlocks(c *ethclient.EthClient, db *data.Database, initBlockHeight *big.Int, endBlockHeight *big.Int) {
-	logs := c.FilterLogs(QueryForStoreLogs(initBlockHeight, endBlockHeight))
+	logs, err := FilterStoreLogs(ctx, source, filter, window, initBlockHeight.Uint64(), endBlockHeight.Uint64())
+	if err != nil {
+		return err
+	}
 	logs = OrderLogs(logs)
+	timestamps, err := BlockTimestamps(ctx, source, logs, fetchTimestamps, endHeader)
+	if err != nil {
+		return err
+	}
 	logger.LogInfo(fmt.Sprintf("[indexer] processing logs up to %d", endBlockHeight))
 
-	processedTxns := map[string]*UnconfirmedTransaction{}
+	ApplyLogs(db, filter, setTimestamps(DecodeLogs(logs), timestamps))
 
-	for _, v := range logs {
-		found := false
-		if _, ok := processedTxns[v.TxHash.Hex()]; ok {
-			found = true
+	db.StartBlock(endBlockHeight.Uint64(), endHeader.Hash())
+	return nil
+}
+
+// DecodedLog is a log with its store event already unpacked
+type DecodedLog struct {
+	Log types.Log
+	// Event name, empty if the topic is not a store event
+	Name  string
+	Event interface{}
+	Err   error
+	// Block time used as the rows provenance, 0 if unknown
+	Timestamp uint64
+}
+
+// DecodeLog does not use the database, so it can be called concurrently
+func DecodeLog(v types.Log) DecodedLog {
+	ret := DecodedLog{Log: v}
+	switch v.Topics[0].Hex() {
+	case mudhelpers.GetStoreAbiEventID("StoreSetRecord").Hex():
+		ret.Name = "StoreSetRecord"
+		event, err := mudhandlers.ParseStoreSetRecord(v)
+		if err != nil {
+			ret.Err = err
+		} else {
+			ret.Event = event
+		}
+	case mudhelpers.GetStoreAbiEventID("StoreSetField").Hex():
+		ret.Name = "StoreSetField"
+		event, err := mudhandlers.ParseStoreSetField(v)
+		if err != nil {
+			ret.Err = err
+		} else {
+			ret.Event = event
+		}
+	case mudhelpers.GetStoreAbiEventID("StoreDeleteRecord").Hex():
+		ret.Name = "StoreDeleteRecord"
+		event, err := mudhandlers.ParseStoreDeleteRecord(v)
+		if err != nil {
+			ret.Err = err
+		} else {
+			ret.Event = event
+		}
+	case mudhelpers.GetStoreEphemeralRecordEventID().Hex():
+		ret.Name = "StoreEphemeralRecord"
+		event, err := mudhandlers.ParseStoreEphemeralRecord(v)
+		if err != nil {
+			ret.Err = err
+		} else {
+			ret.Event = event
+		}
+	case mudhelpers.GetStoreEventsAbiEventID("Store_SetRecord").Hex():
+		ret.Name = "Store_SetRecord"
+		event, err := mudhandlers.ParseStoreEventsSetRecord(v)
+		if err != nil {
+			ret.Err = err
 		} else {
-			for k, txsent := range db.UnconfirmedTransactions {
-				if v.TxHash.Hex() == txsent.Txhash {
-					logger.LogInfo(fmt.Sprintf("[indexer] procesing tx from mempool with hash %s", txsent))
-					temp := db.UnconfirmedTransactions[k]
-					db.UnconfirmedTransactions = append(db.UnconfirmedTransactions[:k], db.UnconfirmedTransactions[k+1:]...)
-					processedTxns[temp.Txhash] = &UnconfirmedTransaction{Txhash: temp.Txhash, Events: &temp.Events}
-					found = true
-					break
-				}
+			ret.Event = event
+		}
+	case mudhelpers.GetStoreEventsAbiEventID("Store_SpliceStaticData").Hex():
+		ret.Name = "Store_SpliceStaticData"
+		event, err := mudhandlers.ParseStoreEventsSpliceStaticData(v)
+		if err != nil {
+			ret.Err = err
+		} else {
+			ret.Event = event
+		}
+	case mudhelpers.GetStoreEventsAbiEventID("Store_SpliceDynamicData").Hex():
+		ret.Name = "Store_SpliceDynamicData"
+		event, err := mudhandlers.ParseStoreEventsSpliceDynamicData(v)
+		if err != nil {
+			ret.Err = err
+		} else {
+			ret.Event = event
+		}
+	case mudhelpers.GetStoreEventsAbiEventID("Store_DeleteRecord").Hex():
+		ret.Name = "Store_DeleteRecord"
+		event, err := mudhandlers.ParseStoreEventsDeleteRecord(v)
+		if err != nil {
+			ret.Err = err
+		} else {
+			ret.Event = event
+		}
+	default:
+		ret.Err = fmt.Errorf("unknown event %s", v.Topics[0].Hex())
+	}
+	return ret
+}
+
+func DecodeLogs(logs []types.Log) []DecodedLog {
+	ret := make([]DecodedLog, 0, len(logs))
+	for _, v := range logs {
+		ret = append(ret, DecodeLog(v))
+	}
+	return ret
+}
+
+// ProcessLogs applies the already ordered logs to the database, the rows provenance will not have timestamps
+func ProcessLogs(db *data.Database, filter mudhandlers.Filter, logs []types.Log) {
+	ApplyLogs(db, filter, DecodeLogs(logs))
+}
+
+// ApplyLogs updates the database with the already ordered and decoded logs
+func ApplyLogs(db *data.Database, filter mudhandlers.Filter, logs []DecodedLog) {
+	// Transactions sent by the game that must be compared with their logs
+	predictions := map[string]*prediction{}
+	order := []string{}
+
+	// Each block is applied with the write lock, so the readers see it at once
+	inBlock := false
+	height := uint64(0)
+	for _, decoded := range logs {
+		v := decoded.Log
+		if !inBlock || height != v.BlockNumber {
+			if inBlock {
+				db.EndBlock()
 			}
+			db.BeginBlock(v.BlockNumber, v.BlockHash)
+			inBlock = true
+			height = v.BlockNumber
 		}
 
-		var logMudEvent data.MudEvent
+		if !filter.AllowsWorld(v.Address.Hex()) {
+			continue
+		}
 
-		if v.Topics[0].Hex() == mudhelpers.GetStoreAbiEventID("StoreSetRecord").Hex() {
-			event, err := mudhandlers.ParseStoreSetRecord(v)
-			if err != nil {
-				logger.LogError(fmt.Sprintf("[indexer] error decoding message:%s", err))
-				// TODO: what should we do here?
-				break
+		txHash := v.TxHash.Hex()
+		pending, found := predictions[txHash]
+		if !found {
+			// The optimistic overlay is dropped as soon as the transaction is confirmed
+			if tx, ok := db.ConfirmUnconfirmedTransaction(txHash); ok {
+				logger.LogInfo(fmt.Sprintf("[indexer] procesing tx from mempool with hash %s", txHash))
+				pending = &prediction{tx: tx}
+				predictions[txHash] = pending
+				order = append(order, txHash)
+				found = true
 			}
+		}
+
+		if decoded.Err != nil {
+			// Keep processing the batch, the log can be retried later
+			db.CountDecodeError()
+			db.AddDeadLetter(v, decoded.Err)
+			continue
+		}
+
+		provenance := data.NewProvenance(v, decoded.Timestamp)
+		db.SetCurrentLog(&provenance)
+		logMudEvent, err := applyEvent(db, filter, decoded.Event)
+		if err != nil {
+			db.CountApplyError()
+			db.AddDeadLetter(v, err)
+			continue
+		}
+		db.CountLog(decoded.Name)
+
+		if found && logMudEvent.Table != "" {
+			pending.actual = append(pending.actual, logMudEvent)
+		}
+	}
+	if inBlock {
+		db.EndBlock()
+	}
+
+	// The confirmed state is already applied, the predictions are only compared to report the mismatches
+	for _, txHash := range order {
+		db.ReconcilePrediction(predictions[txHash].tx, predictions[txHash].actual)
+	}
+}
+
+type prediction struct {
+	tx     data.UnconfirmedTransaction
+	actual []data.MudEvent
+}
+
+// applyEvent updates the database with a decoded store event, a panic while handling the event is returned as an error
+func applyEvent(db *data.Database, filter mudhandlers.Filter, decodedEvent interface{}) (logMudEvent data.MudEvent, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("error handling the event: %v", r)
+		}
+	}()
+
+	switch event := decodedEvent.(type) {
+	case *mudhelpers.StorecoreStoreSetRecord:
+		if filter.AllowsRecord(event.TableId, event.Key) {
 			switch mudhelpers.PaddedTableId(event.TableId) {
 			case mudhelpers.SchemaTableId():
 				logger.LogInfo("[indexer] processing and creating schema table")
@@ -61,61 +231,77 @@ func ProcessBlocks(c *ethclient.EthClient, db *data.Database, initBlockHeight *b
 				logMudEvent = mudhandlers.HandleGenericTableEvent(event, db)
 			}
 		}
-
-		if v.Topics[0].Hex() == mudhelpers.GetStoreAbiEventID("StoreSetField").Hex() {
-			event, err := mudhandlers.ParseStoreSetField(v)
-			logger.LogInfo("[indexer] processing store set field message")
-			if err != nil {
-				logger.LogError(fmt.Sprintf("[indexer] error decoding message for store set field:%s\n", err))
-			} else {
-				logMudEvent = mudhandlers.HandleSetFieldEvent(event, db)
-			}
+	case *mudhelpers.StorecoreStoreSetField:
+		logger.LogInfo("[indexer] processing store set field message")
+		if filter.AllowsRecord(event.TableId, event.Key) {
+			logMudEvent = mudhandlers.HandleSetFieldEvent(event, db)
+		}
+	case *mudhelpers.StorecoreStoreDeleteRecord:
+		logger.LogInfo("[indexer] processing store delete record message")
+		if filter.AllowsRecord(event.TableId, event.Key) {
+			logMudEvent = mudhandlers.HandleDeleteRecordEvent(event, db)
+		}
+	case *mudhelpers.StorecoreStoreEphemeralRecord:
+		logger.LogInfo("[indexer] processing store ephemeral record message")
+		if filter.AllowsRecord(event.TableId, event.Key) {
+			mudhandlers.HandleStoreEphemeralRecord(event, db)
 		}
-		if v.Topics[0].Hex() == mudhelpers.GetStoreAbiEventID("StoreDeleteRecord").Hex() {
-			logger.LogInfo("[indexer] processing store delete record message")
-			event, err := mudhandlers.ParseStoreDeleteRecord(v)
-			if err != nil {
-				logger.LogError(fmt.Sprintf("[indexer] error decoding message for store delete record:%s\n", err))
+	case *mudhelpers.StoreEventsSetRecord:
+		logger.LogInfo("[indexer] processing store set record (v2) message")
+		if filter.AllowsStoreRecord(event.TableId, event.KeyTuple) {
+			if mudhelpers.PaddedTableId(event.TableId) == mudhelpers.StoreTablesId() {
+				logger.LogInfo("[indexer] processing and registering a table")
+				logMudEvent = mudhandlers.HandleStoreTablesEvent(event, db)
+			} else if mudhandlers.IsOffchainTable(event.TableId) {
+				// Offchain records are streamed, they are not part of the state
+				mudhandlers.HandleOffchainSetRecord(event, db)
 			} else {
-				logMudEvent = mudhandlers.HandleDeleteRecordEvent(event, db)
+				logMudEvent = mudhandlers.HandleStoreEventsSetRecord(event, db)
 			}
 		}
-
-		if found {
-			fmt.Printf("validating table:%s, key:%s\n", logMudEvent.Table, logMudEvent.Key)
-			for i, event := range *processedTxns[v.TxHash.Hex()].Events {
-				if logMudEvent.Table == event.Table && logMudEvent.Key == event.Key {
-
-					for j, field := range event.Fields {
-						if logMudEvent.Fields[j].Data.String() != field.Data.String() {
-							fmt.Printf("fieldA:%s.fieldB:%s. %s != %s, for table %s, id %s\n", logMudEvent.Fields[j].Key, field.Key, logMudEvent.Fields[j].Data.String(), field.Data.String(), logMudEvent.Table, logMudEvent.Key)
-							panic("the prediction was wrong!")
-						}
-					}
-
-					temp := *processedTxns[v.TxHash.Hex()].Events
-
-					//nolint:gocritic
-					if len(temp) == 1 {
-						temp = []data.MudEvent{}
-					} else if len(temp) == i {
-						temp = temp[:i]
-					} else {
-						temp = append(temp[:i], temp[i+1:]...)
-					}
-
-					processedTxns[v.TxHash.Hex()].Events = &temp
-					break
-				}
+	case *mudhelpers.StoreEventsSpliceStaticData:
+		logger.LogInfo("[indexer] processing store splice static data message")
+		if filter.AllowsStoreRecord(event.TableId, event.KeyTuple) {
+			logMudEvent = mudhandlers.HandleSpliceStaticDataEvent(event, db)
+		}
+	case *mudhelpers.StoreEventsSpliceDynamicData:
+		logger.LogInfo("[indexer] processing store splice dynamic data message")
+		if filter.AllowsStoreRecord(event.TableId, event.KeyTuple) {
+			logMudEvent = mudhandlers.HandleSpliceDynamicDataEvent(event, db)
+		}
+	case *mudhelpers.StoreEventsDeleteRecord:
+		logger.LogInfo("[indexer] processing store delete record (v2) message")
+		if filter.AllowsStoreRecord(event.TableId, event.KeyTuple) {
+			if mudhandlers.IsOffchainTable(event.TableId) {
+				mudhandlers.HandleOffchainDeleteRecord(event, db)
+			} else {
+				logMudEvent = mudhandlers.HandleStoreEventsDeleteRecord(event, db)
 			}
 		}
-
 	}
+	return logMudEvent, nil
+}
 
-	for _, v := range processedTxns {
-		if len(*v.Events) > 0 {
-			fmt.Printf("events not processed %s %s %s", v.Txhash, (*v.Events)[0].Table, (*v.Events)[0].Key)
-			panic("events were not proccessed")
+// RetryDeadLetters decodes and applies the dead letters again, the logs that fail again are kept.
+// The rows modified by newer logs are not overwritten.
+func RetryDeadLetters(db *data.Database, filter mudhandlers.Filter) (int, int) {
+	retried := 0
+	failed := 0
+	for _, v := range db.DeadLetters() {
+		decoded := DecodeLog(v.Log)
+		err := db.RetryDeadLetter(v, func() error {
+			if decoded.Err != nil {
+				return decoded.Err
+			}
+			_, err := applyEvent(db, filter, decoded.Event)
+			return err
+		})
+		if err != nil {
+			failed++
+		} else {
+			retried++
 		}
 	}
+	logger.LogInfo(fmt.Sprintf("[indexer] retried %d dead letters, %d failed again", retried, failed))
+	return retried, failed
 }
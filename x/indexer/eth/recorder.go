@@ -0,0 +1,128 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// LogRecorder appends the logs to a file, one json encoded log per line
+type LogRecorder struct {
+	file  *os.File
+	mutex *sync.Mutex
+}
+
+func NewLogRecorder(path string) (*LogRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &LogRecorder{file: file, mutex: &sync.Mutex{}}, nil
+}
+
+func (r *LogRecorder) Record(logs []types.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	lines := []byte{}
+	for _, v := range logs {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, encoded...)
+		lines = append(lines, '\n')
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, err := r.file.Write(lines)
+	return err
+}
+
+func (r *LogRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
+}
+
+// RecordingSource writes every log fetched or streamed from the wrapped source
+type RecordingSource struct {
+	LogSource
+	Recorder *LogRecorder
+}
+
+func NewRecordingSource(source LogSource, recorder *LogRecorder) *RecordingSource {
+	return &RecordingSource{LogSource: source, Recorder: recorder}
+}
+
+func (s *RecordingSource) record(logs []types.Log) {
+	if err := s.Recorder.Record(logs); err != nil {
+		logger.LogError(fmt.Sprintf("[indexer] error recording the logs: %s", err))
+	}
+}
+
+func (s *RecordingSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	logs, err := s.LogSource.FilterLogs(ctx, q)
+	if err == nil {
+		s.record(logs)
+	}
+	return logs, err
+}
+
+func (s *RecordingSource) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	receipts, ok := s.LogSource.(ReceiptReader)
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipts.TransactionReceipt(ctx, txHash)
+}
+
+func (s *RecordingSource) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	sub, ok := s.LogSource.(Subscriber)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	return sub.SubscribeNewHead(ctx, ch)
+}
+
+func (s *RecordingSource) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	sub, ok := s.LogSource.(Subscriber)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+
+	// Forward the logs after recording them, the forwarding stops when the subscription ends
+	logs := make(chan types.Log, cap(ch))
+	subscription, err := sub.SubscribeFilterLogs(ctx, q, logs)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case v := <-logs:
+				s.record([]types.Log{v})
+				select {
+				case ch <- v:
+				case <-ctx.Done():
+					return
+				}
+			case <-subscription.Err():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return subscription, nil
+}
@@ -0,0 +1,117 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ErrReorgTooDeep is returned when none of the tracked blocks is part of the canonical chain, the database must be indexed again
+var ErrReorgTooDeep = errors.New("the chain reorg is deeper than the tracked blocks")
+
+type HeaderReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// HandleReorg verifies that the last processed block is still part of the canonical chain.
+// In case it was orphaned, it reverts the database to the common ancestor and returns its height.
+func HandleReorg(ctx context.Context, headers HeaderReader, db *data.Database, head uint64) (uint64, bool, error) {
+	last := db.LastProcessedBlock()
+	if last == nil {
+		return 0, false, nil
+	}
+
+	if last.Height < head {
+		next, err := headers.HeaderByNumber(ctx, new(big.Int).SetUint64(last.Height+1))
+		if err != nil {
+			return 0, false, err
+		}
+		if next.ParentHash == last.Hash {
+			return last.Height, false, nil
+		}
+		logger.LogInfo(fmt.Sprintf("[indexer] parent hash mismatch at block %d: %s != %s", next.Number.Uint64(), next.ParentHash.Hex(), last.Hash.Hex()))
+	}
+
+	// A head below the last block may be stale or come from an endpoint that is behind, the blocks are only reverted
+	// if the last one was replaced or if no endpoint has it anymore
+	if last.Height > head {
+		header, err := headers.HeaderByNumber(ctx, new(big.Int).SetUint64(last.Height))
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			return 0, false, err
+		}
+		if err == nil && header.Hash() == last.Hash {
+			return last.Height, false, nil
+		}
+	}
+
+	for _, block := range db.ProcessedBlocks() {
+		if block.Height > head {
+			continue
+		}
+
+		header, err := headers.HeaderByNumber(ctx, new(big.Int).SetUint64(block.Height))
+		if err != nil {
+			return 0, false, err
+		}
+
+		if header.Hash() == block.Hash {
+			if block.Height == last.Height {
+				return last.Height, false, nil
+			}
+			logger.LogInfo(fmt.Sprintf("[indexer] chain reorg detected, rolling back from %d to %d", last.Height, block.Height))
+			if err := db.Rollback(block.Height); err != nil {
+				return 0, false, err
+			}
+			return block.Height, true, nil
+		}
+	}
+
+	return 0, false, fmt.Errorf("%w (%d blocks)", ErrReorgTooDeep, data.MaxReorgDepth)
+}
+
+// ValidateCheckpoint verifies that the loaded checkpoint belongs to the same chain and that its block is still canonical.
+// If the block was orphaned the tracked blocks are reverted to the common ancestor. The database is cleared if it belongs to
+// another chain or if none of the tracked blocks is canonical, so the chain is indexed again from the beginning.
+func ValidateCheckpoint(ctx context.Context, headers HeaderReader, db *data.Database, chainID string) (bool, error) {
+	last := db.LastProcessedBlock()
+	if last == nil {
+		return false, nil
+	}
+
+	if checkpointChainID := db.Info().ChainID; checkpointChainID != chainID {
+		logger.LogError(fmt.Sprintf("[indexer] the checkpoint chain id %s does not match the endpoint chain id %s, discarding it", checkpointChainID, chainID))
+		db.Reset()
+		return false, nil
+	}
+
+	header, err := headers.HeaderByNumber(ctx, new(big.Int).SetUint64(last.Height))
+	if err != nil && !errors.Is(err, ethereum.NotFound) {
+		return false, err
+	}
+	// The chain may be shorter than the checkpoint after a reorg
+	if err == nil && header.Hash() == last.Hash {
+		return true, nil
+	}
+
+	head, err := headers.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	logger.LogError(fmt.Sprintf("[indexer] the checkpoint block %d (%s) is not canonical anymore, reverting the orphaned blocks", last.Height, last.Hash.Hex()))
+	_, _, err = HandleReorg(ctx, headers, db, head.Number.Uint64())
+	if errors.Is(err, ErrReorgTooDeep) {
+		logger.LogError(fmt.Sprintf("[indexer] none of the checkpoint blocks is canonical, discarding it: %s", err))
+		db.Reset()
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
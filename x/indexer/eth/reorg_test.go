@@ -0,0 +1,156 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+)
+
+func processRange(t *testing.T, source LogSource, db *data.Database, from uint64, to uint64) {
+	t.Helper()
+	window := NewAdaptiveWindow(100, 1, 100)
+	if err := ProcessBlocks(context.Background(), source, db, mudhandlers.Filter{}, window, true, new(big.Int).SetUint64(from), new(big.Int).SetUint64(to)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleReorgRevertsRows(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemorySource(1)
+	source.AddLogs(
+		registerTableLog(t, 2, 0, "value"),
+		counterLog(t, 2, 1, 1, 10),
+		counterLog(t, 5, 0, 1, 20),
+	)
+	source.SetHead(6)
+
+	db := data.NewDatabase()
+	processRange(t, source, db, 0, 6)
+	if value := counterValue(t, db, 1); value != `"value":20` {
+		t.Fatalf("unexpected value %q", value)
+	}
+
+	// The block 5 is replaced by one that sets another value
+	source.Reorg(4)
+	source.AddLogs(counterLog(t, 5, 0, 1, 30))
+	source.SetHead(7)
+
+	ancestor, reorged, err := HandleReorg(ctx, source, db, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reorged || ancestor != 2 {
+		t.Fatalf("expected a rollback to block 2, got %d (%v)", ancestor, reorged)
+	}
+	if value := counterValue(t, db, 1); value != `"value":10` {
+		t.Fatalf("the orphaned block was not reverted: %q", value)
+	}
+
+	processRange(t, source, db, ancestor+1, 7)
+	if value := counterValue(t, db, 1); value != `"value":30` {
+		t.Fatalf("unexpected value after the reorg %q", value)
+	}
+}
+
+func TestHandleReorgRevertsRegistration(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemorySource(1)
+	source.SetHead(1)
+	db := data.NewDatabase()
+	processRange(t, source, db, 0, 1)
+
+	source.AddLogs(registerTableLog(t, 2, 0, "value"), counterLog(t, 2, 1, 1, 10))
+	source.SetHead(3)
+	processRange(t, source, db, 2, 3)
+	if name := counterTable(db).Metadata.TableName; name != "Counter" {
+		t.Fatalf("the table was not registered: %q", name)
+	}
+
+	// The registration is orphaned and the table is registered again with another column name
+	source.Reorg(2)
+	source.AddLogs(registerTableLog(t, 3, 0, "amount"))
+	source.SetHead(3)
+
+	ancestor, reorged, err := HandleReorg(ctx, source, db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reorged || ancestor != 1 {
+		t.Fatalf("expected a rollback to block 1, got %d (%v)", ancestor, reorged)
+	}
+	table := counterTable(db)
+	if table.Metadata.TableName != "" || len(*table.Schema.FieldNames) != 0 {
+		t.Fatalf("the registration was not reverted: %q %v", table.Metadata.TableName, *table.Schema.FieldNames)
+	}
+	if value := counterValue(t, db, 1); value != "" {
+		t.Fatalf("the row of the orphaned block still exists: %q", value)
+	}
+
+	processRange(t, source, db, 2, 3)
+	if names := *counterTable(db).Schema.FieldNames; len(names) != 1 || names[0] != "amount" {
+		t.Fatalf("unexpected field names %v", names)
+	}
+}
+
+func TestHandleReorgTooDeep(t *testing.T) {
+	source := NewMemorySource(1)
+	source.AddLogs(counterLog(t, 3, 0, 1, 10))
+	source.SetHead(4)
+	db := data.NewDatabase()
+	processRange(t, source, db, 2, 4)
+
+	// Every tracked block is orphaned
+	source.Reorg(1)
+	source.SetHead(5)
+	_, _, err := HandleReorg(context.Background(), source, db, 5)
+	if !errors.Is(err, ErrReorgTooDeep) {
+		t.Fatalf("expected ErrReorgTooDeep, got %v", err)
+	}
+}
+
+func TestHandleReorgLaggingHead(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemorySource(1)
+	source.AddLogs(registerTableLog(t, 2, 0, "value"), counterLog(t, 5, 0, 1, 20))
+	source.SetHead(6)
+
+	db := data.NewDatabase()
+	processRange(t, source, db, 0, 6)
+	db.SetConfirmedHeight(5)
+
+	// An endpoint behind the others reports an older head, the blocks after it are still canonical
+	ancestor, reorged, err := HandleReorg(ctx, source, db, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reorged || ancestor != 6 {
+		t.Fatalf("the canonical blocks were reverted to %d", ancestor)
+	}
+	if last := db.LastProcessedBlock(); last.Height != 6 {
+		t.Fatalf("unexpected last processed block %d", last.Height)
+	}
+	if value := counterValue(t, db, 1); value != `"value":20` {
+		t.Fatalf("the row was reverted: %q", value)
+	}
+	if confirmed := db.Info().ConfirmedHeight; confirmed != 5 {
+		t.Fatalf("the confirmed height was lowered to %d", confirmed)
+	}
+
+	// The chain got shorter, the blocks that no endpoint has are reverted to the last tracked canonical one
+	source.Reorg(4)
+	source.SetHead(5)
+	ancestor, reorged, err = HandleReorg(ctx, source, db, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reorged || ancestor != 2 {
+		t.Fatalf("expected a rollback to block 2, got %d (%v)", ancestor, reorged)
+	}
+	if value := counterValue(t, db, 1); value != "" {
+		t.Fatalf("the orphaned block was not reverted: %q", value)
+	}
+}
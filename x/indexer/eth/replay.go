@@ -0,0 +1,87 @@
+package eth
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type recordedBlock struct {
+	hash common.Hash
+	logs map[uint]types.Log
+}
+
+// ReadLogs loads the logs written by a LogRecorder. The same log may be recorded more than once and the blocks
+// may have been reorged, so only the last recorded version of each block is kept.
+func ReadLogs(path string) ([]types.Log, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blocks := map[uint64]*recordedBlock{}
+	scanner := bufio.NewScanner(file)
+	// Store records can be bigger than the default line limit
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var v types.Log
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			return nil, fmt.Errorf("error decoding the log at line %d: %w", line, err)
+		}
+
+		block, ok := blocks[v.BlockNumber]
+		if !ok || block.hash != v.BlockHash {
+			if v.Removed {
+				continue
+			}
+			block = &recordedBlock{hash: v.BlockHash, logs: map[uint]types.Log{}}
+			blocks[v.BlockNumber] = block
+		}
+
+		if v.Removed {
+			delete(block.logs, v.Index)
+		} else {
+			block.logs[v.Index] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	logs := []types.Log{}
+	for _, block := range blocks {
+		for _, v := range block.logs {
+			logs = append(logs, v)
+		}
+	}
+	return OrderLogs(logs), nil
+}
+
+// ReplayLogs applies the recorded logs using the same decoding and handlers as the live indexer, it returns the last replayed height
+func ReplayLogs(db *data.Database, filter mudhandlers.Filter, path string) (uint64, error) {
+	logs, err := ReadLogs(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(logs) == 0 {
+		return 0, nil
+	}
+
+	ProcessLogs(db, filter, logs)
+	height := logs[len(logs)-1].BlockNumber
+	db.SetLastHeight(height)
+	return height, nil
+}
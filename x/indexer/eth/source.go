@@ -0,0 +1,40 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	gethclient "github.com/ethereum/go-ethereum/ethclient"
+)
+
+// LogSource is the chain data used by the indexer: the head height, the logs for a block range and the block headers.
+// The errors are returned so the requests can be retried or failed over.
+type LogSource interface {
+	HeaderReader
+	// BlockNumber returns the chain head height
+	BlockNumber(ctx context.Context) (uint64, error)
+	ChainID(ctx context.Context) (*big.Int, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+var (
+	_ LogSource  = (*gethclient.Client)(nil)
+	_ Subscriber = (*gethclient.Client)(nil)
+	_ LogSource  = (*EndpointPool)(nil)
+	_ Subscriber = (*EndpointPool)(nil)
+	_ LogSource  = (*RecordingSource)(nil)
+	_ LogSource  = (*MemorySource)(nil)
+	_ LogSource  = (*InstrumentedSource)(nil)
+	_ Subscriber = (*InstrumentedSource)(nil)
+)
+
+// DialEthClient connects to a single rpc endpoint, use an EndpointPool to fail over between endpoints
+func DialEthClient(ctx context.Context, url string) (LogSource, error) {
+	client, err := gethclient.DialContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
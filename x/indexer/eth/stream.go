@@ -0,0 +1,110 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type Subscriber interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+	SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error)
+}
+
+// StreamBlocks subscribes to the new heads and applies each block as soon as its header arrives.
+// The store logs of a block are requested using its hash, the logs subscription does not guarantee that every log is sent before the header.
+// It returns the next height to be processed when the context is cancelled or the subscription fails.
+// The progress function is called with the next height and the head after each block.
+func StreamBlocks(ctx context.Context, source LogSource, sub Subscriber, db *data.Database, filter mudhandlers.Filter, window *AdaptiveWindow, fetchTimestamps bool, nextHeight uint64, confirmations ConfirmationPolicy, checkpoint *data.Checkpointer, progress func(nextHeight uint64, head uint64)) (uint64, error) {
+	heads := make(chan *types.Header, 16)
+	headsSub, err := sub.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return nextHeight, err
+	}
+	defer headsSub.Unsubscribe()
+
+	logger.LogInfo(fmt.Sprintf("[indexer] streaming blocks from %d", nextHeight))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nextHeight, nil
+		case err := <-headsSub.Err():
+			return nextHeight, fmt.Errorf("new heads subscription failed: %w", err)
+		case header := <-heads:
+			nextHeight, err = processHead(ctx, source, db, filter, window, fetchTimestamps, header, nextHeight)
+			if err != nil {
+				return nextHeight, err
+			}
+			if err := db.Err(); err != nil {
+				return nextHeight, err
+			}
+			if err := UpdateConfirmedHeight(ctx, source, db, confirmations, header.Number.Uint64()); err != nil {
+				return nextHeight, err
+			}
+			checkpoint.SaveIfDue(db)
+			if progress != nil {
+				progress(nextHeight, header.Number.Uint64())
+			}
+		}
+	}
+}
+
+func processHead(ctx context.Context, source LogSource, db *data.Database, filter mudhandlers.Filter, window *AdaptiveWindow, fetchTimestamps bool, header *types.Header, nextHeight uint64) (uint64, error) {
+	height := header.Number.Uint64()
+
+	last := db.LastProcessedBlock()
+	if last != nil && (height < nextHeight || (last.Height+1 == height && header.ParentHash != last.Hash)) {
+		ancestor, reorged, err := HandleReorg(ctx, source, db, height)
+		if err != nil {
+			return nextHeight, err
+		}
+		if reorged {
+			nextHeight = ancestor + 1
+		}
+	}
+
+	if height < nextHeight {
+		return nextHeight, nil
+	}
+
+	// Fill the gap if some heads were skipped
+	if height > nextHeight {
+		if err := ProcessBlocks(ctx, source, db, filter, window, fetchTimestamps, new(big.Int).SetUint64(nextHeight), new(big.Int).SetUint64(height-1)); err != nil {
+			return nextHeight, err
+		}
+	}
+
+	// The logs are requested by hash so they always belong to this header, even if the block was orphaned since
+	logs := []types.Log{}
+	if hasStoreLogs(header) {
+		var err error
+		logs, err = FilterBlockStoreLogs(ctx, source, filter, header.Hash())
+		if err != nil {
+			return nextHeight, err
+		}
+	}
+
+	// Every log belongs to this block. The block is tracked after its logs are applied, like ProcessBlocks does,
+	// so a crash in between never stores the block as processed without them
+	ApplyLogs(db, filter, setTimestamps(DecodeLogs(OrderLogs(logs)), map[uint64]uint64{height: header.Time}))
+	db.StartBlock(height, header.Hash())
+	db.SetLastHeight(height)
+
+	return height + 1, nil
+}
+
+func hasStoreLogs(header *types.Header) bool {
+	for _, topic := range StoreLogsTopics() {
+		if types.BloomLookup(header.Bloom, topic) {
+			return true
+		}
+	}
+	return false
+}
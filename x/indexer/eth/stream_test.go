@@ -0,0 +1,130 @@
+package eth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+)
+
+type streamResult struct {
+	nextHeight uint64
+	err        error
+}
+
+// startStream runs StreamBlocks against the node until the test ends, the applied heights are sent to the returned channel
+func startStream(t *testing.T, node *testNode, url string, db *data.Database, nextHeight uint64) (chan uint64, chan streamResult, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	client, err := DialEthClient(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := client.(Subscriber)
+
+	applied := make(chan uint64, 16)
+	done := make(chan streamResult, 1)
+	go func() {
+		window := NewAdaptiveWindow(100, 1, 100)
+		next, err := StreamBlocks(ctx, client, sub, db, mudhandlers.Filter{}, window, true, nextHeight, ConfirmationPolicy{}, nil, func(nextHeight uint64, _ uint64) {
+			applied <- nextHeight
+		})
+		done <- streamResult{nextHeight: next, err: err}
+	}()
+
+	select {
+	case <-node.subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream did not subscribe to the new heads")
+	}
+	return applied, done, cancel
+}
+
+func waitApplied(t *testing.T, applied chan uint64, nextHeight uint64) {
+	t.Helper()
+	for {
+		select {
+		case v := <-applied:
+			if v == nextHeight {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the block %d was not applied", nextHeight-1)
+		}
+	}
+}
+
+func TestStreamBlocksAppliesEveryLog(t *testing.T) {
+	source := NewMemorySource(1)
+	source.AddLogs(registerTableLog(t, 1, 0, "value"))
+	db := data.NewDatabase()
+	processRange(t, source, db, 0, 1)
+
+	node, url := startTestNode(t, source)
+	applied, done, cancel := startStream(t, node, url, db, 2)
+
+	// Every log of the block is applied even if there are many of them
+	rows := 50
+	for i := 0; i < rows; i++ {
+		source.AddLogs(counterLog(t, 2, uint(i), byte(i), uint32(i+100)))
+	}
+	node.PublishHead(t, 2)
+	waitApplied(t, applied, 3)
+	for i := 0; i < rows; i++ {
+		if value := counterValue(t, db, byte(i)); value == "" {
+			t.Fatalf("the log %d of the block was not applied", i)
+		}
+	}
+
+	// An empty block does not request the logs
+	requests := node.Calls("eth_getLogs")
+	source.SetHead(3)
+	node.PublishHead(t, 3)
+	waitApplied(t, applied, 4)
+	if node.Calls("eth_getLogs") != requests {
+		t.Errorf("the logs of an empty block were requested")
+	}
+
+	cancel()
+	result := <-done
+	if result.err != nil || result.nextHeight != 4 {
+		t.Fatalf("unexpected stream result %d: %v", result.nextHeight, result.err)
+	}
+}
+
+func TestStreamBlocksReplacedHead(t *testing.T) {
+	source := NewMemorySource(1)
+	source.AddLogs(registerTableLog(t, 1, 0, "value"))
+	db := data.NewDatabase()
+	processRange(t, source, db, 0, 1)
+
+	node, url := startTestNode(t, source)
+	applied, _, _ := startStream(t, node, url, db, 2)
+
+	source.AddLogs(counterLog(t, 2, 0, 1, 10))
+	node.PublishHead(t, 2)
+	waitApplied(t, applied, 3)
+	if value := counterValue(t, db, 1); value != `"value":10` {
+		t.Fatalf("unexpected value %q", value)
+	}
+
+	// The block 2 is replaced, its logs must not be mixed with the orphaned ones
+	source.Reorg(2)
+	source.AddLogs(counterLog(t, 2, 0, 2, 20))
+	header := node.PublishHead(t, 2)
+	waitApplied(t, applied, 3)
+
+	if value := counterValue(t, db, 1); value != "" {
+		t.Errorf("the row of the orphaned block still exists: %q", value)
+	}
+	if value := counterValue(t, db, 2); value != `"value":20` {
+		t.Errorf("unexpected value %q", value)
+	}
+	if last := db.LastProcessedBlock(); last.Hash != header.Hash() {
+		t.Errorf("the new block was not tracked: %s != %s", last.Hash.Hex(), header.Hash().Hex())
+	}
+}
@@ -0,0 +1,71 @@
+package eth
+
+import (
+	"testing"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+)
+
+func TestRegisterTableAgainRenamesRows(t *testing.T) {
+	source := NewMemorySource(1)
+	source.AddLogs(
+		registerTableLog(t, 2, 0, "value"),
+		counterLog(t, 2, 1, 1, 10),
+		registerTableLog(t, 3, 0, "Amount"),
+		counterLog(t, 3, 1, 2, 20),
+	)
+	source.SetHead(3)
+
+	db := data.NewDatabase()
+	processRange(t, source, db, 0, 3)
+
+	// The row written before the new registration uses the new column name
+	if value := counterValue(t, db, 1); value != `"amount":10` {
+		t.Errorf("the row was not renamed: %q", value)
+	}
+	if value := counterValue(t, db, 2); value != `"amount":20` {
+		t.Errorf("unexpected row %q", value)
+	}
+	table := db.GetWorld(testWorld.Hex()).GetTableByName("Counter")
+	if table == nil {
+		t.Fatal("the table was not found")
+	}
+	if names := *table.Schema.FieldNames; len(names) != 1 || names[0] != "amount" {
+		t.Errorf("unexpected field names %v", names)
+	}
+	if _, ok := (*table.Schema.NamedFields)["amount"]; !ok || table.Metadata.OnChainTableName != "game__Counter" {
+		t.Errorf("unexpected table %+v %+v", table.Metadata, *table.Schema.NamedFields)
+	}
+}
+
+func TestTablesWithTheSameName(t *testing.T) {
+	otherID := mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, "other", "Counter")
+	source := NewMemorySource(1)
+	source.AddLogs(
+		registerTableLog(t, 2, 0, "value"),
+		registerResourceLog(t, 2, 1, otherID, "amount"),
+		counterLog(t, 2, 2, 1, 10),
+		resourceLog(t, 2, 3, otherID, 1, 20),
+	)
+	source.SetHead(2)
+
+	db := data.NewDatabase()
+	processRange(t, source, db, 0, 2)
+
+	world := db.GetWorld(testWorld.Hex())
+	if table := world.GetTableByName("Counter"); table != nil {
+		t.Errorf("the name used by two namespaces returned the table %s", table.Metadata.OnChainTableName)
+	}
+	game := world.GetTableByNamespace("game", "Counter")
+	other := world.GetTableByName("other__Counter")
+	if game == nil || other == nil || game.Metadata.TableID == other.Metadata.TableID {
+		t.Fatalf("the namespaced tables were not found: %v %v", game, other)
+	}
+	if fields, err := db.GetRowNoMempool(game, hexKey(1)); err != nil || fields[0].String() != `"value":10` {
+		t.Errorf("unexpected game row %v: %v", fields, err)
+	}
+	if fields, err := db.GetRowNoMempool(other, hexKey(1)); err != nil || fields[0].String() != `"amount":20` {
+		t.Errorf("unexpected other row %v: %v", fields, err)
+	}
+}
@@ -0,0 +1,91 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Amount of headers requested at the same time to get the block timestamps
+const timestampWorkers = 8
+
+// BlockTimestamps returns the time of each block with logs, the known headers are not requested again.
+// If fetch is false only the known headers are used, it saves one request per block but the rows provenance will not have timestamps.
+func BlockTimestamps(ctx context.Context, source HeaderReader, logs []types.Log, fetch bool, known ...*types.Header) (map[uint64]uint64, error) {
+	ret := map[uint64]uint64{}
+	for _, header := range known {
+		if header != nil {
+			ret[header.Number.Uint64()] = header.Time
+		}
+	}
+	if !fetch {
+		return ret, nil
+	}
+
+	missing := map[uint64]bool{}
+	for _, v := range logs {
+		if _, ok := ret[v.BlockNumber]; !ok {
+			missing[v.BlockNumber] = true
+		}
+	}
+	heights := make([]uint64, 0, len(missing))
+	for height := range missing {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mutex := &sync.Mutex{}
+	var fetchErr error
+	wg := sync.WaitGroup{}
+	jobs := make(chan uint64)
+	for w := 0; w < timestampWorkers && w < len(heights); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for height := range jobs {
+				header, err := source.HeaderByNumber(ctx, new(big.Int).SetUint64(height))
+				mutex.Lock()
+				if err != nil {
+					if fetchErr == nil {
+						fetchErr = fmt.Errorf("error getting the header for block %d: %w", height, err)
+					}
+					cancel()
+				} else {
+					ret[height] = header.Time
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, height := range heights {
+		select {
+		case jobs <- height:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func setTimestamps(logs []DecodedLog, timestamps map[uint64]uint64) []DecodedLog {
+	for i := range logs {
+		logs[i].Timestamp = timestamps[logs[i].Log.BlockNumber]
+	}
+	return logs
+}
@@ -0,0 +1,50 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestBlockTimestamps(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemorySource(1)
+	logs := []types.Log{}
+	for height := uint64(1); height <= 40; height++ {
+		logs = append(logs, types.Log{BlockNumber: height}, types.Log{BlockNumber: height, Index: 1})
+	}
+	source.SetHead(40)
+	known, _ := source.HeaderByNumber(ctx, bigInt(40))
+
+	// The memory source uses the height as the block time
+	timestamps, err := BlockTimestamps(ctx, source, logs, true, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timestamps) != 40 {
+		t.Fatalf("expected 40 timestamps, got %d", len(timestamps))
+	}
+	for height, timestamp := range timestamps {
+		if timestamp != height {
+			t.Fatalf("unexpected timestamp %d for block %d", timestamp, height)
+		}
+	}
+
+	// Only the known headers are used if they are not fetched
+	timestamps, err = BlockTimestamps(ctx, source, logs, false, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timestamps) != 1 || timestamps[40] != 40 {
+		t.Fatalf("unexpected timestamps %v", timestamps)
+	}
+
+	// A missing header fails the request
+	logs = append(logs, types.Log{BlockNumber: 50})
+	if _, err := BlockTimestamps(ctx, source, logs, true); !errors.Is(err, ethereum.NotFound) {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
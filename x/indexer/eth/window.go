@@ -0,0 +1,190 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	// Consecutive successful requests needed to grow the window
+	WindowGrowthThreshold = 5
+	// Failed requests allowed before giving up on a range
+	MaxFilterLogsRetries = 8
+	MinRetryBackoff      = 250 * time.Millisecond
+	MaxRetryBackoff      = 30 * time.Second
+)
+
+// AdaptiveWindow is the amount of blocks requested with each eth_getLogs call.
+// It is shrunk when the provider rejects a request and it grows back after consecutive successes.
+type AdaptiveWindow struct {
+	size      uint64
+	min       uint64
+	max       uint64
+	successes int
+	mutex     *sync.Mutex
+}
+
+func NewAdaptiveWindow(initial uint64, minSize uint64, maxSize uint64) *AdaptiveWindow {
+	if minSize < 1 {
+		minSize = 1
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+	if initial < minSize {
+		initial = minSize
+	}
+	if initial > maxSize {
+		initial = maxSize
+	}
+	return &AdaptiveWindow{size: initial, min: minSize, max: maxSize, successes: 0, mutex: &sync.Mutex{}}
+}
+
+func (w *AdaptiveWindow) Size() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.size
+}
+
+// Shrink returns false if the window already had its minimum size
+func (w *AdaptiveWindow) Shrink() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.successes = 0
+	previous := w.size
+	w.size /= 2
+	if w.size < w.min {
+		w.size = w.min
+	}
+	return w.size < previous
+}
+
+func (w *AdaptiveWindow) Success() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.successes++
+	if w.successes < WindowGrowthThreshold {
+		return
+	}
+	w.successes = 0
+	w.size *= 2
+	if w.size > w.max {
+		w.size = w.max
+	}
+}
+
+// rangeErrors are the messages used by the providers to reject eth_getLogs requests with too many blocks or results
+var rangeErrors = []string{
+	"block range",
+	"range too large",
+	"range is too large",
+	"more than",
+	"too many blocks",
+	"too many results",
+	"limit exceeded",
+	"response size",
+	"query timeout exceeded",
+}
+
+// limitExceededCode is the json rpc error code used by most providers when a request exceeds their limits
+const limitExceededCode = -32005
+
+// IsRangeError is true if the provider rejected the request because of its size, a smaller range must be requested
+func IsRangeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == limitExceededCode {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, v := range rangeErrors {
+		if strings.Contains(message, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func retryBackoff(attempt int) time.Duration {
+	backoff := MinRetryBackoff << attempt
+	if backoff > MaxRetryBackoff || backoff <= 0 {
+		return MaxRetryBackoff
+	}
+	return backoff
+}
+
+// FilterStoreLogs requests the logs using the window size, failed requests are split and retried with exponential backoff
+func FilterStoreLogs(ctx context.Context, source LogSource, filter mudhandlers.Filter, window *AdaptiveWindow, from uint64, to uint64) ([]types.Log, error) {
+	ret := []types.Log{}
+	attempt := 0
+	for from <= to {
+		end := from + window.Size() - 1
+		if end > to {
+			end = to
+		}
+
+		logs, err := source.FilterLogs(ctx, QueryForStoreLogs(new(big.Int).SetUint64(from), new(big.Int).SetUint64(end), filter.WorldAddresses()))
+		if err != nil && IsRangeError(err) && window.Shrink() {
+			// The provider limit is not a failure, the smaller range is requested right away
+			logger.LogInfo(fmt.Sprintf("[indexer] the logs from %d to %d were rejected, retrying with %d blocks: %s", from, end, window.Size(), err))
+			continue
+		}
+		if err != nil {
+			if attempt >= MaxFilterLogsRetries {
+				return nil, fmt.Errorf("error getting the logs from %d to %d after %d retries: %w", from, end, attempt, err)
+			}
+
+			window.Shrink()
+			backoff := retryBackoff(attempt)
+			attempt++
+			logger.LogError(fmt.Sprintf("[indexer] error getting the logs from %d to %d, retrying in %s with %d blocks: %s", from, end, backoff, window.Size(), err))
+
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			continue
+		}
+
+		window.Success()
+		attempt = 0
+		ret = append(ret, logs...)
+		from = end + 1
+	}
+	return ret, nil
+}
+
+// FilterBlockStoreLogs requests the logs of a block by its hash, failed requests are retried with exponential backoff
+func FilterBlockStoreLogs(ctx context.Context, source LogSource, filter mudhandlers.Filter, blockHash common.Hash) ([]types.Log, error) {
+	for attempt := 0; ; attempt++ {
+		logs, err := source.FilterLogs(ctx, QueryForBlockStoreLogs(blockHash, filter.WorldAddresses()))
+		if err == nil {
+			return logs, nil
+		}
+		if attempt >= MaxFilterLogsRetries {
+			return nil, fmt.Errorf("error getting the logs of block %s after %d retries: %w", blockHash.Hex(), attempt, err)
+		}
+
+		backoff := retryBackoff(attempt)
+		logger.LogError(fmt.Sprintf("[indexer] error getting the logs of block %s, retrying in %s: %s", blockHash.Hex(), backoff, err))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
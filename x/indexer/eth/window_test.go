@@ -0,0 +1,144 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// limitedSource rejects the requests with more blocks than its limit and fails the first requests
+type limitedSource struct {
+	*MemorySource
+	limit    uint64
+	failures int
+	requests [][2]uint64
+}
+
+func (s *limitedSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	from, to := q.FromBlock.Uint64(), q.ToBlock.Uint64()
+	s.requests = append(s.requests, [2]uint64{from, to})
+	if to-from+1 > s.limit {
+		return nil, fmt.Errorf("query returned too many results, the limit is %d blocks", s.limit)
+	}
+	if s.failures > 0 {
+		s.failures--
+		return nil, errors.New("connection reset by peer")
+	}
+	return s.MemorySource.FilterLogs(ctx, q)
+}
+
+func newLimitedSource(t *testing.T, blocks uint64, limit uint64) *limitedSource {
+	source := NewMemorySource(1)
+	for height := uint64(1); height <= blocks; height++ {
+		source.AddLogs(counterLog(t, height, 0, byte(height), uint32(height)))
+	}
+	source.SetHead(blocks)
+	return &limitedSource{MemorySource: source, limit: limit}
+}
+
+func TestAdaptiveWindow(t *testing.T) {
+	window := NewAdaptiveWindow(8, 2, 16)
+	if !window.Shrink() || window.Size() != 4 {
+		t.Fatalf("unexpected size %d", window.Size())
+	}
+	if !window.Shrink() || window.Size() != 2 {
+		t.Fatalf("unexpected size %d", window.Size())
+	}
+	if window.Shrink() || window.Size() != 2 {
+		t.Fatalf("the window was shrunk below its minimum: %d", window.Size())
+	}
+
+	// It grows after consecutive successes, a failure restarts the count
+	for i := 0; i < WindowGrowthThreshold-1; i++ {
+		window.Success()
+	}
+	window.Shrink()
+	for i := 0; i < WindowGrowthThreshold-1; i++ {
+		window.Success()
+	}
+	if window.Size() != 2 {
+		t.Fatalf("the window grew without consecutive successes: %d", window.Size())
+	}
+	window.Success()
+	if window.Size() != 4 {
+		t.Fatalf("the window did not grow: %d", window.Size())
+	}
+	for i := 0; i < 3*WindowGrowthThreshold; i++ {
+		window.Success()
+	}
+	if window.Size() != 16 {
+		t.Fatalf("the window grew above its maximum: %d", window.Size())
+	}
+}
+
+func TestFilterStoreLogsSplitsTheRange(t *testing.T) {
+	source := newLimitedSource(t, 40, 4)
+	window := NewAdaptiveWindow(16, 1, 16)
+	logs, err := FilterStoreLogs(context.Background(), source, mudhandlers.Filter{}, window, 1, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 40 {
+		t.Fatalf("expected 40 logs, got %d", len(logs))
+	}
+	for i, log := range logs {
+		if log.BlockNumber != uint64(i+1) {
+			t.Fatalf("the log %d is from block %d", i, log.BlockNumber)
+		}
+	}
+
+	// The rejected ranges are split, the window grows after the successes and it is shrunk again
+	expected := [][2]uint64{
+		{1, 16}, {1, 8}, {1, 4}, {5, 8}, {9, 12}, {13, 16}, {17, 20},
+		{21, 28}, {21, 24}, {25, 28}, {29, 32}, {33, 36}, {37, 40},
+	}
+	if !reflect.DeepEqual(source.requests, expected) {
+		t.Errorf("unexpected requests %v", source.requests)
+	}
+	// The last 5 requests were accepted
+	if window.Size() != 8 {
+		t.Errorf("unexpected window size %d", window.Size())
+	}
+}
+
+func TestFilterStoreLogsRetries(t *testing.T) {
+	backoff := MinRetryBackoff
+	retries := MaxFilterLogsRetries
+	MinRetryBackoff = time.Millisecond
+	MaxFilterLogsRetries = 3
+	defer func() {
+		MinRetryBackoff = backoff
+		MaxFilterLogsRetries = retries
+	}()
+
+	// The failed requests are retried with a smaller window
+	source := newLimitedSource(t, 8, 8)
+	source.failures = 2
+	window := NewAdaptiveWindow(8, 1, 8)
+	logs, err := FilterStoreLogs(context.Background(), source, mudhandlers.Filter{}, window, 1, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 8 {
+		t.Fatalf("expected 8 logs, got %d", len(logs))
+	}
+	if expected := [][2]uint64{{1, 8}, {1, 4}, {1, 2}, {3, 4}, {5, 6}, {7, 8}}; !reflect.DeepEqual(source.requests, expected) {
+		t.Errorf("unexpected requests %v", source.requests)
+	}
+
+	source = newLimitedSource(t, 8, 8)
+	source.failures = 10
+	if _, err := FilterStoreLogs(context.Background(), source, mudhandlers.Filter{}, NewAdaptiveWindow(8, 1, 8), 1, 8); err == nil {
+		t.Fatal("the range was not given up after the retries")
+	}
+	if len(source.requests) != MaxFilterLogsRetries+1 {
+		t.Errorf("unexpected requests %v", source.requests)
+	}
+}
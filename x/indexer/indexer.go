@@ -0,0 +1,169 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth"
+	"github.com/bocha-io/garnet/x/indexer/eth/mudhandlers"
+)
+
+type Options struct {
+	// Time between chain head requests when the endpoint does not support subscriptions
+	PollInterval time.Duration
+	// Initial and maximum amount of blocks requested with each eth_getLogs call
+	BatchSize    uint64
+	MaxBatchSize uint64
+	// Used when there is no checkpoint
+	StartingHeight uint64
+	Confirmations  eth.ConfirmationPolicy
+	// Expiry and receipt checks of the transactions sent by the game
+	Pending eth.PendingPolicy
+	Filter  mudhandlers.Filter
+	// Requests the header of every block with store logs to record when each row was modified
+	FetchTimestamps bool
+	// Optional, the state is not persisted if it is nil
+	Checkpoint *data.Checkpointer
+	// Optional, writes a snapshot every few blocks. The newest one is loaded at startup if there is no other state.
+	Snapshots *data.Snapshotter
+}
+
+func DefaultOptions() Options {
+	return Options{
+		PollInterval:    100 * time.Millisecond,
+		BatchSize:       initialWindowSize,
+		MaxBatchSize:    maxWindowSize,
+		StartingHeight:  0,
+		Confirmations:   eth.ConfirmationPolicy{},
+		Pending:         eth.PendingPolicy{MaxBlocks: 50, MaxAge: 5 * time.Minute, CheckReceipts: true, ReceiptInterval: 2 * time.Second},
+		Filter:          mudhandlers.Filter{},
+		FetchTimestamps: true,
+		Checkpoint:      nil,
+		Snapshots:       nil,
+	}
+}
+
+// Indexer keeps the database in sync with the chain until it is stopped
+type Indexer struct {
+	Source   eth.LogSource
+	Database *data.Database
+	options  Options
+
+	mutex     *sync.Mutex
+	cancel    context.CancelFunc
+	done      chan struct{}
+	err       error
+	height    uint64
+	head      uint64
+	lastError error
+}
+
+func NewIndexer(source eth.LogSource, database *data.Database, options Options) *Indexer {
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultOptions().PollInterval
+	}
+	if options.BatchSize == 0 {
+		options.BatchSize = initialWindowSize
+	}
+	if options.MaxBatchSize < options.BatchSize {
+		options.MaxBatchSize = options.BatchSize
+	}
+
+	return &Indexer{
+		Source:   source,
+		Database: database,
+		options:  options,
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// Start runs the indexer in the background until the context is cancelled or Stop is called
+func (i *Indexer) Start(ctx context.Context) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.done != nil {
+		return fmt.Errorf("the indexer was already started")
+	}
+
+	ctx, i.cancel = context.WithCancel(ctx)
+	i.done = make(chan struct{})
+	go func() {
+		err := i.process(ctx)
+		i.mutex.Lock()
+		i.err = err
+		i.mutex.Unlock()
+		close(i.done)
+	}()
+	return nil
+}
+
+func (i *Indexer) Stop() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.cancel != nil {
+		i.cancel()
+	}
+}
+
+// Wait blocks until the indexer stops, it returns the error that stopped it or the one that happened while shutting down
+func (i *Indexer) Wait() error {
+	i.mutex.Lock()
+	done := i.done
+	i.mutex.Unlock()
+	if done == nil {
+		return fmt.Errorf("the indexer was not started")
+	}
+
+	<-done
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.err
+}
+
+// Height is the last block applied to the database
+func (i *Indexer) Height() uint64 {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.height
+}
+
+// Head is the last known chain height
+func (i *Indexer) Head() uint64 {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.head
+}
+
+func (i *Indexer) Lag() uint64 {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.head < i.height {
+		return 0
+	}
+	return i.head - i.height
+}
+
+// LastError is the last error found while syncing, the indexer retries after errors
+func (i *Indexer) LastError() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.lastError
+}
+
+func (i *Indexer) setProgress(nextHeight uint64, head uint64) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if nextHeight > 0 {
+		i.height = nextHeight - 1
+	}
+	i.head = head
+}
+
+func (i *Indexer) setError(err error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.lastError = err
+}
@@ -0,0 +1,143 @@
+package indexer
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/bocha-io/garnet/x/indexer/eth"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/umbracle/ethgo/abi"
+)
+
+var (
+	testWorld   = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	testTableID = mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, "game", "Counter")
+)
+
+func setRecordLog(t *testing.T, height uint64, index uint, tableID [32]byte, key [][32]byte, staticData []byte, lengths [32]byte, dynamicData []byte) types.Log {
+	t.Helper()
+	event := mudhelpers.StoreEventsAbi.Events["Store_SetRecord"]
+	encoded, err := event.Inputs.NonIndexed().Pack(key, staticData, lengths, dynamicData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return types.Log{
+		Address:     testWorld,
+		Topics:      []common.Hash{event.ID, tableID},
+		Data:        encoded,
+		BlockNumber: height,
+		Index:       index,
+	}
+}
+
+func encodeNames(t *testing.T, names []string) []byte {
+	t.Helper()
+	encoded, err := abi.MustNewType("tuple(string[] cols)").Encode(map[string]interface{}{"cols": names})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+// registerTableLog registers game:Counter, a table with a bytes32 key and a uint32 field
+func registerTableLog(t *testing.T, height uint64) types.Log {
+	t.Helper()
+	fieldLayout := make([]byte, 32)
+	fieldLayout[1], fieldLayout[2], fieldLayout[4] = 4, 1, 4
+	keySchema := make([]byte, 32)
+	keySchema[1], keySchema[2], keySchema[4] = 32, 1, byte(mudhelpers.BYTES32)
+	valueSchema := make([]byte, 32)
+	valueSchema[1], valueSchema[2], valueSchema[4] = 4, 1, byte(mudhelpers.UINT32)
+	staticData := append(append(fieldLayout, keySchema...), valueSchema...)
+
+	keyNames := encodeNames(t, []string{"id"})
+	fieldNames := encodeNames(t, []string{"value"})
+	lengths := mudhelpers.EncodeLengths([]uint64{uint64(len(keyNames)), uint64(len(fieldNames))})
+	tablesID := mudhelpers.EncodeResourceID(mudhelpers.ResourceTable, "store", "Tables")
+	return setRecordLog(t, height, 0, tablesID, [][32]byte{testTableID}, staticData, lengths, append(keyNames, fieldNames...))
+}
+
+func counterLog(t *testing.T, height uint64, index uint, key byte, value uint32) types.Log {
+	t.Helper()
+	staticData := make([]byte, 4)
+	binary.BigEndian.PutUint32(staticData, value)
+	return setRecordLog(t, height, index, testTableID, [][32]byte{{31: key}}, staticData, [32]byte{}, []byte{})
+}
+
+// counterValue returns the field of the row as a string, empty if the row does not exist
+func counterValue(db *data.Database, key byte) string {
+	table := db.GetTable(testWorld.Hex(), mudhelpers.PaddedTableId(testTableID))
+	fields, err := db.GetRowNoMempool(table, common.Hash{31: key}.Hex())
+	if err != nil || len(fields) != 1 {
+		return ""
+	}
+	return fields[0].String()
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestIndexerFollowsMemorySource(t *testing.T) {
+	source := eth.NewMemorySource(1)
+	source.AddLogs(registerTableLog(t, 2), counterLog(t, 3, 0, 1, 10))
+	source.SetHead(5)
+
+	db := data.NewDatabase()
+	options := DefaultOptions()
+	options.PollInterval = 5 * time.Millisecond
+	indexer := NewIndexer(source, db, options)
+	if err := indexer.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the initial sync", func() bool { return indexer.Height() == 5 })
+	if value := counterValue(db, 1); value != `"value":10` {
+		t.Fatalf("unexpected value %q", value)
+	}
+
+	// New blocks are polled
+	source.AddLogs(counterLog(t, 7, 0, 2, 20))
+	source.SetHead(8)
+	waitFor(t, "the new blocks", func() bool { return indexer.Height() == 8 })
+	if value := counterValue(db, 2); value != `"value":20` {
+		t.Fatalf("unexpected value %q", value)
+	}
+
+	// The block 7 is replaced
+	source.Reorg(7)
+	source.AddLogs(counterLog(t, 7, 0, 3, 30))
+	source.SetHead(9)
+	waitFor(t, "the reorg", func() bool { return counterValue(db, 3) == `"value":30` && indexer.Height() == 9 })
+	if value := counterValue(db, 2); value != "" {
+		t.Fatalf("the row of the orphaned block still exists: %q", value)
+	}
+
+	// Every block is replaced, the chain is indexed again
+	source.Reorg(1)
+	source.AddLogs(registerTableLog(t, 2), counterLog(t, 3, 0, 4, 40))
+	source.SetHead(10)
+	waitFor(t, "the new chain", func() bool { return counterValue(db, 4) == `"value":40` && indexer.Height() == 10 })
+	for _, key := range []byte{1, 3} {
+		if value := counterValue(db, key); value != "" {
+			t.Fatalf("the row %d of the orphaned chain still exists: %q", key, value)
+		}
+	}
+
+	indexer.Stop()
+	if err := indexer.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
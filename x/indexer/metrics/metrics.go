@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bocha-io/garnet/x/indexer"
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth"
+)
+
+// Collector reads the indexer state on each scrape and writes it using the prometheus text format.
+// Every field is optional, the metrics of the missing ones are not exported.
+type Collector struct {
+	// Added as the chain label when several chains are indexed
+	Chain     string
+	Indexer   *indexer.Indexer
+	Database  *data.Database
+	RPC       *eth.InstrumentedSource
+	Endpoints *eth.EndpointPool
+}
+
+func NewCollector(idx *indexer.Indexer, database *data.Database, rpc *eth.InstrumentedSource, endpoints *eth.EndpointPool) *Collector {
+	return &Collector{Indexer: idx, Database: database, RPC: rpc, Endpoints: endpoints}
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type family struct {
+	header  string
+	samples []string
+}
+
+// writer groups the samples by metric so the collectors of several chains can share the output
+type writer struct {
+	families []*family
+	index    map[string]*family
+	current  *family
+	// Added to every sample
+	labels []label
+}
+
+func newWriter() *writer {
+	return &writer{families: []*family{}, index: map[string]*family{}}
+}
+
+func (w *writer) header(name string, metricType string, help string) {
+	if f, ok := w.index[name]; ok {
+		w.current = f
+		return
+	}
+	w.current = &family{header: fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType), samples: []string{}}
+	w.index[name] = w.current
+	w.families = append(w.families, w.current)
+}
+
+func (w *writer) sample(name string, value float64, labels ...label) {
+	labels = append(append([]label{}, w.labels...), labels...)
+
+	var line strings.Builder
+	line.WriteString(name)
+	if len(labels) > 0 {
+		line.WriteString("{")
+		for i, l := range labels {
+			if i > 0 {
+				line.WriteString(",")
+			}
+			fmt.Fprintf(&line, "%s=\"%s\"", l.name, escapeLabel(l.value))
+		}
+		line.WriteString("}")
+	}
+	line.WriteString(" ")
+	line.WriteString(formatValue(value))
+	line.WriteString("\n")
+	w.current.samples = append(w.current.samples, line.String())
+}
+
+func (w *writer) metric(name string, metricType string, help string, value float64) {
+	w.header(name, metricType, help)
+	w.sample(name, value)
+}
+
+func (w *writer) WriteTo(out io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+	for _, f := range w.families {
+		buf.WriteString(f.header)
+		for _, v := range f.samples {
+			buf.WriteString(v)
+		}
+	}
+	return buf.WriteTo(out)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func (c *Collector) writeIndexer(w *writer) {
+	w.metric("garnet_indexed_height", "gauge", "Last block applied to the database.", float64(c.Indexer.Height()))
+	w.metric("garnet_chain_head", "gauge", "Last known chain height.", float64(c.Indexer.Head()))
+	w.metric("garnet_lag_blocks", "gauge", "Blocks between the chain head and the indexed height.", float64(c.Indexer.Lag()))
+	w.metric("garnet_sync_error", "gauge", "1 if the last sync attempt failed.", boolToFloat(c.Indexer.LastError() != nil))
+}
+
+func (c *Collector) writeDatabase(w *writer) {
+	db := c.Database
+	info := db.Info()
+	w.metric("garnet_confirmed_height", "gauge", "Height considered final by the confirmation policy.", float64(info.ConfirmedHeight))
+	w.metric("garnet_last_update_timestamp_seconds", "gauge", "Unix time of the last database update.", float64(info.LastUpdate.UnixNano())/1e9)
+
+	stats := db.LogStats()
+	w.header("garnet_logs_total", "counter", "Store logs applied, by event type.")
+	for _, event := range sortedKeys(stats.Logs) {
+		w.sample("garnet_logs_total", float64(stats.Logs[event]), label{"event", event})
+	}
+	w.metric("garnet_decode_errors_total", "counter", "Store logs that could not be decoded.", float64(stats.DecodeErrors))
+	w.metric("garnet_apply_errors_total", "counter", "Decoded store logs that failed while updating the database.", float64(stats.ApplyErrors))
+	w.metric("garnet_dead_letters", "gauge", "Failed logs waiting to be retried.", float64(len(db.DeadLetters())))
+
+	w.metric("garnet_unconfirmed_transactions", "gauge", "Transactions sent by the game that are not confirmed yet.", float64(len(db.PendingTransactions())))
+	predictions := db.PredictionStats()
+	w.header("garnet_predictions_total", "counter", "Optimistic updates compared with the confirmed logs, by result.")
+	w.sample("garnet_predictions_total", float64(predictions.Matched), label{"result", "matched"})
+	w.sample("garnet_predictions_total", float64(predictions.Mismatched), label{"result", "mismatched"})
+
+	rows := db.RowsPerTable()
+	w.header("garnet_table_rows", "gauge", "Rows stored in each table.")
+	for _, world := range sortedKeys(rows) {
+		for _, table := range sortedKeys(rows[world]) {
+			w.sample("garnet_table_rows", float64(rows[world][table]), label{"world", world}, label{"table", table})
+		}
+	}
+}
+
+func (c *Collector) writeRPC(w *writer) {
+	stats := c.RPC.Stats()
+	methods := sortedKeys(stats)
+
+	w.header("garnet_rpc_requests_total", "counter", "Requests sent to the rpc endpoints, by method.")
+	for _, method := range methods {
+		w.sample("garnet_rpc_requests_total", float64(stats[method].Requests), label{"method", method})
+	}
+	w.header("garnet_rpc_errors_total", "counter", "Failed rpc requests, by method.")
+	for _, method := range methods {
+		w.sample("garnet_rpc_errors_total", float64(stats[method].Errors), label{"method", method})
+	}
+	w.header("garnet_rpc_latency_seconds", "histogram", "Latency of the rpc requests, by method.")
+	for _, method := range methods {
+		v := stats[method]
+		for i, bound := range eth.RPCLatencyBuckets {
+			w.sample("garnet_rpc_latency_seconds_bucket", float64(v.Buckets[i]), label{"method", method}, label{"le", formatValue(bound)})
+		}
+		w.sample("garnet_rpc_latency_seconds_bucket", float64(v.Requests), label{"method", method}, label{"le", "+Inf"})
+		w.sample("garnet_rpc_latency_seconds_sum", v.LatencySum, label{"method", method})
+		w.sample("garnet_rpc_latency_seconds_count", float64(v.Requests), label{"method", method})
+	}
+}
+
+func (c *Collector) writeEndpoints(w *writer) {
+	status := c.Endpoints.Status()
+
+	w.header("garnet_endpoint_up", "gauge", "0 if the endpoint is marked as down after consecutive errors.")
+	for _, v := range status {
+		w.sample("garnet_endpoint_up", boolToFloat(!v.Down), label{"endpoint", v.URL})
+	}
+	w.header("garnet_endpoint_latency_seconds", "gauge", "Moving average of the endpoint latency.")
+	for _, v := range status {
+		w.sample("garnet_endpoint_latency_seconds", v.Latency.Seconds(), label{"endpoint", v.URL})
+	}
+	w.header("garnet_endpoint_consecutive_errors", "gauge", "Consecutive failed requests of the endpoint.")
+	for _, v := range status {
+		w.sample("garnet_endpoint_consecutive_errors", float64(v.Errors), label{"endpoint", v.URL})
+	}
+	w.header("garnet_endpoint_lag_blocks", "gauge", "Blocks between the endpoint head and the best known head.")
+	for _, v := range status {
+		w.sample("garnet_endpoint_lag_blocks", float64(v.Lag), label{"endpoint", v.URL})
+	}
+}
+
+func (c *Collector) write(w *writer) {
+	w.labels = []label{}
+	if c.Chain != "" {
+		w.labels = append(w.labels, label{"chain", c.Chain})
+	}
+	if c.Indexer != nil {
+		c.writeIndexer(w)
+	}
+	if c.Database != nil {
+		c.writeDatabase(w)
+	}
+	if c.RPC != nil {
+		c.writeRPC(w)
+	}
+	if c.Endpoints != nil {
+		c.writeEndpoints(w)
+	}
+}
+
+// WriteTo writes every available metric using the prometheus text format
+func (c *Collector) WriteTo(out io.Writer) (int64, error) {
+	w := newWriter()
+	c.write(w)
+	return w.WriteTo(out)
+}
+
+// Collectors exports the metrics of several chains, each collector should have a different chain name
+type Collectors []*Collector
+
+func (c Collectors) WriteTo(out io.Writer) (int64, error) {
+	w := newWriter()
+	for _, v := range c {
+		v.write(w)
+	}
+	return w.WriteTo(out)
+}
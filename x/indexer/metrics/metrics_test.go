@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bocha-io/garnet/x/indexer"
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/eth"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func scrape(t *testing.T, url string) string {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	return string(body)
+}
+
+// TestConcurrentScrape is meant to be run with the race detector, the metrics are read while the indexer and a writer modify the database
+func TestConcurrentScrape(t *testing.T) {
+	chain := eth.NewMemorySource(1)
+	chain.SetHead(10)
+	source := eth.NewInstrumentedSource(chain)
+	db := data.NewDatabase()
+	endpoints, err := eth.NewEndpointPool([]string{"ws://127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := indexer.DefaultOptions()
+	options.PollInterval = time.Millisecond
+	idx := indexer.NewIndexer(source, db, options)
+	if err := idx.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		idx.Stop()
+		if err := idx.Wait(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	collector := NewCollector(idx, db, source, endpoints)
+	collector.Chain = "test"
+	server := httptest.NewServer(Collectors{collector})
+	defer server.Close()
+
+	writes := 200
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writes; i++ {
+			// New tables are registered while the scrapes list them
+			table := db.GetTable("0x01", fmt.Sprintf("0x%064x", i%20))
+			db.Update(func() {
+				table.Metadata.TableName = fmt.Sprintf("Table%d", i%20)
+				db.AddRow(table, []byte{byte(i)}, &[]data.Field{{Key: "value", Data: data.NewUintFieldFromNumber(int64(i))}})
+				db.AddDeadLetter(chainLog(i), fmt.Errorf("error %d", i))
+			})
+			chain.SetHead(uint64(10 + i))
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				scrape(t, server.URL)
+			}
+		}()
+	}
+	wg.Wait()
+
+	body := scrape(t, server.URL)
+	for _, expected := range []string{
+		fmt.Sprintf(`garnet_dead_letters{chain="test"} %d`, writes),
+		`garnet_table_rows{chain="test",world="0x01",table="Table0"} 10`,
+		`garnet_rpc_requests_total{chain="test",method="eth_blockNumber"}`,
+		`garnet_endpoint_up{chain="test",endpoint="ws://127.0.0.1:1"} 1`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("the metrics do not contain %s:\n%s", expected, body)
+		}
+	}
+}
+
+func chainLog(index int) types.Log {
+	return types.Log{BlockNumber: uint64(index), Topics: []common.Hash{}, Data: []byte{}}
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bocha-io/logger"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+func (c Collectors) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	if _, err := c.WriteTo(w); err != nil {
+		logger.LogError(fmt.Sprintf("[indexer] error writing the metrics: %s", err))
+	}
+}
+
+// Serve exposes the metrics at http://address/metrics until the context is cancelled
+func Serve(ctx context.Context, address string, collectors ...*Collector) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Collectors(collectors))
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	logger.LogInfo(fmt.Sprintf("[indexer] serving metrics at http://%s/metrics", address))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -1,14 +1,15 @@
 package indexer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/big"
 	"time"
 
-	"github.com/bocha-io/ethclient/x/ethclient"
-	"github.com/bocha-io/garnet/x/indexer/data"
 	"github.com/bocha-io/garnet/x/indexer/eth"
 	"github.com/bocha-io/logger"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 // func ProcessMempool(database *data.Database, quit *bool) {
@@ -17,39 +18,229 @@ import (
 //     c.PendingTransactionCount()
 // }
 
-func Process(client *ethclient.EthClient, database *data.Database, quit *bool, startingHeight uint64, sleepDuration time.Duration) {
+const (
+	backfillWorkers = 8
+	// Amount of batches synced by each backfill call
+	backfillChunk = 100
+
+	// Amount of blocks requested with each eth_getLogs call
+	initialWindowSize = 500
+	maxWindowSize     = 2000
+)
+
+func logBackfillProgress(p eth.BackfillProgress) {
+	logger.LogInfo(fmt.Sprintf("[indexer] backfill %d/%d (%.2f%%), %d logs, %.0f blocks/s", p.Height, p.To, p.Percentage(), p.Logs, p.BlocksPerSecond()))
+}
+
+// sleep returns false if the context was cancelled while waiting
+func sleep(ctx context.Context, duration time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(duration):
+		return true
+	}
+}
+
+func (i *Indexer) logError(message string, err error) {
+	logger.LogError(fmt.Sprintf("[indexer] %s: %s", message, err))
+	i.setError(err)
+}
+
+func (i *Indexer) checkPending(ctx context.Context, receipts eth.ReceiptReader, head uint64, nextHeight uint64) {
+	processedHeight := uint64(0)
+	if nextHeight > 0 {
+		processedHeight = nextHeight - 1
+	}
+	if err := eth.CheckPendingTransactions(ctx, receipts, i.Database, i.options.Pending, head, processedHeight); err != nil && ctx.Err() == nil {
+		i.logError("error checking the pending transactions", err)
+	}
+}
+
+// resync drops the indexed state when the tracked blocks were orphaned, the chain is indexed again from the starting height
+func (i *Indexer) resync(err error) {
+	i.logError(fmt.Sprintf("the tracked blocks are not canonical anymore, indexing again from block %d", i.options.StartingHeight), err)
+	i.Database.Reset()
+}
+
+func (i *Indexer) process(ctx context.Context) error {
+	source := i.Source
+	database := i.Database
+	options := i.options
+	checkpoint := options.Checkpoint
+
 	logger.LogInfo("indexer is starting...")
-	database.ChainID = client.ChainID().String()
+	chainID := ""
+	for {
+		id, err := source.ChainID(ctx)
+		if err == nil {
+			chainID = id.String()
+			break
+		}
+		i.logError("error getting the chain id", err)
+		if !sleep(ctx, options.PollInterval) {
+			return nil
+		}
+	}
+
+	window := eth.NewAdaptiveWindow(options.BatchSize, 1, options.MaxBatchSize)
+	nextHeight := options.StartingHeight
 
-	height := client.BlockNumber()
+	if checkpoint != nil {
+		if _, err := checkpoint.Load(database); err != nil {
+			i.logError(fmt.Sprintf("error loading the checkpoint, starting from block %d", options.StartingHeight), err)
+		}
+	}
+	// New replicas are bootstrapped from the snapshot instead of indexing the whole chain
+	if options.Snapshots != nil && database.LastProcessedBlock() == nil {
+		if _, err := options.Snapshots.Load(database); err != nil {
+			i.logError(fmt.Sprintf("error loading the snapshots, starting from block %d", options.StartingHeight), err)
+		}
+	}
+
+	// Resume from the block restored by the checkpoint, the snapshot or the storage if it is still part of the chain
+	if database.LastProcessedBlock() != nil {
+		for {
+			valid, err := eth.ValidateCheckpoint(ctx, source, database, chainID)
+			if err != nil {
+				i.logError("error validating the checkpoint", err)
+				if !sleep(ctx, options.PollInterval) {
+					return nil
+				}
+				continue
+			}
+			if valid {
+				nextHeight = database.LastProcessedBlock().Height + 1
+			}
+			break
+		}
+	}
+	database.SetChainID(chainID)
+	i.setProgress(nextHeight, 0)
 
-	endHeight := height
-	amountOfBlocks := uint64(500)
+	// Used to discard the predictions of reverted transactions
+	receipts, _ := source.(eth.ReceiptReader)
 
-	if height > startingHeight+amountOfBlocks {
-		endHeight = startingHeight + amountOfBlocks
+	// Websocket endpoints push the new blocks, http endpoints are polled
+	subscriber, streaming := source.(eth.Subscriber)
+	if options.Confirmations.ConfirmedOnly {
+		// The streamed blocks are applied as soon as they are received
+		streaming = false
 	}
 
-	eth.ProcessBlocks(client, database, big.NewInt(int64(startingHeight)), big.NewInt(int64(endHeight)))
+	for ctx.Err() == nil {
+		// The stored state does not match the applied blocks after a failed write
+		if err := database.Err(); err != nil {
+			i.logError("error writing to the storage, stopping the indexer", err)
+			return err
+		}
+
+		newHeight, err := source.BlockNumber(ctx)
+		if err != nil {
+			i.logError("error getting the chain height", err)
+			sleep(ctx, options.PollInterval)
+			continue
+		}
+
+		// Only look for reorgs if the chain head moved since the last processed block
+		if nextHeight != newHeight+1 {
+			ancestor, reorged, err := eth.HandleReorg(ctx, source, database, newHeight)
+			if errors.Is(err, eth.ErrReorgTooDeep) {
+				i.resync(err)
+				nextHeight = options.StartingHeight
+				i.setProgress(nextHeight, newHeight)
+				continue
+			}
+			if err != nil {
+				i.logError("error checking for chain reorgs", err)
+				sleep(ctx, options.PollInterval)
+				continue
+			}
+			if reorged {
+				nextHeight = ancestor + 1
+			}
+		}
+
+		targetHeight := newHeight
+		if options.Confirmations.ConfirmedOnly {
+			confirmed, err := options.Confirmations.ConfirmedHeight(ctx, source, newHeight)
+			if err != nil {
+				i.logError("error getting the confirmed height", err)
+				sleep(ctx, options.PollInterval)
+				continue
+			}
+			targetHeight = confirmed
+		}
+
+		// Sync the old blocks using concurrent requests, the loop is kept to be able to quit between chunks
+		if targetHeight > nextHeight+window.Size() {
+			endHeight := targetHeight
+			if targetHeight > nextHeight+window.Size()*backfillChunk {
+				endHeight = nextHeight + window.Size()*backfillChunk
+			}
 
-	for !*quit {
-		newHeight := client.BlockNumber()
+			nextHeight, err = eth.Backfill(ctx, source, database, options.Filter, window, options.FetchTimestamps, nextHeight, endHeight, backfillWorkers, logBackfillProgress)
+			if err != nil && ctx.Err() == nil {
+				i.logError("error backfilling blocks", err)
+			}
+		}
 
-		if newHeight != endHeight {
-			startingHeight = endHeight
-			endHeight = newHeight
+		if targetHeight >= nextHeight && ctx.Err() == nil {
+			endHeight := targetHeight
+			if targetHeight > nextHeight+window.Size() {
+				endHeight = nextHeight + window.Size()
+			}
 
-			if newHeight > startingHeight+amountOfBlocks {
-				endHeight = startingHeight + amountOfBlocks
+			logger.LogInfo(fmt.Sprintf("Heights: %d %d", nextHeight, endHeight))
+
+			if err := eth.ProcessBlocks(ctx, source, database, options.Filter, window, options.FetchTimestamps, new(big.Int).SetUint64(nextHeight), new(big.Int).SetUint64(endHeight)); err != nil {
+				if ctx.Err() == nil {
+					i.logError("error processing blocks", err)
+				}
+			} else {
+				nextHeight = endHeight + 1
 			}
+		}
+
+		database.SetLastHeight(newHeight)
+		i.setProgress(nextHeight, newHeight)
 
-			logger.LogInfo(fmt.Sprintf("Heights: %d %d", startingHeight, endHeight))
+		if err := eth.UpdateConfirmedHeight(ctx, source, database, options.Confirmations, newHeight); err != nil && ctx.Err() == nil {
+			i.logError("error updating the confirmed height", err)
+		}
+
+		i.checkPending(ctx, receipts, newHeight, nextHeight)
 
-			eth.ProcessBlocks(client, database, big.NewInt(int64(startingHeight)), big.NewInt(int64(endHeight)))
+		checkpoint.SaveIfDue(database)
+		options.Snapshots.SaveIfDue(database)
+
+		if streaming && nextHeight > newHeight {
+			nextHeight, err = eth.StreamBlocks(ctx, source, subscriber, database, options.Filter, window, options.FetchTimestamps, nextHeight, options.Confirmations, checkpoint, func(nextHeight uint64, head uint64) {
+				i.setProgress(nextHeight, head)
+				i.checkPending(ctx, receipts, head, nextHeight)
+				options.Snapshots.SaveIfDue(database)
+			})
+			if errors.Is(err, eth.ErrReorgTooDeep) {
+				i.resync(err)
+				nextHeight = options.StartingHeight
+				continue
+			}
+			if errors.Is(err, rpc.ErrNotificationsUnsupported) {
+				logger.LogInfo("[indexer] the endpoint does not support subscriptions, polling for new blocks")
+				streaming = false
+			} else if err != nil && ctx.Err() == nil {
+				i.logError("error streaming blocks, polling for new blocks", err)
+			}
 		}
 
-		database.LastHeight = newHeight
+		sleep(ctx, options.PollInterval)
+	}
 
-		time.Sleep(sleepDuration)
+	if checkpoint != nil {
+		if err := checkpoint.Save(database); err != nil {
+			i.logError("error saving the checkpoint", err)
+			return err
+		}
 	}
+	return nil
 }
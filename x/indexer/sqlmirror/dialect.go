@@ -0,0 +1,86 @@
+package sqlmirror
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+)
+
+// Dialect has the differences between the supported sql databases, the statements use the common
+// sql syntax plus `INSERT ... ON CONFLICT DO UPDATE` and `ALTER TABLE ... RENAME COLUMN`
+type Dialect interface {
+	Name() string
+	// ColumnType returns the sql type used to store the mud type
+	ColumnType(schemaType mudhelpers.SchemaType) string
+	// Table returns the quoted name of a table of the namespace
+	Table(namespace string, name string) string
+	// CreateNamespace returns the statements needed before creating the tables of a namespace
+	CreateNamespace(namespace string) []string
+	// Placeholder returns the parameter number n, starting at 1
+	Placeholder(n int) string
+}
+
+// Quote returns the identifier between double quotes, used by both dialects
+func Quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+type postgres struct{}
+
+// Postgres creates a schema per namespace, the tables keep the on chain name
+var Postgres Dialect = postgres{}
+
+func (postgres) Name() string {
+	return "postgres"
+}
+
+func (postgres) ColumnType(schemaType mudhelpers.SchemaType) string {
+	return mudhelpers.SchemaTypeToPostgresType(schemaType)
+}
+
+func (postgres) Table(namespace string, name string) string {
+	return Quote(namespace) + "." + Quote(name)
+}
+
+func (postgres) CreateNamespace(namespace string) []string {
+	return []string{"CREATE SCHEMA IF NOT EXISTS " + Quote(namespace)}
+}
+
+func (postgres) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+type sqlite struct{}
+
+// SQLite has no schemas, the namespace is added to the table name. Used as an embedded database,
+// it works with any registered driver (ie. modernc.org/sqlite or github.com/mattn/go-sqlite3)
+var SQLite Dialect = sqlite{}
+
+func (sqlite) Name() string {
+	return "sqlite"
+}
+
+func (sqlite) ColumnType(schemaType mudhelpers.SchemaType) string {
+	switch mudhelpers.SchemaTypeToPostgresType(schemaType) {
+	case "integer", "bigint":
+		return "INTEGER"
+	case "boolean":
+		return "BOOLEAN"
+	default:
+		// The arrays are stored as json text
+		return "TEXT"
+	}
+}
+
+func (sqlite) Table(namespace string, name string) string {
+	return Quote(namespace + mudhelpers.CONNECTOR + name)
+}
+
+func (sqlite) CreateNamespace(string) []string {
+	return []string{}
+}
+
+func (sqlite) Placeholder(int) string {
+	return "?"
+}
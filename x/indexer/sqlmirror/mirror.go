@@ -0,0 +1,351 @@
+package sqlmirror
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/bocha-io/logger"
+)
+
+// KeyColumn is the primary key of every mirrored table, it has the hex encoded key tuple used by the database
+const KeyColumn = "__key"
+
+// CatalogTable keeps the schema of the mirrored tables, used to drop them after a restart
+var CatalogTable = mudhelpers.TABLE_PREFIX + mudhelpers.CONNECTOR + "tables"
+
+type column struct {
+	name       string
+	schemaType mudhelpers.SchemaType
+}
+
+// Mirror is a data.Sink that keeps one sql table per mud table, with a column for each key and field.
+// The tables are created in the namespace of their world (mudhelpers.Namespace).
+type Mirror struct {
+	db      *sql.DB
+	dialect Dialect
+	// Identifies the mirror in the catalog, several chains can use the same sql database
+	name string
+	// Mirrored tables by world and table id
+	tables map[string]*mudhelpers.TableSchema
+}
+
+func NewMirror(db *sql.DB, dialect Dialect, name string) (*Mirror, error) {
+	m := &Mirror{db: db, dialect: dialect, name: name, tables: map[string]*mudhelpers.TableSchema{}}
+
+	_, err := db.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (mirror TEXT NOT NULL, world TEXT NOT NULL, table_id TEXT NOT NULL, definition TEXT NOT NULL, PRIMARY KEY (mirror, world, table_id))",
+		Quote(CatalogTable),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error creating the sql catalog: %w", err)
+	}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT world, table_id, definition FROM %s WHERE mirror = %s", Quote(CatalogTable), dialect.Placeholder(1)), name)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the sql catalog: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var world, tableID, definition string
+		if err := rows.Scan(&world, &tableID, &definition); err != nil {
+			return nil, fmt.Errorf("error reading the sql catalog: %w", err)
+		}
+		schema := &mudhelpers.TableSchema{}
+		if err := json.Unmarshal([]byte(definition), schema); err != nil {
+			return nil, fmt.Errorf("error decoding the sql table %s: %w", tableID, err)
+		}
+		m.tables[catalogID(world, tableID)] = schema
+	}
+	return m, rows.Err()
+}
+
+func catalogID(world string, tableID string) string {
+	return strings.ToLower(world) + "/" + tableID
+}
+
+// sqlTableName uses the on chain name (namespace__name) when it is known
+func sqlTableName(table *data.Table) string {
+	if table.Metadata.OnChainTableName != "" {
+		return table.Metadata.OnChainTableName
+	}
+	if table.Metadata.TableName != "" {
+		return table.Metadata.TableName
+	}
+	return table.Metadata.TableID
+}
+
+// columnName returns a name that is not used by the key column or a previous column, the sql names are not case sensitive
+func columnName(used map[string]bool, names []string, index int, defaultName func(int) string) string {
+	name := defaultName(index)
+	if index < len(names) && names[index] != "" {
+		name = names[index]
+	}
+	unique := name
+	for suffix := 1; used[strings.ToLower(unique)]; suffix++ {
+		unique = fmt.Sprintf("%s_%d", name, suffix)
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+// TableSchema describes the sql table used to mirror the table
+func TableSchema(chainID string, table *data.Table) *mudhelpers.TableSchema {
+	schema := &mudhelpers.TableSchema{
+		TableId:               table.Metadata.TableID,
+		TableName:             sqlTableName(table),
+		FieldNames:            []string{},
+		KeyNames:              []string{},
+		SolidityTypes:         map[string]string{},
+		PostgresTypes:         map[string]string{},
+		IsKey:                 map[string]bool{},
+		Namespace:             mudhelpers.Namespace(chainID, table.Metadata.WorldAddress),
+		StoreCoreSchemaTypeKV: table.Schema.Schema,
+		PrimaryKey:            KeyColumn,
+		OnChainReadableName:   table.Metadata.TableName,
+		OnChainColNames:       map[string]string{},
+	}
+	used := map[string]bool{strings.ToLower(KeyColumn): true}
+	for i, schemaType := range schemaTypes(table.Schema.Schema.Key) {
+		name := columnName(used, *table.Schema.KeyNames, i, mudhelpers.DefaultKeyName)
+		schema.KeyNames = append(schema.KeyNames, name)
+		schema.SolidityTypes[name] = mudhelpers.SchemaTypeToSolidityType(schemaType)
+		schema.PostgresTypes[name] = mudhelpers.SchemaTypeToPostgresType(schemaType)
+		schema.IsKey[name] = true
+	}
+	for i, schemaType := range schemaTypes(table.Schema.Schema.Value) {
+		name := columnName(used, *table.Schema.FieldNames, i, mudhelpers.DefaultFieldName)
+		schema.FieldNames = append(schema.FieldNames, name)
+		schema.SolidityTypes[name] = mudhelpers.SchemaTypeToSolidityType(schemaType)
+		schema.PostgresTypes[name] = mudhelpers.SchemaTypeToPostgresType(schemaType)
+		schema.IsKey[name] = false
+	}
+	return schema
+}
+
+// columns returns the keys and then the fields
+func columns(schema *mudhelpers.TableSchema) []column {
+	ret := []column{}
+	for i, schemaType := range schemaTypes(schema.StoreCoreSchemaTypeKV.Key) {
+		ret = append(ret, column{name: schema.KeyNames[i], schemaType: schemaType})
+	}
+	for i, schemaType := range schemaTypes(schema.StoreCoreSchemaTypeKV.Value) {
+		ret = append(ret, column{name: schema.FieldNames[i], schemaType: schemaType})
+	}
+	return ret
+}
+
+func (m *Mirror) table(schema *mudhelpers.TableSchema) string {
+	return m.dialect.Table(schema.Namespace, schema.TableName)
+}
+
+// Apply writes the batch in a single sql transaction
+func (m *Mirror) Apply(batch data.SinkBatch) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// The known tables are updated once the transaction is committed
+	tables := map[string]*mudhelpers.TableSchema{}
+	for k, v := range m.tables {
+		tables[k] = v
+	}
+
+	if batch.Reset {
+		for id, schema := range tables {
+			if _, err := tx.Exec("DROP TABLE IF EXISTS " + m.table(schema)); err != nil {
+				return err
+			}
+			delete(tables, id)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE mirror = %s", Quote(CatalogTable), m.dialect.Placeholder(1)), m.name); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range batch.Tables {
+		if err := m.rewrite(tx, tables, batch.ChainID, table); err != nil {
+			return fmt.Errorf("error writing the table %s: %w", sqlTableName(table.Table), err)
+		}
+	}
+
+	for _, change := range batch.Changes {
+		schema, ok := tables[catalogID(change.Table.Metadata.WorldAddress, change.Table.Metadata.TableID)]
+		if !ok {
+			return fmt.Errorf("the table %s was not created", sqlTableName(change.Table))
+		}
+		if change.Fields == nil {
+			_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = %s", m.table(schema), Quote(KeyColumn), m.dialect.Placeholder(1)), change.Key)
+		} else {
+			err = m.upsert(tx, schema, change.Key, change.Fields)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing the row %s of table %s: %w", change.Key, schema.TableName, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.tables = tables
+	return nil
+}
+
+// sameTable is true if the table can be kept, only the column names changed
+func sameTable(previous *mudhelpers.TableSchema, schema *mudhelpers.TableSchema) bool {
+	if previous.Namespace != schema.Namespace || previous.TableName != schema.TableName {
+		return false
+	}
+	previousColumns := columns(previous)
+	newColumns := columns(schema)
+	if len(previous.KeyNames) != len(schema.KeyNames) || len(previousColumns) != len(newColumns) {
+		return false
+	}
+	for i := range newColumns {
+		if previousColumns[i].schemaType != newColumns[i].schemaType {
+			return false
+		}
+	}
+	return true
+}
+
+// rewrite creates the table (or renames its columns) and replaces its rows
+func (m *Mirror) rewrite(tx *sql.Tx, tables map[string]*mudhelpers.TableSchema, chainID string, table data.SinkTable) error {
+	id := catalogID(table.Table.Metadata.WorldAddress, table.Table.Metadata.TableID)
+	schema := TableSchema(chainID, table.Table)
+	name := m.table(schema)
+
+	if previous, ok := tables[id]; ok && sameTable(previous, schema) {
+		if err := m.renameColumns(tx, name, columns(previous), columns(schema)); err != nil {
+			return err
+		}
+	} else {
+		if ok {
+			if _, err := tx.Exec("DROP TABLE IF EXISTS " + m.table(previous)); err != nil {
+				return err
+			}
+		}
+		for _, statement := range m.dialect.CreateNamespace(schema.Namespace) {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		// A table that is not in the catalog was left by a previous run
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + name); err != nil {
+			return err
+		}
+		definitions := []string{Quote(KeyColumn) + " TEXT PRIMARY KEY"}
+		for _, c := range columns(schema) {
+			definitions = append(definitions, Quote(c.name)+" "+m.dialect.ColumnType(c.schemaType))
+		}
+		if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", name, strings.Join(definitions, ", "))); err != nil {
+			return err
+		}
+		logger.LogInfo(fmt.Sprintf("[indexer] created the sql table %s", name))
+	}
+
+	if _, err := tx.Exec("DELETE FROM " + name); err != nil {
+		return err
+	}
+	for key, fields := range table.Rows {
+		if err := m.upsert(tx, schema, key, fields); err != nil {
+			return fmt.Errorf("error writing the row %s: %w", key, err)
+		}
+	}
+
+	definition, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		fmt.Sprintf("DELETE FROM %s WHERE mirror = %s AND world = %s AND table_id = %s", Quote(CatalogTable), m.dialect.Placeholder(1), m.dialect.Placeholder(2), m.dialect.Placeholder(3)),
+		m.name, strings.ToLower(table.Table.Metadata.WorldAddress), table.Table.Metadata.TableID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		fmt.Sprintf("INSERT INTO %s (mirror, world, table_id, definition) VALUES (%s, %s, %s, %s)", Quote(CatalogTable), m.dialect.Placeholder(1), m.dialect.Placeholder(2), m.dialect.Placeholder(3), m.dialect.Placeholder(4)),
+		m.name, strings.ToLower(table.Table.Metadata.WorldAddress), table.Table.Metadata.TableID, string(definition),
+	); err != nil {
+		return err
+	}
+	tables[id] = schema
+	return nil
+}
+
+// renameColumns uses temporary names first, the new names may be used by other columns
+func (m *Mirror) renameColumns(tx *sql.Tx, name string, previous []column, renamed []column) error {
+	changed := []int{}
+	for i := range renamed {
+		if previous[i].name != renamed[i].name {
+			changed = append(changed, i)
+		}
+	}
+	for _, i := range changed {
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", name, Quote(previous[i].name), Quote(fmt.Sprintf("__renamed_%d", i)))); err != nil {
+			return err
+		}
+	}
+	for _, i := range changed {
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", name, Quote(fmt.Sprintf("__renamed_%d", i)), Quote(renamed[i].name))); err != nil {
+			return err
+		}
+		logger.LogInfo(fmt.Sprintf("[indexer] renamed the column %s of the sql table %s to %s", previous[i].name, name, renamed[i].name))
+	}
+	return nil
+}
+
+// upsert writes the row, the fields are matched by position because the names may change inside a block
+func (m *Mirror) upsert(tx *sql.Tx, schema *mudhelpers.TableSchema, key string, fields []data.Field) error {
+	names := []string{Quote(KeyColumn)}
+	values := []interface{}{key}
+
+	keyColumns := schemaTypes(schema.StoreCoreSchemaTypeKV.Key)
+	keys, err := keyValues(schema.StoreCoreSchemaTypeKV.Key, key)
+	if err != nil {
+		// The key columns are left empty, the row is still identified by the key column
+		logger.LogDebug(fmt.Sprintf("[indexer] could not decode the key of the sql table %s: %s", schema.TableName, err))
+		keys = make([]interface{}, len(keyColumns))
+	}
+	for i := range keyColumns {
+		names = append(names, Quote(schema.KeyNames[i]))
+		values = append(values, keys[i])
+	}
+
+	for i, schemaType := range schemaTypes(schema.StoreCoreSchemaTypeKV.Value) {
+		var value interface{}
+		if i < len(fields) {
+			if value, err = columnValue(schemaType, fields[i].Data); err != nil {
+				return fmt.Errorf("field %s: %w", schema.FieldNames[i], err)
+			}
+		}
+		names = append(names, Quote(schema.FieldNames[i]))
+		values = append(values, value)
+	}
+
+	placeholders := []string{}
+	updates := []string{}
+	for i, name := range names {
+		placeholders = append(placeholders, m.dialect.Placeholder(i+1))
+		if i > 0 {
+			updates = append(updates, name+" = excluded."+name)
+		}
+	}
+	conflict := "DO NOTHING"
+	if len(updates) > 0 {
+		conflict = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s",
+		m.table(schema), strings.Join(names, ", "), strings.Join(placeholders, ", "), Quote(KeyColumn), conflict,
+	), values...)
+	return err
+}
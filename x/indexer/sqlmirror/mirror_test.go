@@ -0,0 +1,185 @@
+//go:build cgo && sqlite
+
+// The mirror tests use the cgo sqlite driver, run them with: go test -tags sqlite ./x/indexer/sqlmirror
+
+package sqlmirror
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/ethereum/go-ethereum/common"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	sqlDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "mirror.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+	return sqlDB
+}
+
+// mirrored returns a database mirrored in sqlite, the table is created by the first batch
+func mirrored(t *testing.T, sqlDB *sql.DB) (*data.Database, *data.Table) {
+	db := data.NewDatabase()
+	table := db.GetTable(testWorld, "0x7462000000000000000000000000000000000000000000000000000000000001")
+	fieldNames := []string{"value", "name"}
+	keyNames := []string{"id"}
+	table.Schema.Schema = &mudhelpers.SchemaTypeKV{
+		Key:   &mudhelpers.SchemaTypePair{Static: []mudhelpers.SchemaType{mudhelpers.UINT32}, Dynamic: []mudhelpers.SchemaType{}, StaticDataLength: 4},
+		Value: &mudhelpers.SchemaTypePair{Static: []mudhelpers.SchemaType{mudhelpers.UINT32}, Dynamic: []mudhelpers.SchemaType{mudhelpers.STRING}, StaticDataLength: 4},
+	}
+	table.Schema.FieldNames = &fieldNames
+	table.Schema.KeyNames = &keyNames
+	table.Metadata.TableName = "Counter"
+
+	mirror, err := NewMirror(sqlDB, SQLite, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetSink(mirror)
+	t.Cleanup(func() {
+		db.SetSink(nil)
+	})
+	return db, table
+}
+
+func setRow(db *data.Database, table *data.Table, height uint64, id byte, value int64, name string) {
+	db.BeginBlock(height, common.Hash{31: byte(height)})
+	db.AddRow(table, common.Hash{31: id}.Bytes(), &[]data.Field{
+		{Key: (*table.Schema.FieldNames)[0], Data: data.NewUintFieldFromNumber(value)},
+		{Key: (*table.Schema.FieldNames)[1], Data: data.NewStringFieldFromValue(name)},
+	})
+	db.EndBlock()
+}
+
+func waitSink(t *testing.T, db *data.Database) {
+	t.Helper()
+	if err := db.WaitSink(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// queryRows returns the columns of every row ordered by key
+func queryRows(t *testing.T, sqlDB *sql.DB, query string) [][]interface{} {
+	t.Helper()
+	rows, err := sqlDB.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := [][]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(names))
+		pointers := make([]interface{}, len(names))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, values)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func expectRows(t *testing.T, rows [][]interface{}, expected [][]interface{}) {
+	t.Helper()
+	if len(rows) != len(expected) {
+		t.Fatalf("expected %d rows, got %v", len(expected), rows)
+	}
+	for i := range expected {
+		for j := range expected[i] {
+			value := rows[i][j]
+			if bytes, ok := value.([]byte); ok {
+				value = string(bytes)
+			}
+			if value != expected[i][j] {
+				t.Errorf("row %d column %d is %v instead of %v", i, j, value, expected[i][j])
+			}
+		}
+	}
+}
+
+func TestMirrorCreateUpsertDelete(t *testing.T) {
+	sqlDB := openSQLite(t)
+	db, table := mirrored(t, sqlDB)
+	setRow(db, table, 1, 1, 10, "one")
+	setRow(db, table, 2, 2, 20, "two")
+	waitSink(t, db)
+
+	name := SQLite.Table(mudhelpers.Namespace("", testWorld), "Counter")
+	query := `SELECT "__key", "id", "value", "name" FROM ` + name + ` ORDER BY "__key"`
+	expectRows(t, queryRows(t, sqlDB, query), [][]interface{}{
+		{common.Hash{31: 1}.Hex(), int64(1), int64(10), "one"},
+		{common.Hash{31: 2}.Hex(), int64(2), int64(20), "two"},
+	})
+
+	// The existing row is updated in place
+	setRow(db, table, 3, 1, 11, "uno")
+	waitSink(t, db)
+	expectRows(t, queryRows(t, sqlDB, query), [][]interface{}{
+		{common.Hash{31: 1}.Hex(), int64(1), int64(11), "uno"},
+		{common.Hash{31: 2}.Hex(), int64(2), int64(20), "two"},
+	})
+
+	db.BeginBlock(4, common.Hash{31: 4})
+	db.DeleteRow(table, common.Hash{31: 2}.Bytes())
+	db.EndBlock()
+	waitSink(t, db)
+	expectRows(t, queryRows(t, sqlDB, query), [][]interface{}{
+		{common.Hash{31: 1}.Hex(), int64(1), int64(11), "uno"},
+	})
+
+	// The catalog keeps the table after a restart
+	mirror, err := NewMirror(sqlDB, SQLite, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mirror.tables) != 1 {
+		t.Fatalf("unexpected catalog %v", mirror.tables)
+	}
+}
+
+func TestMirrorRenameColumns(t *testing.T) {
+	sqlDB := openSQLite(t)
+	db, table := mirrored(t, sqlDB)
+	setRow(db, table, 1, 1, 10, "one")
+	waitSink(t, db)
+
+	// The fields swap their names, the rows are kept
+	db.BeginBlock(2, common.Hash{31: 2})
+	fieldNames := []string{"name", "value"}
+	db.RewriteRows(table, func(fields []data.Field) []data.Field {
+		for i := range fields {
+			fields[i].Key = fieldNames[i]
+		}
+		return fields
+	})
+	db.UpdateTable(table, func(_ *data.TableMetadata, schema *data.TableSchema) {
+		schema.FieldNames = &fieldNames
+	})
+	db.EndBlock()
+	setRow(db, table, 3, 2, 20, "two")
+	waitSink(t, db)
+
+	name := SQLite.Table(mudhelpers.Namespace("", testWorld), "Counter")
+	expectRows(t, queryRows(t, sqlDB, `SELECT "id", "name", "value" FROM `+name+` ORDER BY "__key"`), [][]interface{}{
+		{int64(1), int64(10), "one"},
+		{int64(2), int64(20), "two"},
+	})
+}
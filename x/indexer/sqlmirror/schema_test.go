@@ -0,0 +1,35 @@
+package sqlmirror
+
+import (
+	"testing"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+)
+
+const testWorld = "0x01"
+
+func TestTableSchemaUniqueColumns(t *testing.T) {
+	table := &data.Table{
+		Metadata: &data.TableMetadata{TableID: "0x01", WorldAddress: testWorld, TableName: "Table"},
+		Schema: &data.TableSchema{
+			KeyNames:   &[]string{"__key"},
+			FieldNames: &[]string{"Value", "value", ""},
+			Schema: &mudhelpers.SchemaTypeKV{
+				Key:   &mudhelpers.SchemaTypePair{Static: []mudhelpers.SchemaType{mudhelpers.UINT32}, Dynamic: []mudhelpers.SchemaType{}},
+				Value: &mudhelpers.SchemaTypePair{Static: []mudhelpers.SchemaType{mudhelpers.UINT32, mudhelpers.UINT32, mudhelpers.UINT32}, Dynamic: []mudhelpers.SchemaType{}},
+			},
+		},
+	}
+	schema := TableSchema("", table)
+	if schema.KeyNames[0] != "__key_1" {
+		t.Errorf("the key column uses the primary key name: %v", schema.KeyNames)
+	}
+	expected := []string{"Value", "value_1", "field_2"}
+	for i, name := range expected {
+		if schema.FieldNames[i] != name {
+			t.Errorf("unexpected field names %v", schema.FieldNames)
+			break
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package sqlmirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/bocha-io/garnet/x/indexer/data"
+	"github.com/bocha-io/garnet/x/indexer/data/mudhelpers"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// isInt is true for the signed types, they are decoded as unsigned numbers
+func isInt(schemaType mudhelpers.SchemaType) bool {
+	return schemaType >= mudhelpers.INT8 && schemaType <= mudhelpers.INT256
+}
+
+// signed applies the two's complement of the int types
+func signed(schemaType mudhelpers.SchemaType, value big.Int) *big.Int {
+	ret := new(big.Int).Set(&value)
+	if !isInt(schemaType) {
+		return ret
+	}
+	bits := uint(schemaType-mudhelpers.UINT256) * 8
+	if ret.BitLen() == int(bits) {
+		ret.Sub(ret, new(big.Int).Lsh(big.NewInt(1), bits))
+	}
+	return ret
+}
+
+// jsonValue is the value of an array element
+func jsonValue(schemaType mudhelpers.SchemaType, field data.FieldData) (interface{}, error) {
+	switch v := field.(type) {
+	case data.UintField:
+		return json.Number(v.Data.String()), nil
+	case data.IntField:
+		return json.Number(signed(schemaType, v.Data).String()), nil
+	case data.BoolField:
+		return v.Data, nil
+	case data.AddressField:
+		return strings.ToLower(v.Data.Hex()), nil
+	case data.BytesField:
+		return hexutil.Encode(v.Data), nil
+	case data.StringField:
+		return v.Data, nil
+	}
+	return nil, fmt.Errorf("unsupported array element %T", field)
+}
+
+// numberValue uses strings for the numbers that do not fit in the sql integers
+func numberValue(schemaType mudhelpers.SchemaType, number *big.Int) interface{} {
+	if columnType := mudhelpers.SchemaTypeToPostgresType(schemaType); columnType == "integer" || columnType == "bigint" {
+		return number.Int64()
+	}
+	return number.String()
+}
+
+// columnValue converts the field to the value of its column, see mudhelpers.SchemaTypeToPostgresType
+func columnValue(schemaType mudhelpers.SchemaType, field data.FieldData) (interface{}, error) {
+	if field == nil {
+		return nil, nil
+	}
+	switch v := field.(type) {
+	case data.UintField:
+		return numberValue(schemaType, signed(schemaType, v.Data)), nil
+	case data.IntField:
+		return numberValue(schemaType, signed(schemaType, v.Data)), nil
+	case data.BoolField:
+		return v.Data, nil
+	case data.AddressField:
+		return strings.ToLower(v.Data.Hex()), nil
+	case data.BytesField:
+		return hexutil.Encode(v.Data), nil
+	case data.StringField:
+		return v.Data, nil
+	case data.ArrayField:
+		elements := make([]interface{}, 0, len(v.Data))
+		for _, element := range v.Data {
+			value, err := jsonValue(schemaType-98, element)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, value)
+		}
+		encoded, err := json.Marshal(elements)
+		if err != nil {
+			return nil, err
+		}
+		return string(encoded), nil
+	}
+	return nil, fmt.Errorf("unsupported field %T", field)
+}
+
+// keyValues decodes the key tuple, each element uses 32 bytes like in the store events
+func keyValues(schema *mudhelpers.SchemaTypePair, key string) ([]interface{}, error) {
+	types := schemaTypes(schema)
+	encoded, err := hexutil.Decode(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %w", key, err)
+	}
+	if len(encoded) != len(types)*32 {
+		return nil, fmt.Errorf("the key %s has %d bytes, expected %d", key, len(encoded), len(types)*32)
+	}
+
+	ret := make([]interface{}, 0, len(types))
+	for i, schemaType := range types {
+		word := encoded[i*32 : (i+1)*32]
+		length := mudhelpers.GetStaticByteLength(schemaType)
+		if length == 0 || length > 32 {
+			return nil, fmt.Errorf("unsupported key type %s", schemaType.String())
+		}
+		// The bytes are left aligned, the numbers, bools and addresses are right aligned
+		offset := 32 - length
+		if schemaType >= mudhelpers.BYTES1 && schemaType <= mudhelpers.BYTES32 {
+			offset = 0
+		}
+		value, err := columnValue(schemaType, data.BytesToStaticField(schemaType, word, offset))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, value)
+	}
+	return ret, nil
+}
+
+// schemaTypes copies the types, Flatten may share the static slice
+func schemaTypes(schema *mudhelpers.SchemaTypePair) []mudhelpers.SchemaType {
+	ret := make([]mudhelpers.SchemaType, 0, len(schema.Static)+len(schema.Dynamic))
+	ret = append(ret, schema.Static...)
+	return append(ret, schema.Dynamic...)
+}